@@ -1,24 +1,242 @@
 package lambda_deploy
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"gopkg.in/yaml.v2"
 	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/aws"
 )
 
-func SetupLambdaClient(profile, region string) (*lambda.Lambda) {
+// The lambda operations used by this package. It is a subset of
+// lambdaiface.LambdaAPI, so the client returned by SetupLambdaClient, any
+// lambdaiface.LambdaAPI and the fake in package lambdatest can all be used.
+type LambdaAPI interface {
+	CreateFunction(*lambda.CreateFunctionInput) (*lambda.FunctionConfiguration, error)
+	GetFunction(*lambda.GetFunctionInput) (*lambda.GetFunctionOutput, error)
+	GetFunctionConfiguration(*lambda.GetFunctionConfigurationInput) (*lambda.FunctionConfiguration, error)
+	UpdateFunctionCode(*lambda.UpdateFunctionCodeInput) (*lambda.FunctionConfiguration, error)
+	UpdateFunctionConfiguration(*lambda.UpdateFunctionConfigurationInput) (*lambda.FunctionConfiguration, error)
+	DeleteFunction(*lambda.DeleteFunctionInput) (*lambda.DeleteFunctionOutput, error)
+	ListFunctions(*lambda.ListFunctionsInput) (*lambda.ListFunctionsOutput, error)
+	ListFunctionsPages(*lambda.ListFunctionsInput, func(*lambda.ListFunctionsOutput, bool) bool) error
+	Invoke(*lambda.InvokeInput) (*lambda.InvokeOutput, error)
+	GetAccountSettings(*lambda.GetAccountSettingsInput) (*lambda.GetAccountSettingsOutput, error)
+	PublishVersion(*lambda.PublishVersionInput) (*lambda.FunctionConfiguration, error)
+	ListVersionsByFunction(*lambda.ListVersionsByFunctionInput) (*lambda.ListVersionsByFunctionOutput, error)
+	GetAlias(*lambda.GetAliasInput) (*lambda.AliasConfiguration, error)
+	CreateAlias(*lambda.CreateAliasInput) (*lambda.AliasConfiguration, error)
+	UpdateAlias(*lambda.UpdateAliasInput) (*lambda.AliasConfiguration, error)
+	ListAliases(*lambda.ListAliasesInput) (*lambda.ListAliasesOutput, error)
+	ListTags(*lambda.ListTagsInput) (*lambda.ListTagsOutput, error)
+	TagResource(*lambda.TagResourceInput) (*lambda.TagResourceOutput, error)
+	UntagResource(*lambda.UntagResourceInput) (*lambda.UntagResourceOutput, error)
+	GetPolicy(*lambda.GetPolicyInput) (*lambda.GetPolicyOutput, error)
+	GetFunctionConcurrency(*lambda.GetFunctionConcurrencyInput) (*lambda.GetFunctionConcurrencyOutput, error)
+	ListEventSourceMappings(*lambda.ListEventSourceMappingsInput) (*lambda.ListEventSourceMappingsOutput, error)
+}
+
+var _ LambdaAPI = lambdaiface.LambdaAPI(nil)
+
+// Settings for the AWS client. They can be given as command line flags, in
+// the aws: block of a descriptor or in the aws: block of a tool config file.
+type ClientConfig struct {
+	Profile         string
+	Region          string
+	Endpoint_url    string // e.g. a local stand-in for lambda, or a VPC endpoint
+	Ca_bundle       string // path to a PEM file with extra CA certificates
+	Proxy           string // URL of a HTTP(S) proxy
+	Request_timeout int    // seconds, 0 means no timeout
+	Max_retries     *int
+
+	// When set, the credentials from the profile are used to assume this
+	// role, and the client uses the role's credentials instead.
+	Assume_role_arn   string
+	External_id       string
+	Role_session_name string // defaults to lambdatool-<unix time>
+	Session_duration  int    // seconds, 0 means the STS default (1 hour)
+	Mfa_serial        string // serial or ARN of the MFA device, if the role requires it
+	Mfa_token         string // prompted for on stdin when Mfa_serial is set and this is not
+}
+
+// Fills in every setting that is not set in c from other, so settings with
+// higher precedence should be merged first.
+func (c *ClientConfig) Merge(other *ClientConfig) {
+	if other == nil {
+		return
+	}
+	if c.Profile == "" {
+		c.Profile = other.Profile
+	}
+	if c.Region == "" {
+		c.Region = other.Region
+	}
+	if c.Endpoint_url == "" {
+		c.Endpoint_url = other.Endpoint_url
+	}
+	if c.Ca_bundle == "" {
+		c.Ca_bundle = other.Ca_bundle
+	}
+	if c.Proxy == "" {
+		c.Proxy = other.Proxy
+	}
+	if c.Request_timeout == 0 {
+		c.Request_timeout = other.Request_timeout
+	}
+	if c.Max_retries == nil {
+		c.Max_retries = other.Max_retries
+	}
+	if c.Assume_role_arn == "" {
+		c.Assume_role_arn = other.Assume_role_arn
+	}
+	if c.External_id == "" {
+		c.External_id = other.External_id
+	}
+	if c.Role_session_name == "" {
+		c.Role_session_name = other.Role_session_name
+	}
+	if c.Session_duration == 0 {
+		c.Session_duration = other.Session_duration
+	}
+	if c.Mfa_serial == "" {
+		c.Mfa_serial = other.Mfa_serial
+	}
+	if c.Mfa_token == "" {
+		c.Mfa_token = other.Mfa_token
+	}
+}
+
+// Reads the aws: block from a descriptor or a tool config file.
+func LoadClientConfigFile(filename string) (*ClientConfig, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	parent := struct{ Aws ClientConfig }{}
+	if err := yaml.Unmarshal(data, &parent); err != nil {
+		return nil, fmt.Errorf("Unable to parse %q: %s", filename, err)
+	}
+	return &parent.Aws, nil
+}
+
+// Creates an AWS session from the settings, with the shared config
+// (~/.aws/config) enabled. Nil means all defaults. Endpoint_url is not used
+// here, as it only applies to lambda, see NewLambdaClient. With
+// Assume_role_arn every session assumes the role on its own, so the clients
+// of one run should share a session.
+func NewSession(clientConfig *ClientConfig) (*session.Session, error) {
+	if clientConfig == nil {
+		clientConfig = &ClientConfig{}
+	}
 	config := aws.NewConfig()
-	if region != "" {
-		config = config.WithRegion(region)
+	if clientConfig.Region != "" {
+		config = config.WithRegion(clientConfig.Region)
+	}
+	if clientConfig.Max_retries != nil {
+		config = config.WithMaxRetries(*clientConfig.Max_retries)
+	}
+	if clientConfig.Proxy != "" || clientConfig.Request_timeout > 0 || clientConfig.Ca_bundle != "" {
+		httpClient, err := NewHttpClient(clientConfig)
+		if err != nil {
+			return nil, err
+		}
+		config = config.WithHTTPClient(httpClient)
 	}
 	options := session.Options{
 		Config:            *config,
 		SharedConfigState: session.SharedConfigEnable,
+		// for profiles in ~/.aws/config that assume a role with mfa_serial set
+		AssumeRoleTokenProvider: stscreds.StdinTokenProvider,
 	}
-	if profile != "" {
-		options.Profile = profile
+	if clientConfig.Profile != "" {
+		options.Profile = clientConfig.Profile
 	}
 	sess, err := session.NewSessionWithOptions(options)
-	check(err)
+	if err != nil || clientConfig.Assume_role_arn == "" {
+		return sess, err
+	}
+	credentials := stscreds.NewCredentials(sess, clientConfig.Assume_role_arn, assumeRoleOptions(clientConfig))
+	return sess.Copy(aws.NewConfig().WithCredentials(credentials)), nil
+}
+
+func assumeRoleOptions(clientConfig *ClientConfig) func(*stscreds.AssumeRoleProvider) {
+	return func(provider *stscreds.AssumeRoleProvider) {
+		provider.RoleSessionName = clientConfig.Role_session_name
+		if provider.RoleSessionName == "" {
+			provider.RoleSessionName = "lambdatool-" + strconv.FormatInt(time.Now().Unix(), 10)
+		}
+		if clientConfig.External_id != "" {
+			provider.ExternalID = aws.String(clientConfig.External_id)
+		}
+		if clientConfig.Session_duration > 0 {
+			provider.Duration = time.Duration(clientConfig.Session_duration) * time.Second
+		}
+		if clientConfig.Mfa_serial != "" {
+			provider.SerialNumber = aws.String(clientConfig.Mfa_serial)
+			if clientConfig.Mfa_token != "" {
+				provider.TokenCode = aws.String(clientConfig.Mfa_token)
+			} else {
+				provider.TokenProvider = stscreds.StdinTokenProvider
+			}
+		}
+	}
+}
+
+// Creates a HTTP client with the proxy, CA bundle and request timeout of the
+// settings. NewSession uses it for the AWS clients; code downloads from the
+// presigned locations of GetFunction need one as well.
+func NewHttpClient(clientConfig *ClientConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if clientConfig.Proxy != "" {
+		proxyUrl, err := url.Parse(clientConfig.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxy %q: %s", clientConfig.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+	if clientConfig.Ca_bundle != "" {
+		bundle, err := loadFileContent(clientConfig.Ca_bundle)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load CA bundle %q: %s", clientConfig.Ca_bundle, err)
+		}
+		// the bundle has extra certificates, the system ones are still trusted
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("Unable to load CA bundle %q: no certificates found", clientConfig.Ca_bundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(clientConfig.Request_timeout) * time.Second,
+	}, nil
+}
+
+// Creates a lambda client from a session made by NewSession, using the
+// Endpoint_url of the settings if it is set.
+func NewLambdaClient(sess *session.Session, clientConfig *ClientConfig) *lambda.Lambda {
+	if clientConfig != nil && clientConfig.Endpoint_url != "" {
+		return lambda.New(sess, aws.NewConfig().WithEndpoint(clientConfig.Endpoint_url))
+	}
 	return lambda.New(sess)
 }
+
+func SetupLambdaClient(clientConfig *ClientConfig) (*lambda.Lambda, error) {
+	sess, err := NewSession(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	return NewLambdaClient(sess, clientConfig), nil
+}
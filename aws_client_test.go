@@ -0,0 +1,115 @@
+package lambda_deploy
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+)
+
+func TestLoadClientConfigFile(t *testing.T) {
+	config, err := LoadClientConfigFile("./testdata/descriptors/aws-descriptor.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-west-1", config.Region)
+	assert.Equal(t, "http://localhost:9001", config.Endpoint_url)
+	assert.Equal(t, 30, config.Request_timeout)
+	assert.Equal(t, 0, *config.Max_retries)
+}
+
+func TestLoadClientConfigFileWithoutAwsBlock(t *testing.T) {
+	config, err := LoadClientConfigFile("./testdata/test1/lambda-desc.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, ClientConfig{}, *config)
+}
+
+func TestMergeClientConfig(t *testing.T) {
+	retries := 5
+	config := ClientConfig{Region: "us-east-1"}
+	config.Merge(&ClientConfig{Region: "eu-west-1", Profile: "prod", Max_retries: &retries})
+	assert.Equal(t, "us-east-1", config.Region)
+	assert.Equal(t, "prod", config.Profile)
+	assert.Equal(t, 5, *config.Max_retries)
+}
+
+func TestNewSessionWithEndpoint(t *testing.T) {
+	retries := 1
+	sess, err := NewSession(&ClientConfig{
+		Region: "eu-west-1",
+		Endpoint_url: "http://localhost:9001",
+		Proxy: "http://proxy.example.com:3128",
+		Request_timeout: 10,
+		Max_retries: &retries,
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, sess.Config.Endpoint, "the endpoint is only for lambda")
+	assert.Equal(t, 1, *sess.Config.MaxRetries)
+	assert.Equal(t, 10.0, sess.Config.HTTPClient.Timeout.Seconds())
+}
+
+func TestSetupLambdaClientWithEndpoint(t *testing.T) {
+	client, err := SetupLambdaClient(&ClientConfig{Region: "eu-west-1", Endpoint_url: "http://localhost:9001"})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:9001", client.Endpoint)
+}
+
+func TestNewSessionBadCaBundle(t *testing.T) {
+	_, err := NewSession(&ClientConfig{Region: "eu-west-1", Ca_bundle: "./testdata/missing.pem"})
+	assert.Error(t, err)
+}
+
+func TestNewHttpClientTrustsCaBundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("code"))
+	}))
+	defer server.Close()
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644))
+
+	client, err := NewHttpClient(&ClientConfig{})
+	assert.NoError(t, err)
+	_, err = downloadCode(client, server.URL)
+	assert.Error(t, err, "the certificate of the server is not trusted without the bundle")
+
+	client, err = NewHttpClient(&ClientConfig{Ca_bundle: bundle, Request_timeout: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, client.Timeout)
+	code, err := downloadCode(client, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "code", string(code))
+
+	_, err = NewHttpClient(&ClientConfig{Ca_bundle: "./testdata/descriptors/vpc-descriptor.yml"})
+	assert.EqualError(t, err, `Unable to load CA bundle "./testdata/descriptors/vpc-descriptor.yml": no certificates found`)
+}
+
+func TestAssumeRoleOptions(t *testing.T) {
+	provider := stscreds.AssumeRoleProvider{}
+	assumeRoleOptions(&ClientConfig{
+		Assume_role_arn: "arn:aws:iam::123456789012:role/deploy",
+		External_id: "pipeline",
+		Session_duration: 900,
+		Mfa_serial: "arn:aws:iam::123456789012:mfa/me",
+	})(&provider)
+	assert.Contains(t, provider.RoleSessionName, "lambdatool-")
+	assert.Equal(t, "pipeline", *provider.ExternalID)
+	assert.Equal(t, 15*time.Minute, provider.Duration)
+	assert.Equal(t, "arn:aws:iam::123456789012:mfa/me", *provider.SerialNumber)
+	assert.NotNil(t, provider.TokenProvider)
+}
+
+func TestAssumeRoleOptionsWithToken(t *testing.T) {
+	provider := stscreds.AssumeRoleProvider{}
+	assumeRoleOptions(&ClientConfig{
+		Role_session_name: "ci-build-42",
+		Mfa_serial: "arn:aws:iam::123456789012:mfa/me",
+		Mfa_token: "123456",
+	})(&provider)
+	assert.Equal(t, "ci-build-42", provider.RoleSessionName)
+	assert.Nil(t, provider.ExternalID)
+	assert.Equal(t, "123456", *provider.TokenCode)
+	assert.Nil(t, provider.TokenProvider)
+}
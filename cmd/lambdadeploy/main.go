@@ -5,19 +5,74 @@ import (
 	"fmt"
 	"os"
 	"github.com/pbthorste/aws-lambda-tool"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+	"net/http"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/mitchellh/go-homedir"
 	"errors"
+	"encoding/json"
+	"io"
+	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
 )
 var (
 	version string
 )
 
-func check(e error) {
-	if e != nil {
-		panic(e)
-	}
+const defaultToolConfig = "~/.lambdatool.yml"
+const defaultHistoryFile = "~/.lambdatool-history.jsonl"
+
+// selects the overlay under stages: in the descriptor
+var stageFlag = cli.StringFlag{
+	Name: "stage",
+	Usage: "`Stage` overlay in the descriptor to apply, e.g. prod (optional)",
+	EnvVar: "LAMBDATOOL_STAGE",
+}
+
+// values for ${var:name} references in the descriptor
+var varFlag = cli.StringSliceFlag{
+	Name: "var",
+	Usage: "`name=value` for ${var:name} references in the descriptor, can be repeated (optional)",
+}
+var varsFileFlag = cli.StringFlag{
+	Name: "vars-file",
+	Usage: "yaml `file` with values for ${var:name} references, --var takes precedence (optional)",
+}
+
+// selects functions from a descriptor with several functions
+var functionFlag = cli.StringSliceFlag{
+	Name: "function",
+	Usage: "`Name` of a function in the descriptor, can be repeated (optional, default all)",
 }
 
+// how long deploy and wait wait for a function to become ready
+var waitTimeoutFlag = cli.DurationFlag{
+	Name: "wait-timeout",
+	Value: lambda_deploy.DefaultWaitTimeout,
+	Usage: "How long to wait for the function to become ready after a change, e.g. 90s or 10m",
+}
+
+// exit codes, these are part of the interface of the tool and should not change
+const (
+	exitError              = 1
+	exitUsage              = 2
+	exitChangesPending     = 3 // plan (and deploy --dry-run) when the function would change, drift when it drifted
+	exitInvalidDescriptor  = 4
+	exitFunctionNotFound   = 5
+	exitCodeUploadFailed   = 6
+	exitConfigUpdateFailed = 7
+	exitFunctionNotReady   = 8 // the function failed to become ready, or waiting for it timed out
+	exitRolledBack         = 9 // a check failed while shifting traffic, the alias was moved back
+	exitSmokeTestsFailed   = 10 // the smoke tests of the descriptor failed after deploying
+)
+
 func main() {
 
 	app := cli.NewApp()
@@ -37,18 +92,137 @@ func main() {
 			Name: "profile",
 			Usage: "AWS profile (optional, can be set by env var 'AWS_PROFILE')",
 		},
+		cli.StringFlag{
+			Name: "endpoint-url",
+			Usage: "`URL` of the lambda API, e.g. a local stand-in or a VPC endpoint (optional)",
+		},
+		cli.StringFlag{
+			Name: "ca-bundle",
+			Usage: "`PEM` file with extra CA certificates to trust (optional)",
+		},
+		cli.StringFlag{
+			Name: "proxy",
+			Usage: "`URL` of a HTTP(S) proxy (optional, can also be set by env var 'HTTPS_PROXY')",
+		},
+		cli.IntFlag{
+			Name: "request-timeout",
+			Usage: "timeout for each request to AWS in `seconds` (optional)",
+		},
+		cli.IntFlag{
+			Name: "max-retries",
+			Usage: "maximum number of `retries` for failed requests to AWS (optional)",
+		},
+		cli.StringFlag{
+			Name: "assume-role-arn",
+			Usage: "`ARN` of an IAM role to assume before talking to lambda (optional)",
+		},
+		cli.StringFlag{
+			Name: "external-id",
+			Usage: "external `ID` to pass when assuming the role (optional)",
+		},
+		cli.StringFlag{
+			Name: "role-session-name",
+			Usage: "session `name` to use when assuming the role (optional, default lambdatool-<time>)",
+		},
+		cli.IntFlag{
+			Name: "session-duration",
+			Usage: "duration of the assumed role session in `seconds` (optional)",
+		},
+		cli.StringFlag{
+			Name: "mfa-serial",
+			Usage: "`serial` or ARN of the MFA device required by the role, the token is prompted for (optional)",
+		},
+		cli.StringFlag{
+			Name: "mfa-token",
+			Usage: "MFA `token` code, instead of prompting for it (optional)",
+		},
+		cli.StringFlag{
+			Name: "config",
+			Usage: "tool config `file` with default AWS settings (optional, default ~/.lambdatool.yml)",
+			EnvVar: "LAMBDATOOL_CONFIG",
+		},
+		cli.StringFlag{
+			Name: "history-file",
+			Value: defaultHistoryFile,
+			Usage: "`file` where deploys and rollbacks are recorded, used by rollback",
+			EnvVar: "LAMBDATOOL_HISTORY_FILE",
+		},
+		cli.StringFlag{
+			Name: "history-store",
+			Value: "file",
+			Usage: "where to keep the deploy history: file (see --history-file), s3://bucket/key, tags (on the function) or none",
+			EnvVar: "LAMBDATOOL_HISTORY_STORE",
+		},
+		cli.StringFlag{
+			Name: "output",
+			Value: outputText,
+			Usage: "`format` of the output: text, json, yaml or table; with json and yaml progress messages go to standard error",
+			EnvVar: "LAMBDATOOL_OUTPUT",
+		},
 	}
+	app.ErrWriter = os.Stderr
+	app.Before = setupOutput
 	app.Commands = []cli.Command{
 		{
 			Name:    "list",
 			Usage:   "list lambda functions",
+			Flags:   []cli.Flag{
+				cli.StringFlag{
+					Name: "name-prefix",
+					Usage: "Only functions with names starting with `PREFIX`",
+				},
+				cli.StringFlag{
+					Name: "name-regex",
+					Usage: "Only functions with names matching `REGEX`",
+				},
+				cli.StringFlag{
+					Name: "runtime",
+					Usage: "Only functions with runtime `RUNTIME`, e.g. nodejs18.x; without a version, e.g. python, any version of it",
+				},
+				cli.StringSliceFlag{
+					Name: "tag",
+					Usage: "Only functions with tag `KEY=VALUE`, or KEY with any value (can be repeated)",
+				},
+				cli.StringFlag{
+					Name: "modified-since",
+					Usage: "Only functions modified since `TIME`: a date, a RFC 3339 time or a duration like 36h",
+				},
+				cli.StringFlag{
+					Name: "sort",
+					Value: "name",
+					Usage: "Sort by `FIELD`: name, runtime, memory, timeout or last-modified",
+				},
+				cli.BoolFlag{
+					Name: "reverse",
+					Usage: "Reverse the sort order",
+				},
+				cli.BoolFlag{
+					Name: "all-regions",
+					Usage: "List the functions of all regions",
+				},
+			},
 			Action:  func(c *cli.Context) error {
-				if !c.GlobalBool("noheader") {
-					fmt.Println("Installed lambdas\n----------------------")
+				options, err := listOptions(c)
+				if err != nil {
+					return cli.NewExitError(err, exitUsage)
+				}
+				functions, err := listFunctions(c, options)
+				if functions == nil {
+					return toExitError(err)
+				}
+				if structuredOutput(c) {
+					if printErr := printDocument(c, functions); printErr != nil {
+						return printErr
+					}
+				} else {
+					if !c.GlobalBool("noheader") && c.GlobalString("output") == outputText {
+						fmt.Fprintln(c.App.Writer, "Installed lambdas\n----------------------")
+					}
+					printFunctions(c.App.Writer, functions, !c.GlobalBool("noheader"), c.Bool("all-regions"))
+				}
+				if err != nil {
+					return toExitError(err)
 				}
-				client := lambda_deploy.SetupLambdaClient(c.GlobalString("profile"), c.GlobalString("region"))
-				lambdas := lambda_deploy.ListLambdas(client)
-				fmt.Println(lambdas)
 				return nil
 			},
 		},
@@ -58,64 +232,514 @@ func main() {
 			Flags:   []cli.Flag{
 				cli.StringFlag{
 					Name: "name, n",
-					Usage: "`Name` of lambda function (required)",
+					Usage: "`Name` of lambda function (can not be used with descriptor)",
+
+				},
+				cli.StringSliceFlag{
+					Name: "descriptor, d",
+					Usage: "`Descriptor` with the lambda functions to delete (can not be used with name, can be repeated)",
 
 				},
+				stageFlag,
+				varFlag,
+				varsFileFlag,
+				functionFlag,
 			},
 			Action:  func (c *cli.Context) error {
-				name, err := checkRequiredArg("name", c.String("name"))
+				if onlyOne, err := thereMustBeOnlyOne("descriptor", strings.Join(c.StringSlice("descriptor"), ","), "name", c.String("name")); !onlyOne {
+					return cli.NewExitError(err, exitUsage)
+				}
+				functionNames, descriptorConfig, err := getFunctionNames(c)
 				if err != nil {
-					return cli.NewExitError(err, 2)
+					return toExitError(err)
 				}
-				if !c.GlobalBool("noheader") {
-					fmt.Println("Deleting lambda: " + name + "\n----------------------")
+				client, err := setupClient(c, descriptorConfig)
+				if err != nil {
+					return toExitError(err)
 				}
-				client := lambda_deploy.SetupLambdaClient(c.GlobalString("profile"), c.GlobalString("region"))
-				lambda_deploy.DeleteLambda(client, name)
-				fmt.Println("Lambda function has been deleted")
-				return nil
+				deleted := make([]deleteResult, 0, len(functionNames))
+				for _, name := range functionNames {
+					if !c.GlobalBool("noheader") {
+						fmt.Fprintln(progressOut(c), "Deleting lambda: " + name + "\n----------------------")
+					}
+					if err := lambda_deploy.DeleteLambda(client, name); err != nil {
+						return printPartial(c, deleted, toExitError(err))
+					}
+					fmt.Fprintln(progressOut(c), "Lambda function has been deleted")
+					deleted = append(deleted, deleteResult{FunctionName: name, Deleted: true})
+				}
+				return printPartial(c, deleted, nil)
 			},
 		},
 		{
 			Name: "deploy",
 			Usage: "Deploy a lambda function using a descriptor",
 			Flags:   []cli.Flag{
+				cli.StringSliceFlag{
+					Name: "descriptor, d",
+					Usage: "`Descriptor` for the lambda function (required, can be repeated to layer descriptors)",
+
+				},
+				stageFlag,
+				varFlag,
+				varsFileFlag,
+				cli.StringFlag{
+					Name: "zip-file, z",
+					Usage: "`ZIP-File` containing the lambda function (required, unless the descriptor has a source: block)",
+
+				},
+				cli.BoolFlag{
+					Name: "dry-run",
+					Usage: "Show what would change (like plan) without deploying",
+				},
 				cli.StringFlag{
+					Name: "s3-bucket",
+					Usage: "Stage the zip in `BUCKET` instead of uploading it directly, for functions without a code: block",
+					EnvVar: "LAMBDATOOL_S3_BUCKET",
+				},
+				waitTimeoutFlag,
+				functionFlag,
+				cli.StringFlag{
+					Name: "alias",
+					Usage: "Publish a version and shift the traffic of `ALIAS` to it following the strategy",
+				},
+				cli.StringFlag{
+					Name: "strategy",
+					Value: "allatonce",
+					Usage: "`STRATEGY` for shifting the alias: canary<N>percent<M>minutes, linear<N>percentevery<M>minutes or allatonce",
+				},
+				cli.StringFlag{
+					Name: "health-check",
+					Usage: "Invoke the new version with `PAYLOAD` before and during the shift, rolling back when it fails",
+				},
+				cli.StringFlag{
+					Name: "check-command",
+					Usage: "Run `COMMAND` before and during the shift, rolling back when it exits non-zero",
+				},
+				cli.BoolFlag{
+					Name: "skip-smoke-tests",
+					Usage: "Do not run the smoke tests of the descriptors after deploying",
+				},
+			},
+			Action:  func (c *cli.Context) error {
+				_, err := checkRequiredArg("descriptor", strings.Join(c.StringSlice("descriptor"), ","))
+				if err != nil {
+					return cli.NewExitError(err, exitUsage)
+				}
+				shift, err := shiftOptions(c)
+				if err != nil {
+					return cli.NewExitError(err, exitUsage)
+				}
+				lambdaDesc, functions, err := loadFunctions(c)
+				if err != nil {
+					return toExitError(err)
+				}
+				sess, err := setupSession(c, lambdaDesc.Aws)
+				if err != nil {
+					return toExitError(err)
+				}
+				if err := resolveSecrets(sess, functions); err != nil {
+					return toExitError(err)
+				}
+				buildDir, err := ioutil.TempDir("", "lambdatool")
+				if err != nil {
+					return toExitError(err)
+				}
+				defer os.RemoveAll(buildDir)
+				zipfiles, err := zipFiles(c, functions, buildDir)
+				if err != nil {
+					return toExitError(err)
+				}
+				client := sess.lambdaClient()
+				if c.Bool("dry-run") {
+					return showPlan(c, client, functions, zipfiles)
+				}
+				options, err := deployOptions(c, sess, client, functions)
+				if err != nil {
+					return toExitError(err)
+				}
+				options.Shift = shift
+				options.SkipSmokeTests = c.Bool("skip-smoke-tests")
+				results := make([]*lambda_deploy.DeployResult, 0, len(functions))
+				for i, lambdaDesc := range functions {
+					if !c.GlobalBool("noheader") {
+						fmt.Fprintln(progressOut(c), "Deploying lambda: " + lambdaDesc.Function_name + "\n----------------------")
+					}
+					result, err := lambda_deploy.LambdaDeployWithOptions(client, zipfiles[i], lambdaDesc, options)
+					if err != nil {
+						return printDeployResults(c, results, toExitError(err))
+					}
+					fmt.Fprintln(progressOut(c), "Lambda function deployed successfully")
+					results = append(results, result)
+				}
+				return printDeployResults(c, results, nil)
+			},
+
+		},
+		{
+			Name: "package",
+			Usage: "Build the zip for a lambda function from the source: block of the descriptor",
+			Flags:   []cli.Flag{
+				cli.StringSliceFlag{
 					Name: "descriptor, d",
-					Usage: "`Descriptor` for the lambda function (required)",
+					Usage: "`Descriptor` for the lambda function (required, can be repeated to layer descriptors)",
 
 				},
+				stageFlag,
+				varFlag,
+				varsFileFlag,
+				functionFlag,
+				cli.StringFlag{
+					Name: "output-dir, o",
+					Value: ".",
+					Usage: "`Directory` to write <function_name>.zip to",
+				},
+			},
+			Action:  func (c *cli.Context) error {
+				_, err := checkRequiredArg("descriptor", strings.Join(c.StringSlice("descriptor"), ","))
+				if err != nil {
+					return cli.NewExitError(err, exitUsage)
+				}
+				_, functions, err := loadFunctions(c)
+				if err != nil {
+					return toExitError(err)
+				}
+				packaged := make([]packageResult, 0, len(functions))
+				for _, lambdaDesc := range functions {
+					if lambdaDesc.Source == nil {
+						return printPartial(c, packaged, cli.NewExitError("Error: there is no source: block for " + lambdaDesc.Function_name, exitUsage))
+					}
+					zipfile := filepath.Join(c.String("output-dir"), lambdaDesc.Function_name + ".zip")
+					if err := lambda_deploy.BuildZip(lambdaDesc.Source, zipfile); err != nil {
+						return printPartial(c, packaged, toExitError(err))
+					}
+					result := packageResult{FunctionName: lambdaDesc.Function_name, ZipFile: zipfile, CodeSha256: lambda_deploy.Base64sha256(zipfile)}
+					if !structuredOutput(c) {
+						fmt.Fprintf(c.App.Writer, "%v (CodeSha256: %v)\n", result.ZipFile, result.CodeSha256)
+					}
+					packaged = append(packaged, result)
+				}
+				return printPartial(c, packaged, nil)
+			},
+		},
+		{
+			Name: "plan",
+			Usage: "Show what deploy would change, without changing anything",
+			Flags:   []cli.Flag{
+				cli.StringSliceFlag{
+					Name: "descriptor, d",
+					Usage: "`Descriptor` for the lambda function (required, can be repeated to layer descriptors)",
+
+				},
+				stageFlag,
+				varFlag,
+				varsFileFlag,
 				cli.StringFlag{
 					Name: "zip-file, z",
-					Usage: "`ZIP-File` containing the lambda function (required)",
+					Usage: "`ZIP-File` containing the lambda function (required, unless the descriptor has a source: block)",
 
 				},
+				functionFlag,
+			},
+			Action:  func (c *cli.Context) error {
+				_, err := checkRequiredArg("descriptor", strings.Join(c.StringSlice("descriptor"), ","))
+				if err != nil {
+					return cli.NewExitError(err, exitUsage)
+				}
+				lambdaDesc, functions, err := loadFunctions(c)
+				if err != nil {
+					return toExitError(err)
+				}
+				sess, err := setupSession(c, lambdaDesc.Aws)
+				if err != nil {
+					return toExitError(err)
+				}
+				if err := resolveSecrets(sess, functions); err != nil {
+					return toExitError(err)
+				}
+				buildDir, err := ioutil.TempDir("", "lambdatool")
+				if err != nil {
+					return toExitError(err)
+				}
+				defer os.RemoveAll(buildDir)
+				zipfiles, err := zipFiles(c, functions, buildDir)
+				if err != nil {
+					return toExitError(err)
+				}
+				return showPlan(c, sess.lambdaClient(), functions, zipfiles)
+			},
+		},
+		{
+			Name: "drift",
+			Usage: "Report where the live functions differ from their descriptors",
+			Flags:   []cli.Flag{
+				cli.StringSliceFlag{
+					Name: "descriptor, d",
+					Usage: "`Descriptor` for the lambda function (required, can be repeated to layer descriptors)",
+				},
+				stageFlag,
+				varFlag,
+				varsFileFlag,
+				cli.StringFlag{
+					Name: "zip-file, z",
+					Usage: "`ZIP-File` to compare the code with, without it only functions with a source: block or an image have their code checked",
+				},
+				functionFlag,
+				cli.BoolFlag{
+					Name: "json",
+					Usage: "Deprecated, use --output json",
+				},
+			},
+			Action:  func (c *cli.Context) error {
+				_, err := checkRequiredArg("descriptor", strings.Join(c.StringSlice("descriptor"), ","))
+				if err != nil {
+					return cli.NewExitError(err, exitUsage)
+				}
+				if c.Bool("json") {
+					fmt.Fprintln(c.App.ErrWriter, "Warning: --json is deprecated, use --output json")
+				}
+				lambdaDesc, functions, err := loadFunctions(c)
+				if err != nil {
+					return toExitError(err)
+				}
+				sess, err := setupSession(c, lambdaDesc.Aws)
+				if err != nil {
+					return toExitError(err)
+				}
+				if err := resolveSecrets(sess, functions); err != nil {
+					return toExitError(err)
+				}
+				buildDir, err := ioutil.TempDir("", "lambdatool")
+				if err != nil {
+					return toExitError(err)
+				}
+				defer os.RemoveAll(buildDir)
+				zipfiles := make([]string, 0, len(functions))
+				for _, function := range functions {
+					// the code of functions without a zip is left unchecked
+					if c.String("zip-file") == "" && function.Source == nil && !function.IsImage() {
+						zipfiles = append(zipfiles, "")
+						continue
+					}
+					zipfile, err := zipFiles(c, []*lambda_deploy.LambdaFunctionDesc{function}, buildDir)
+					if err != nil {
+						return toExitError(err)
+					}
+					zipfiles = append(zipfiles, zipfile[0])
+				}
+				return showDrift(c, sess.lambdaClient(), functions, zipfiles)
+			},
+		},
+		{
+			Name: "export",
+			Usage: "Write a descriptor for an existing lambda function",
+			Flags:   []cli.Flag{
+				cli.StringFlag{
+					Name: "name, n",
+					Usage: "`Name` of the lambda function (required)",
+				},
+				cli.StringFlag{
+					Name: "out, o",
+					Usage: "`File` to write the descriptor to, default standard output",
+				},
+				cli.BoolFlag{
+					Name: "with-code",
+					Usage: "Download the code of the function too",
+				},
+				cli.StringFlag{
+					Name: "code-file",
+					Usage: "`ZIP-File` to download the code to, default <name>.zip",
+				},
+				cli.BoolFlag{
+					Name: "redact",
+					Usage: "Write ${env:NAME} references instead of the values of environment variables",
+				},
 			},
 			Action:  func (c *cli.Context) error {
-				descriptor, err := checkRequiredArg("descriptor", c.String("descriptor"))
+				functionName, err := checkRequiredArg("name", c.String("name"))
+				if err != nil {
+					return cli.NewExitError(err, exitUsage)
+				}
+				sess, err := setupSession(c, nil)
 				if err != nil {
-					return cli.NewExitError(err, 2)
+					return toExitError(err)
 				}
-				zipfile, err    := checkRequiredArg("zip-file", c.String("zip-file"))
+				descriptor, function, err := lambda_deploy.ExportFunction(sess.lambdaClient(), functionName)
 				if err != nil {
-					return cli.NewExitError(err, 2)
+					return toExitError(err)
+				}
+				out, err := lambda_deploy.ExportYAML(descriptor, c.Bool("redact"))
+				if err != nil {
+					return toExitError(err)
+				}
+				result := exportResult{
+					FunctionName: functionName,
+					Descriptor:   lambda_deploy.ExportDocument(descriptor, c.Bool("redact")),
+					File:         c.String("out"),
+				}
+				if c.String("out") == "" {
+					if !structuredOutput(c) {
+						c.App.Writer.Write(out)
+					}
+				} else if err := ioutil.WriteFile(c.String("out"), out, 0644); err != nil {
+					return toExitError(err)
+				}
+				if c.Bool("with-code") {
+					codeFile := c.String("code-file")
+					if codeFile == "" {
+						codeFile = functionName + ".zip"
+					}
+					httpClient, err := sess.httpClient()
+					if err != nil {
+						return toExitError(err)
+					}
+					if err := lambda_deploy.DownloadFunctionCode(httpClient, function, codeFile); err != nil {
+						return toExitError(err)
+					}
+					fmt.Fprintln(c.App.ErrWriter, "Downloaded the code to", codeFile)
+					result.CodeFile = codeFile
+				}
+				if structuredOutput(c) {
+					return printDocument(c, result)
+				}
+				return nil
+			},
+		},
+		{
+			Name: "download",
+			Usage: "Download the deployed code of a lambda function",
+			Flags:   []cli.Flag{
+				cli.StringFlag{
+					Name: "name, n",
+					Usage: "`Name` of the lambda function (required)",
+				},
+				cli.StringFlag{
+					Name: "qualifier, q",
+					Usage: "`Version` or alias to download, default $LATEST",
+				},
+				cli.StringFlag{
+					Name: "out, o",
+					Usage: "`ZIP-File` to write the code to, default <name>.zip",
+				},
+				cli.StringFlag{
+					Name: "extract, x",
+					Usage: "Also extract the code into `DIR`",
+				},
+			},
+			Action:  func (c *cli.Context) error {
+				functionName, err := checkRequiredArg("name", c.String("name"))
+				if err != nil {
+					return cli.NewExitError(err, exitUsage)
+				}
+				out := c.String("out")
+				if out == "" {
+					out = functionName + ".zip"
+				}
+				sess, err := setupSession(c, nil)
+				if err != nil {
+					return toExitError(err)
+				}
+				httpClient, err := sess.httpClient()
+				if err != nil {
+					return toExitError(err)
+				}
+				config, err := lambda_deploy.DownloadLambda(sess.lambdaClient(), httpClient, functionName, c.String("qualifier"), out)
+				if err != nil {
+					return toExitError(err)
+				}
+				result := downloadResult{
+					FunctionName: functionName,
+					Version:      aws.StringValue(config.Version),
+					ZipFile:      out,
+					CodeSha256:   aws.StringValue(config.CodeSha256),
+				}
+				fmt.Fprintf(progressOut(c), "Downloaded version %s of %s to %s (CodeSha256 %s)\n",
+					result.Version, functionName, out, result.CodeSha256)
+				if dir := c.String("extract"); dir != "" {
+					if err := lambda_deploy.ExtractZip(out, dir); err != nil {
+						return toExitError(err)
+					}
+					fmt.Fprintln(progressOut(c), "Extracted to", dir)
+					result.ExtractedTo = dir
+				}
+				if structuredOutput(c) {
+					return printDocument(c, result)
+				}
+				return nil
+			},
+		},
+		{
+			Name: "describe",
+			Usage: "Show everything about a lambda function: configuration, aliases, versions, concurrency, event sources, tags and policy",
+			Flags:   []cli.Flag{
+				cli.StringFlag{
+					Name: "name, n",
+					Usage: "`Name` of lambda function (can not be used with descriptor)",
+				},
+				cli.StringSliceFlag{
+					Name: "descriptor, d",
+					Usage: "`Descriptor` with the lambda functions (can not be used with name, can be repeated)",
+				},
+				stageFlag,
+				varFlag,
+				varsFileFlag,
+				functionFlag,
+				cli.BoolFlag{
+					Name: "show-secrets",
+					Usage: "Show the values of environment variables, which are masked by default",
+				},
+			},
+			Action:  func (c *cli.Context) error {
+				if onlyOne, err := thereMustBeOnlyOne("descriptor", strings.Join(c.StringSlice("descriptor"), ","), "name", c.String("name")); !onlyOne {
+					return cli.NewExitError(err, exitUsage)
+				}
+				functionNames, descriptorConfig, err := getFunctionNames(c)
+				if err != nil {
+					return toExitError(err)
+				}
+				client, err := setupClient(c, descriptorConfig)
+				if err != nil {
+					return toExitError(err)
+				}
+				descriptions := make([]*lambda_deploy.FunctionDescription, 0, len(functionNames))
+				for _, name := range functionNames {
+					description, err := lambda_deploy.DescribeFunction(client, name, c.Bool("show-secrets"))
+					if err != nil {
+						return toExitError(err)
+					}
+					descriptions = append(descriptions, description)
+				}
+				if structuredOutput(c) {
+					return printDocument(c, descriptions)
+				}
+				for i, description := range descriptions {
+					if i > 0 {
+						fmt.Fprintln(c.App.Writer)
+					}
+					fmt.Fprint(c.App.Writer, description)
 				}
-				lambdaDesc := lambda_deploy.LoadDescriptorFile(descriptor)
-				lambda_deploy.LambdaDeploy(c.GlobalString("profile"), c.GlobalString("region"), zipfile, lambdaDesc)
-				fmt.Println("Lambda function deployed successfully")
 				return nil
 			},
-
 		},
 		{
 			Name: "account",
 			Usage: "display account settings",
 			Action: func (c *cli.Context) error {
-				if !c.GlobalBool("noheader") {
-					fmt.Println("Account Settings\n----------------------")
+				if !c.GlobalBool("noheader") && c.GlobalString("output") == outputText {
+					fmt.Fprintln(c.App.Writer, "Account Settings\n----------------------")
+				}
+				client, err := setupClient(c, nil)
+				if err != nil {
+					return toExitError(err)
 				}
-				client := lambda_deploy.SetupLambdaClient(c.GlobalString("profile"), c.GlobalString("region"))
-				fmt.Println(lambda_deploy.LambdaAccountSettings(client))
+				settings, err := lambda_deploy.GetAccountSettings(client)
+				if err != nil {
+					return toExitError(err)
+				}
+				if structuredOutput(c) {
+					return printDocument(c, settings)
+				}
+				printAccountSettings(c.App.Writer, settings)
 				return nil
 			},
 		},
@@ -123,11 +747,14 @@ func main() {
 			Name: "invoke",
 			Usage: "invoke the lambda function synchronously",
 			Flags:   []cli.Flag{
-				cli.StringFlag{
+				cli.StringSliceFlag{
 					Name: "descriptor, d",
-					Usage: "`Descriptor` for the lambda function (can not be used with name)",
+					Usage: "`Descriptor` for the lambda function (can not be used with name, can be repeated)",
 
 				},
+				stageFlag,
+				varFlag,
+				varsFileFlag,
 				cli.StringFlag{
 					Name: "name, n",
 					Usage: "`Name` of the lambda function (can not be used with descriptor)",
@@ -141,28 +768,303 @@ func main() {
 					Name: "file, f",
 					Usage: "`File` containing text to be sent to the lambda function (can not be used with body)",
 				},
+				functionFlag,
 			},
 			Action: func (c *cli.Context) error {
 				body := c.String("body")
 				bodyFile := c.String("file")
-				if onlyOne, err := thereMustBeOnlyOne("descriptor", c.String("descriptor"), "name", c.String("name")); !onlyOne {
-					return cli.NewExitError(err, 2)
+				if onlyOne, err := thereMustBeOnlyOne("descriptor", strings.Join(c.StringSlice("descriptor"), ","), "name", c.String("name")); !onlyOne {
+					return cli.NewExitError(err, exitUsage)
 				}
 				if onlyOne, err := thereCanBeOnlyOne("body", body, "file", bodyFile); !onlyOne {
-					return cli.NewExitError(err, 2)
+					return cli.NewExitError(err, exitUsage)
+				}
+				functionNames, descriptorConfig, err := getFunctionNames(c)
+				if err != nil {
+					return toExitError(err)
 				}
-				functionName := getFunctionName(c.String("descriptor"), c.String("name"))
 				if body == "" && bodyFile != "" {
 					data, err := ioutil.ReadFile(bodyFile)
-					check(err)
+					if err != nil {
+						return toExitError(err)
+					}
 					body = string(data)
 				}
+				client, err := setupClient(c, descriptorConfig)
+				if err != nil {
+					return toExitError(err)
+				}
+				if structuredOutput(c) {
+					var payload []byte
+					if body != "" {
+						payload = []byte(body)
+					}
+					results := make([]*lambda_deploy.InvokeResult, 0, len(functionNames))
+					for _, functionName := range functionNames {
+						output, err := lambda_deploy.InvokeFunction(client, functionName, "", payload)
+						if err != nil {
+							return printPartial(c, results, toExitError(err))
+						}
+						results = append(results, lambda_deploy.NewInvokeResult(functionName, output))
+					}
+					return printPartial(c, results, nil)
+				}
+				for _, functionName := range functionNames {
+					if !c.GlobalBool("noheader") {
+						fmt.Fprintf(c.App.Writer, "Invoking lambda function: %v\n----------------------\n", functionName)
+					}
+					output, err := lambda_deploy.InvokeLambda(client, functionName, body)
+					if err != nil {
+						return toExitError(err)
+					}
+					fmt.Fprintln(c.App.Writer, output)
+				}
+				return nil
+			},
+		},
+		{
+			Name: "versions",
+			Usage: "List the published versions of a lambda function, with their aliases",
+			Flags:   []cli.Flag{
+				cli.StringFlag{
+					Name: "name, n",
+					Usage: "`Name` of lambda function (can not be used with descriptor)",
+				},
+				cli.StringSliceFlag{
+					Name: "descriptor, d",
+					Usage: "`Descriptor` with the lambda functions (can not be used with name, can be repeated)",
+				},
+				stageFlag,
+				varFlag,
+				varsFileFlag,
+				functionFlag,
+			},
+			Action:  func (c *cli.Context) error {
+				if onlyOne, err := thereMustBeOnlyOne("descriptor", strings.Join(c.StringSlice("descriptor"), ","), "name", c.String("name")); !onlyOne {
+					return cli.NewExitError(err, exitUsage)
+				}
+				functionNames, descriptorConfig, err := getFunctionNames(c)
+				if err != nil {
+					return toExitError(err)
+				}
+				client, err := setupClient(c, descriptorConfig)
+				if err != nil {
+					return toExitError(err)
+				}
+				if structuredOutput(c) {
+					documents := make([]versionsDocument, 0, len(functionNames))
+					for _, name := range functionNames {
+						versions, err := lambda_deploy.ListVersions(client, name)
+						if err != nil {
+							return toExitError(err)
+						}
+						documents = append(documents, versionsDocument{FunctionName: name, Versions: versions})
+					}
+					return printDocument(c, documents)
+				}
+				for _, name := range functionNames {
+					versions, err := lambda_deploy.ListVersions(client, name)
+					if err != nil {
+						return toExitError(err)
+					}
+					if !c.GlobalBool("noheader") {
+						fmt.Fprintln(c.App.Writer, "Versions of lambda: " + name + "\n----------------------")
+					}
+					printVersions(c.App.Writer, versions, !c.GlobalBool("noheader"))
+				}
+				return nil
+			},
+		},
+		{
+			Name: "history",
+			Usage: "Show the recorded deploys and rollbacks of a lambda function, newest first",
+			Flags:   []cli.Flag{
+				cli.StringFlag{
+					Name: "name, n",
+					Usage: "`Name` of lambda function (can not be used with descriptor)",
+				},
+				cli.StringSliceFlag{
+					Name: "descriptor, d",
+					Usage: "`Descriptor` with the lambda functions (can not be used with name, can be repeated)",
+				},
+				stageFlag,
+				varFlag,
+				varsFileFlag,
+				functionFlag,
+				cli.IntFlag{
+					Name: "limit",
+					Usage: "show at most `N` records (optional, default all)",
+				},
+			},
+			Action:  func (c *cli.Context) error {
+				if onlyOne, err := thereMustBeOnlyOne("descriptor", strings.Join(c.StringSlice("descriptor"), ","), "name", c.String("name")); !onlyOne {
+					return cli.NewExitError(err, exitUsage)
+				}
+				functionNames, descriptorConfig, err := getFunctionNames(c)
+				if err != nil {
+					return toExitError(err)
+				}
+				sess, err := setupSession(c, descriptorConfig)
+				if err != nil {
+					return toExitError(err)
+				}
+				history, err := historyStore(c, sess, sess.lambdaClient())
+				if err != nil {
+					return toExitError(err)
+				}
+				if history == nil {
+					return cli.NewExitError("No history is kept with --history-store none", exitUsage)
+				}
+				if structuredOutput(c) {
+					documents := make([]*lambda_deploy.DeployRecord, 0)
+					for _, name := range functionNames {
+						records, err := history.Records(name)
+						if err != nil {
+							return toExitError(err)
+						}
+						documents = append(documents, newestFirst(records, c.Int("limit"))...)
+					}
+					return printDocument(c, documents)
+				}
+				for _, name := range functionNames {
+					records, err := history.Records(name)
+					if err != nil {
+						return toExitError(err)
+					}
+					if !c.GlobalBool("noheader") {
+						fmt.Fprintln(c.App.Writer, "History of lambda: " + name + "\n----------------------")
+					}
+					printHistory(c.App.Writer, records, c.Int("limit"), !c.GlobalBool("noheader"))
+				}
+				return nil
+			},
+		},
+		{
+			Name: "rollback",
+			Usage: "Go back to the version deployed before the current one, or to a given version",
+			Flags:   []cli.Flag{
+				cli.StringSliceFlag{
+					Name: "descriptor, d",
+					Usage: "`Descriptor` for the lambda function (required, can be repeated to layer descriptors)",
+				},
+				stageFlag,
+				varFlag,
+				varsFileFlag,
+				functionFlag,
+				cli.StringFlag{
+					Name: "to",
+					Usage: "`VERSION` to roll back to (optional, default the previous one in the deploy history)",
+				},
+				waitTimeoutFlag,
+			},
+			Action:  func (c *cli.Context) error {
+				_, err := checkRequiredArg("descriptor", strings.Join(c.StringSlice("descriptor"), ","))
+				if err != nil {
+					return cli.NewExitError(err, exitUsage)
+				}
+				lambdaDesc, functions, err := loadFunctions(c)
+				if err != nil {
+					return toExitError(err)
+				}
+				if c.String("to") != "" && len(functions) > 1 {
+					return cli.NewExitError("--to can only be used with one function, select it with --function", exitUsage)
+				}
+				sess, err := setupSession(c, lambdaDesc.Aws)
+				if err != nil {
+					return toExitError(err)
+				}
+				client := sess.lambdaClient()
+				history, err := historyStore(c, sess, client)
+				if err != nil {
+					return toExitError(err)
+				}
+				httpClient, err := sess.httpClient()
+				if err != nil {
+					return toExitError(err)
+				}
+				options := &lambda_deploy.DeployOptions{WaitTimeout: c.Duration("wait-timeout"), History: history, GitSha: gitSha(c), Out: progressOut(c), HttpClient: httpClient}
+				results := make([]*lambda_deploy.DeployResult, 0, len(functions))
+				for _, lambdaDesc := range functions {
+					if !c.GlobalBool("noheader") {
+						fmt.Fprintln(progressOut(c), "Rolling back lambda: " + lambdaDesc.Function_name + "\n----------------------")
+					}
+					result, err := lambda_deploy.Rollback(client, lambdaDesc, c.String("to"), options)
+					if err != nil {
+						return printDeployResults(c, results, toExitError(err))
+					}
+					fmt.Fprintf(progressOut(c), "Rolled back to version %s\n", result.RolledBackTo)
+					results = append(results, result)
+				}
+				return printDeployResults(c, results, nil)
+			},
+		},
+		{
+			Name: "wait",
+			Usage: "Wait until a lambda function is ready, after a create or update",
+			Flags:   []cli.Flag{
+				cli.StringFlag{
+					Name: "name, n",
+					Usage: "`Name` of lambda function (can not be used with descriptor)",
+				},
+				cli.StringSliceFlag{
+					Name: "descriptor, d",
+					Usage: "`Descriptor` with the lambda functions to wait for (can not be used with name, can be repeated)",
+				},
+				stageFlag,
+				varFlag,
+				varsFileFlag,
+				functionFlag,
+				waitTimeoutFlag,
+			},
+			Action:  func (c *cli.Context) error {
+				if onlyOne, err := thereMustBeOnlyOne("descriptor", strings.Join(c.StringSlice("descriptor"), ","), "name", c.String("name")); !onlyOne {
+					return cli.NewExitError(err, exitUsage)
+				}
+				functionNames, descriptorConfig, err := getFunctionNames(c)
+				if err != nil {
+					return toExitError(err)
+				}
+				client, err := setupClient(c, descriptorConfig)
+				if err != nil {
+					return toExitError(err)
+				}
+				ready := make([]waitResult, 0, len(functionNames))
+				for _, name := range functionNames {
+					config, err := lambda_deploy.WaitForFunction(client, name, c.Duration("wait-timeout"), progressOut(c))
+					if err != nil {
+						return printPartial(c, ready, toExitError(err))
+					}
+					fmt.Fprintf(progressOut(c), "%s is ready (State: %s, LastUpdateStatus: %s)\n",
+						name, aws.StringValue(config.State), aws.StringValue(config.LastUpdateStatus))
+					ready = append(ready, waitResult{
+						FunctionName:     name,
+						State:            aws.StringValue(config.State),
+						LastUpdateStatus: aws.StringValue(config.LastUpdateStatus),
+						CodeSha256:       aws.StringValue(config.CodeSha256),
+					})
+				}
+				return printPartial(c, ready, nil)
+			},
+		},
+		{
+			Name: "fake-server",
+			Usage: "run a local in-memory fake of the lambda API, for testing",
+			Flags:   []cli.Flag{
+				cli.StringFlag{
+					Name: "listen, l",
+					Value: "127.0.0.1:9001",
+					Usage: "`Address` to listen on",
+				},
+			},
+			Action: func (c *cli.Context) error {
+				address := c.String("listen")
 				if !c.GlobalBool("noheader") {
-					fmt.Printf("Invoking lambda function: %v\n----------------------\n", functionName)
+					fmt.Fprintf(c.App.Writer, "Fake lambda API listening on http://%v\n----------------------\n", address)
+				}
+				err := http.ListenAndServe(address, lambdatest.NewHandler(lambdatest.NewFakeLambda()))
+				if err != nil {
+					return toExitError(err)
 				}
-				client := lambda_deploy.SetupLambdaClient(c.GlobalString("profile"), c.GlobalString("region"))
-				output := lambda_deploy.InvokeLambda(client, functionName, body)
-				fmt.Println(output)
 				return nil
 			},
 		},
@@ -170,6 +1072,313 @@ func main() {
 	app.Run(os.Args)
 }
 
+// prints the plan, and exits with exitChangesPending if anything would change
+func showPlan(c *cli.Context, client lambda_deploy.LambdaAPI, functions []*lambda_deploy.LambdaFunctionDesc, zipfiles []string) error {
+	if !c.GlobalBool("noheader") {
+		fmt.Fprintln(progressOut(c), "Deployment plan\n----------------------")
+	}
+	hasChanges := false
+	plans := make([]*lambda_deploy.DeployPlan, 0, len(functions))
+	for i, lambdaDesc := range functions {
+		plan, err := lambda_deploy.PlanDeploy(client, zipfiles[i], lambdaDesc)
+		if err != nil {
+			return toExitError(err)
+		}
+		if !structuredOutput(c) {
+			fmt.Fprint(c.App.Writer, plan)
+		}
+		plans = append(plans, plan)
+		hasChanges = hasChanges || plan.HasChanges()
+	}
+	if structuredOutput(c) {
+		if err := printDocument(c, plans); err != nil {
+			return err
+		}
+	}
+	if hasChanges {
+		return cli.NewExitError("", exitChangesPending)
+	}
+	return nil
+}
+
+// Prints the drift of each function, exiting with exitChangesPending when
+// any of them drifted.
+func showDrift(c *cli.Context, client lambda_deploy.LambdaAPI, functions []*lambda_deploy.LambdaFunctionDesc, zipfiles []string) error {
+	reports := make([]*lambda_deploy.DriftReport, 0, len(functions))
+	drifted := false
+	for i, lambdaDesc := range functions {
+		report, err := lambda_deploy.DetectDrift(client, zipfiles[i], lambdaDesc)
+		if err != nil {
+			return toExitError(err)
+		}
+		reports = append(reports, report)
+		drifted = drifted || report.HasDrift()
+	}
+	if structuredOutput(c) {
+		if err := printDocument(c, reports); err != nil {
+			return err
+		}
+	} else {
+		if !c.GlobalBool("noheader") {
+			fmt.Fprintln(c.App.Writer, "Drift report\n----------------------")
+		}
+		for _, report := range reports {
+			fmt.Fprint(c.App.Writer, report)
+		}
+	}
+	if drifted {
+		return cli.NewExitError("", exitChangesPending)
+	}
+	return nil
+}
+
+// The AWS session of a command and the settings it was made from. All
+// clients of a command are made from one session, so credentials are only
+// fetched once: an assumed role is assumed once, with one MFA prompt.
+type awsSession struct {
+	*session.Session
+	config *lambda_deploy.ClientConfig
+}
+
+// Creates the session of a command, see clientConfig for where settings
+// come from.
+func setupSession(c *cli.Context, descriptorConfig *lambda_deploy.ClientConfig) (*awsSession, error) {
+	config, err := clientConfig(c, descriptorConfig)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := lambda_deploy.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+	return &awsSession{Session: sess, config: config}, nil
+}
+
+func (s *awsSession) lambdaClient() *lambda.Lambda {
+	return lambda_deploy.NewLambdaClient(s.Session, s.config)
+}
+
+// For downloading code from the locations GetFunction returns, with the
+// proxy, CA bundle and timeout of the AWS clients.
+func (s *awsSession) httpClient() (*http.Client, error) {
+	return lambda_deploy.NewHttpClient(s.config)
+}
+
+// Creates the lambda client of commands that need no other client.
+func setupClient(c *cli.Context, descriptorConfig *lambda_deploy.ClientConfig) (*lambda.Lambda, error) {
+	sess, err := setupSession(c, descriptorConfig)
+	if err != nil {
+		return nil, err
+	}
+	return sess.lambdaClient(), nil
+}
+
+// Flags take precedence over the aws: block of the descriptor (if any),
+// which takes precedence over the tool config file.
+func clientConfig(c *cli.Context, descriptorConfig *lambda_deploy.ClientConfig) (*lambda_deploy.ClientConfig, error) {
+	config := &lambda_deploy.ClientConfig{
+		Profile:         c.GlobalString("profile"),
+		Region:          c.GlobalString("region"),
+		Endpoint_url:    c.GlobalString("endpoint-url"),
+		Ca_bundle:       c.GlobalString("ca-bundle"),
+		Proxy:           c.GlobalString("proxy"),
+		Request_timeout: c.GlobalInt("request-timeout"),
+
+		Assume_role_arn:   c.GlobalString("assume-role-arn"),
+		External_id:       c.GlobalString("external-id"),
+		Role_session_name: c.GlobalString("role-session-name"),
+		Session_duration:  c.GlobalInt("session-duration"),
+		Mfa_serial:        c.GlobalString("mfa-serial"),
+		Mfa_token:         c.GlobalString("mfa-token"),
+	}
+	if c.GlobalIsSet("max-retries") {
+		maxRetries := c.GlobalInt("max-retries")
+		config.Max_retries = &maxRetries
+	}
+	config.Merge(descriptorConfig)
+	toolConfig, err := loadToolConfig(c.GlobalString("config"))
+	if err != nil {
+		return nil, err
+	}
+	config.Merge(toolConfig)
+	return config, nil
+}
+
+// resolves ssm: and secretsmanager: references in the environment of the functions
+func resolveSecrets(sess *awsSession, functions []*lambda_deploy.LambdaFunctionDesc) error {
+	if !lambda_deploy.HasSecretReferences(functions) {
+		return nil
+	}
+	resolver := lambda_deploy.NewSecretResolver(sess.Session)
+	for _, lambdaDesc := range functions {
+		if err := lambdaDesc.ResolveSecrets(resolver); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prints the versions as a table, aliases with a * get part of the traffic
+func printVersions(out io.Writer, versions []lambda_deploy.FunctionVersion, header bool) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	if header {
+		fmt.Fprintln(w, "VERSION\tALIASES\tCODE SHA256\tLAST MODIFIED\tDESCRIPTION")
+	}
+	for _, version := range versions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", version.Version, strings.Join(version.Aliases, ","),
+			version.CodeSha256, version.LastModified, version.Description)
+	}
+	w.Flush()
+}
+
+
+// Returns nil when deploy was not given --alias.
+func shiftOptions(c *cli.Context) (*lambda_deploy.ShiftOptions, error) {
+	if c.String("alias") == "" {
+		if c.IsSet("strategy") || c.IsSet("health-check") || c.IsSet("check-command") {
+			return nil, fmt.Errorf("--strategy, --health-check and --check-command require --alias")
+		}
+		return nil, nil
+	}
+	strategy, err := lambda_deploy.ParseStrategy(c.String("strategy"))
+	if err != nil {
+		return nil, err
+	}
+	return &lambda_deploy.ShiftOptions{
+		Alias:        c.String("alias"),
+		Strategy:     strategy,
+		HealthCheck:  c.String("health-check"),
+		CheckCommand: c.String("check-command"),
+	}, nil
+}
+
+// Prints the newest records first, at most limit of them when it is set.
+func printHistory(out io.Writer, records []*lambda_deploy.DeployRecord, limit int, header bool) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	if header {
+		fmt.Fprintln(w, "TIME\tACTION\tSTATUS\tVERSION\tCODE SHA256\tGIT SHA\tUSER\tCHANGES")
+	}
+	for _, record := range newestFirst(records, limit) {
+		changes := make([]string, 0)
+		if record.Created {
+			changes = append(changes, "created")
+		}
+		if record.CodeChanged {
+			changes = append(changes, "code")
+		}
+		for _, change := range record.ConfigChanges {
+			changes = append(changes, change.Field)
+		}
+		if record.RollbackTarget != "" {
+			changes = append(changes, "to "+record.RollbackTarget)
+		}
+		gitSha := record.GitSha
+		if len(gitSha) > 7 {
+			gitSha = gitSha[:7]
+		}
+		user := record.User
+		if record.CI != "" {
+			user += " (" + record.CI + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", record.Time.Format(time.RFC3339), record.Action,
+			record.Status, record.Version, record.CodeSha256, gitSha, user, strings.Join(changes, ","))
+	}
+	w.Flush()
+}
+
+// Reverses records, keeping at most limit of them when it is set.
+func newestFirst(records []*lambda_deploy.DeployRecord, limit int) []*lambda_deploy.DeployRecord {
+	newest := make([]*lambda_deploy.DeployRecord, 0, len(records))
+	for i := len(records) - 1; i >= 0 && (limit <= 0 || len(newest) < limit); i-- {
+		newest = append(newest, records[i])
+	}
+	return newest
+}
+
+// Returns the store selected by --history-store, nil for none.
+func historyStore(c *cli.Context, sess *awsSession, client lambda_deploy.LambdaAPI) (lambda_deploy.HistoryStore, error) {
+	store := c.GlobalString("history-store")
+	switch {
+	case store == "file":
+		path, err := homedir.Expand(c.GlobalString("history-file"))
+		if err != nil {
+			return nil, err
+		}
+		return lambda_deploy.NewFileHistory(path), nil
+	case store == "tags":
+		return lambda_deploy.NewTagHistory(client), nil
+	case store == "none":
+		return nil, nil
+	case strings.HasPrefix(store, "s3://"):
+		parts := strings.SplitN(strings.TrimPrefix(store, "s3://"), "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, cli.NewExitError("--history-store needs a bucket and key: s3://bucket/key", exitUsage)
+		}
+		return lambda_deploy.NewS3History(lambda_deploy.NewS3Client(sess.Session), parts[0], parts[1]), nil
+	default:
+		return nil, cli.NewExitError(fmt.Sprintf("Unknown --history-store %q, use file, s3://bucket/key, tags or none", store), exitUsage)
+	}
+}
+
+// The commit of the (first) descriptor, recorded in the history.
+func gitSha(c *cli.Context) string {
+	descriptors := c.StringSlice("descriptor")
+	if len(descriptors) == 0 {
+		return ""
+	}
+	return lambda_deploy.GitSha(filepath.Dir(descriptors[0]))
+}
+
+// sets up an S3 client when any of the functions is staged via S3
+func deployOptions(c *cli.Context, sess *awsSession, client lambda_deploy.LambdaAPI, functions []*lambda_deploy.LambdaFunctionDesc) (*lambda_deploy.DeployOptions, error) {
+	history, err := historyStore(c, sess, client)
+	if err != nil {
+		return nil, err
+	}
+	// a failed smoke test can restore the previous version, downloading its code
+	httpClient, err := sess.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	options := &lambda_deploy.DeployOptions{
+		S3Bucket:    c.String("s3-bucket"),
+		WaitTimeout: c.Duration("wait-timeout"),
+		History:     history,
+		GitSha:      gitSha(c),
+		Out:         progressOut(c),
+		HttpClient:  httpClient,
+	}
+	staged := false
+	for _, lambdaDesc := range functions {
+		if lambdaDesc.IsImage() {
+			continue
+		}
+		if (lambdaDesc.Code == nil && options.S3Bucket != "") ||
+			(lambdaDesc.Code != nil && !lambdaDesc.HasS3Code()) {
+			staged = true
+		}
+	}
+	if staged {
+		options.S3 = lambda_deploy.NewS3Client(sess.Session)
+	}
+	return options, nil
+}
+
+// an explicitly given tool config file must exist, the default one is optional
+func loadToolConfig(filename string) (*lambda_deploy.ClientConfig, error) {
+	if filename != "" {
+		return lambda_deploy.LoadClientConfigFile(filename)
+	}
+	defaultFile, err := homedir.Expand(defaultToolConfig)
+	if err != nil {
+		return nil, nil
+	}
+	if _, err := os.Stat(defaultFile); err != nil {
+		return nil, nil
+	}
+	return lambda_deploy.LoadClientConfigFile(defaultFile)
+}
+
 func checkRequiredArg(name, value string) (string, error) {
 	if value == "" {
 		msg := "Error: missing required argument: " + name
@@ -192,12 +1401,330 @@ func thereCanBeOnlyOne(firstName, firstValue, secondName, secondValue string) (b
 	return true, nil
 }
 
-// one of descriptor or string must have a value
-func getFunctionName(descriptor, name string) (string) {
-	if name != "" {
-		return name
+// Returns the zip to deploy for each function: the one given with -z, or one
+// built into dir from the source: block of the function. Image functions and
+// functions with code in S3 need no zip, their entry is empty.
+func zipFiles(c *cli.Context, functions []*lambda_deploy.LambdaFunctionDesc, dir string) ([]string, error) {
+	zipfiles := make([]string, 0, len(functions))
+	for _, lambdaDesc := range functions {
+		if lambdaDesc.IsImage() {
+			zipfiles = append(zipfiles, "")
+			continue
+		}
+		if zipfile := c.String("zip-file"); zipfile != "" {
+			zipfiles = append(zipfiles, zipfile)
+			continue
+		}
+		if lambdaDesc.Source == nil && lambdaDesc.HasS3Code() {
+			zipfiles = append(zipfiles, "")
+			continue
+		}
+		if lambdaDesc.Source == nil {
+			return nil, cli.NewExitError("Error: missing required argument: zip-file (or a source: block in the descriptor)", exitUsage)
+		}
+		zipfile := filepath.Join(dir, lambdaDesc.Function_name + ".zip")
+		if err := lambda_deploy.BuildZip(lambdaDesc.Source, zipfile); err != nil {
+			return nil, err
+		}
+		zipfiles = append(zipfiles, zipfile)
+	}
+	return zipfiles, nil
+}
+
+// loads the descriptors given with -d, with the overlay given by --stage
+func loadDescriptor(c *cli.Context) (*lambda_deploy.LambdaDescriptor, error) {
+	vars, err := loadVars(c)
+	if err != nil {
+		return nil, err
+	}
+	options := &lambda_deploy.DescriptorOptions{
+		Stage: c.String("stage"),
+		Vars:  vars,
+	}
+	return lambda_deploy.LoadDescriptorFiles(c.StringSlice("descriptor"), options)
+}
+
+// collects variables from --vars-file and --var
+func loadVars(c *cli.Context) (map[string]string, error) {
+	vars := map[string]string{}
+	if varsFile := c.String("vars-file"); varsFile != "" {
+		fileVars, err := lambda_deploy.LoadVarsFile(varsFile)
+		if err != nil {
+			return nil, err
+		}
+		vars = fileVars
+	}
+	for _, v := range c.StringSlice("var") {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			return nil, cli.NewExitError("Error: --var must be name=value, got: " + v, exitUsage)
+		}
+		vars[parts[0]] = parts[1]
+	}
+	return vars, nil
+}
+
+// loads the descriptor, and picks the functions given with --function (default all)
+func loadFunctions(c *cli.Context) (*lambda_deploy.LambdaDescriptor, []*lambda_deploy.LambdaFunctionDesc, error) {
+	lambdaDesc, err := loadDescriptor(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	functions, err := lambdaDesc.Select(c.StringSlice("function")...)
+	return lambdaDesc, functions, err
+}
+
+// one of descriptor or name must have a value. Also returns the aws settings
+// of the descriptor, if one was used.
+func getFunctionNames(c *cli.Context) ([]string, *lambda_deploy.ClientConfig, error) {
+	if name := c.String("name"); name != "" {
+		return []string{name}, nil, nil
 	} else {
-		lambdaDesc := lambda_deploy.LoadDescriptorFile(descriptor)
-		return lambdaDesc.Function_name
+		lambdaDesc, functions, err := loadFunctions(c)
+		if err != nil {
+			return nil, nil, err
+		}
+		names := make([]string, 0, len(functions))
+		for _, function := range functions {
+			names = append(names, function.Function_name)
+		}
+		return names, lambdaDesc.Aws, nil
+	}
+}
+
+// maps errors from the library onto the exit codes of the tool
+func toExitError(err error) error {
+	switch err.(type) {
+	case cli.ExitCoder:
+		return err
+	case *lambda_deploy.DescriptorValidationError:
+		return cli.NewExitError(err, exitInvalidDescriptor)
+	case *lambda_deploy.FunctionNotFoundError:
+		return cli.NewExitError(err, exitFunctionNotFound)
+	case *lambda_deploy.CodeUploadError:
+		return cli.NewExitError(err, exitCodeUploadFailed)
+	case *lambda_deploy.ConfigUpdateError:
+		return cli.NewExitError(err, exitConfigUpdateFailed)
+	case *lambda_deploy.FunctionNotReadyError:
+		return cli.NewExitError(err, exitFunctionNotReady)
+	case *lambda_deploy.TrafficShiftError:
+		return cli.NewExitError(err, exitRolledBack)
+	case *lambda_deploy.SmokeTestError:
+		return cli.NewExitError(err, exitSmokeTestsFailed)
+	default:
+		return cli.NewExitError(err, exitError)
+	}
+}
+
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+	outputTable = "table"
+)
+
+func setupOutput(c *cli.Context) error {
+	switch c.GlobalString("output") {
+	case outputText, outputTable, outputJSON, outputYAML:
+	default:
+		return cli.NewExitError(fmt.Sprintf("Error: invalid --output %q, use text, json, yaml or table", c.GlobalString("output")), exitUsage)
+	}
+	return nil
+}
+
+// The --output format; the deprecated --json of drift means json.
+func outputFormat(c *cli.Context) string {
+	if c.Bool("json") {
+		return outputJSON
+	}
+	return c.GlobalString("output")
+}
+
+func structuredOutput(c *cli.Context) bool {
+	output := outputFormat(c)
+	return output == outputJSON || output == outputYAML
+}
+
+// Where progress messages go: the output of the app, or with --output json
+// and yaml its error output, so that the output has nothing but the document.
+func progressOut(c *cli.Context) io.Writer {
+	if structuredOutput(c) {
+		return c.App.ErrWriter
+	}
+	return c.App.Writer
+}
+
+// Writes doc as JSON, or as YAML with the same keys.
+func printDocument(c *cli.Context, doc interface{}) error {
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return toExitError(err)
 	}
-}
\ No newline at end of file
+	if outputFormat(c) == outputYAML {
+		var value interface{}
+		if err := yaml.Unmarshal(out, &value); err != nil {
+			return toExitError(err)
+		}
+		if out, err = yaml.Marshal(value); err != nil {
+			return toExitError(err)
+		}
+	}
+	fmt.Fprintln(c.App.Writer, strings.TrimSuffix(string(out), "\n"))
+	return nil
+}
+
+// Writes the results of the functions done so far as a document, also when
+// one of them failed with err.
+func printPartial(c *cli.Context, results interface{}, err error) error {
+	if structuredOutput(c) {
+		if printErr := printDocument(c, results); printErr != nil {
+			return printErr
+		}
+	}
+	return err
+}
+
+type deleteResult struct {
+	FunctionName string `json:"function_name"`
+	Deleted      bool   `json:"deleted"`
+}
+
+type packageResult struct {
+	FunctionName string `json:"function_name"`
+	ZipFile      string `json:"zip_file"`
+	CodeSha256   string `json:"code_sha256"`
+}
+
+type exportResult struct {
+	FunctionName string                         `json:"function_name"`
+	Descriptor   lambda_deploy.DescriptorFields `json:"descriptor"`
+	File         string                         `json:"file,omitempty"`      // where the descriptor was written
+	CodeFile     string                         `json:"code_file,omitempty"` // with --with-code
+}
+
+type downloadResult struct {
+	FunctionName string `json:"function_name"`
+	Version      string `json:"version"`
+	ZipFile      string `json:"zip_file"`
+	CodeSha256   string `json:"code_sha256"`
+	ExtractedTo  string `json:"extracted_to,omitempty"`
+}
+
+type waitResult struct {
+	FunctionName     string `json:"function_name"`
+	State            string `json:"state"`
+	LastUpdateStatus string `json:"last_update_status"`
+	CodeSha256       string `json:"code_sha256"`
+}
+
+type versionsDocument struct {
+	FunctionName string                          `json:"function_name"`
+	Versions     []lambda_deploy.FunctionVersion `json:"versions"`
+}
+
+func printDeployResults(c *cli.Context, results []*lambda_deploy.DeployResult, err error) error {
+	if c.GlobalString("output") != outputTable {
+		return printPartial(c, results, err)
+	}
+	w := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+	if !c.GlobalBool("noheader") {
+		fmt.Fprintln(w, "FUNCTION\tACTION\tVERSION\tCODE SHA256\tCHANGES")
+	}
+	for _, result := range results {
+		changes := make([]string, 0)
+		if result.CodeChanged {
+			changes = append(changes, "code")
+		}
+		for _, change := range result.ConfigChanges {
+			changes = append(changes, change.Field)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.FunctionName, result.Action, result.Version,
+			result.CodeSha256, strings.Join(changes, ","))
+	}
+	w.Flush()
+	return err
+}
+
+func printFunctions(out io.Writer, functions []lambda_deploy.FunctionSummary, header, regions bool) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	if header && regions {
+		fmt.Fprint(w, "REGION\t")
+	}
+	if header {
+		fmt.Fprintln(w, "NAME\tRUNTIME\tMEMORY\tTIMEOUT\tLAST MODIFIED")
+	}
+	for _, function := range functions {
+		runtime := function.Runtime
+		if runtime == "" {
+			runtime = function.PackageType
+		}
+		if regions {
+			fmt.Fprintf(w, "%s\t", function.Region)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", function.FunctionName, runtime,
+			function.MemorySize, function.Timeout, function.LastModified)
+	}
+	w.Flush()
+}
+
+// The filters and sorting of list.
+func listOptions(c *cli.Context) (*lambda_deploy.ListOptions, error) {
+	options := &lambda_deploy.ListOptions{
+		NamePrefix: c.String("name-prefix"),
+		Runtime:    c.String("runtime"),
+		Tags:       lambda_deploy.ParseTagFilters(c.StringSlice("tag")),
+		SortBy:     c.String("sort"),
+		Reverse:    c.Bool("reverse"),
+	}
+	if c.String("name-regex") != "" {
+		regex, err := regexp.Compile(c.String("name-regex"))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --name-regex: %s", err)
+		}
+		options.NameRegex = regex
+	}
+	if c.String("modified-since") != "" {
+		since, err := lambda_deploy.ParseSince(c.String("modified-since"), time.Now())
+		if err != nil {
+			return nil, err
+		}
+		options.ModifiedSince = since
+	}
+	return options, options.Validate()
+}
+
+// Lists the functions in the region, or with --all-regions in every region
+// at once. With --all-regions the functions found are returned also when
+// some regions failed, with the error.
+func listFunctions(c *cli.Context, options *lambda_deploy.ListOptions) ([]lambda_deploy.FunctionSummary, error) {
+	if !c.Bool("all-regions") {
+		client, err := setupClient(c, nil)
+		if err != nil {
+			return nil, err
+		}
+		return lambda_deploy.ListFunctions(client, options)
+	}
+	// one session, so credentials (and an MFA prompt) are shared by the regions
+	sess, err := setupSession(c, nil)
+	if err != nil {
+		return nil, err
+	}
+	regions := lambda_deploy.LambdaRegions(aws.StringValue(sess.Config.Region))
+	return lambda_deploy.ListFunctionsInRegions(regions, func(region string) (lambda_deploy.LambdaAPI, error) {
+		regionConfig := aws.NewConfig().WithRegion(region)
+		if sess.config.Endpoint_url != "" {
+			regionConfig = regionConfig.WithEndpoint(sess.config.Endpoint_url)
+		}
+		return lambda.New(sess.Session, regionConfig), nil
+	}, options)
+}
+
+func printAccountSettings(out io.Writer, settings *lambda_deploy.AccountSettings) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Functions\t%d\n", settings.FunctionCount)
+	fmt.Fprintf(w, "Total code size\t%d of %d\n", settings.TotalCodeSize, settings.TotalCodeSizeLimit)
+	fmt.Fprintf(w, "Code size limit (zipped)\t%d\n", settings.CodeSizeZippedLimit)
+	fmt.Fprintf(w, "Code size limit (unzipped)\t%d\n", settings.CodeSizeUnzippedLimit)
+	fmt.Fprintf(w, "Concurrent executions\t%d (%d unreserved)\n", settings.ConcurrentExecutionsLimit, settings.UnreservedConcurrentExecutions)
+	w.Flush()
+}
@@ -5,76 +5,232 @@ import (
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/mitchellh/go-homedir"
 	"fmt"
-	"strings"
 	"io/ioutil"
 	"log"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"crypto/sha256"
 	"encoding/base64"
+	"io"
+	"net/http"
+	"os"
+	"time"
 )
 
+// Settings for LambdaDeployWithOptions.
+type DeployOptions struct {
+	S3       S3API  // used to stage zips, required when they go through S3
+	S3Bucket string // stage zips in this bucket when the descriptor has no code: block
 
+	// How long to wait for the function to become ready after each change,
+	// 0 means DefaultWaitTimeout.
+	WaitTimeout time.Duration
 
-func LambdaDeploy(profile, region, zipfile string, descriptor *LambdaFunctionDesc) {
-	svc := SetupLambdaClient(profile, region)
+	// Shift the traffic of an alias to the published version gradually,
+	// this publishes even when the descriptor does not.
+	Shift *ShiftOptions
+
+	// Skip the smoke tests of the descriptor.
+	SkipSmokeTests bool
+
+	// Where deploys and rollbacks are recorded, nil for nowhere.
+	History HistoryStore
+	// The commit being deployed, recorded in the history.
+	GitSha string
+
+	// Where progress messages go, nil for standard output.
+	Out io.Writer
+
+	// Downloads the code of the version a rollback restores, nil for
+	// http.DefaultClient. See NewHttpClient.
+	HttpClient *http.Client
+}
+
+func (o *DeployOptions) out() io.Writer {
+	if o.Out == nil {
+		return os.Stdout
+	}
+	return o.Out
+}
+
+func LambdaDeploy(svc LambdaAPI, zipfile string, descriptor *LambdaFunctionDesc) error {
+	_, err := LambdaDeployWithOptions(svc, zipfile, descriptor, &DeployOptions{})
+	return err
+}
+
+// Like LambdaDeploy, with options for staging the code in S3. zipfile may be
+// empty when the descriptor points at code in S3, the code is then always
+// updated. When the descriptor publishes, the version is published after all
+// changes are made, and the aliases of the descriptor are pointed at it. The
+// alias in options.Shift is moved last, following its strategy. The outcome
+// is appended to options.History when it is set. The smoke tests of the
+// descriptor run last, failing the deploy when one fails.
+func LambdaDeployWithOptions(svc LambdaAPI, zipfile string, descriptor *LambdaFunctionDesc, options *DeployOptions) (*DeployResult, error) {
+	result := &DeployResult{FunctionName: descriptor.Function_name}
+	err := deploy(svc, zipfile, descriptor, options, result)
+	switch {
+	case result.Created:
+		result.Action = PlanActionCreate
+	case result.CodeChanged || result.ConfigChanged:
+		result.Action = PlanActionUpdate
+	default:
+		result.Action = PlanActionNoop
+	}
+	if options.History != nil {
+		recordDeploy(options, "deploy", descriptor, result, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func deploy(svc LambdaAPI, zipfile string, descriptor *LambdaFunctionDesc, options *DeployOptions, result *DeployResult) error {
 	getFunctionInput := lambda.GetFunctionInput{FunctionName: &(descriptor.Function_name)}
-	result, err := svc.GetFunction(&getFunctionInput)
+	function, err := svc.GetFunction(&getFunctionInput)
+	isDeployed, err := checkIfLambdaIsDeployed(err)
+	if err != nil {
+		return err
+	}
 
-	if checkIfLambdaIsDeployed(err) {
-		fmt.Println("The function already exists")
-		amazonSha := *(result.Configuration.CodeSha256)
-		if amazonSha == Base64sha256(zipfile) {
-			fmt.Println("Your zipfile and the uploaded one are identical")
+	if isDeployed {
+		fmt.Fprintln(options.out(), "The function already exists")
+		if err := checkPackageType(descriptor, function.Configuration); err != nil {
+			return err
+		}
+		result.FunctionArn = unqualifiedArn(aws.StringValue(function.Configuration.FunctionArn))
+		result.CodeSha256 = aws.StringValue(function.Configuration.CodeSha256)
+		if !codeChanged(descriptor, zipfile, function) {
+			fmt.Fprintln(options.out(), "Your code and the deployed one are identical")
 		} else {
-			fmt.Println("Uploading lambda function")
-			updateExistingCode(svc, descriptor, zipfile)
+			fmt.Fprintln(options.out(), "Uploading lambda function")
+			if err := updateExistingCode(svc, descriptor, zipfile, options); err != nil {
+				return err
+			}
+			result.CodeChanged = true
+			// the configuration can not be updated while the code update is in progress
+			if err := waitForDeploy(svc, descriptor.Function_name, options, result); err != nil {
+				return err
+			}
 		}
-		configDiff, isDifferent := descriptor.CompareConfig(result.Configuration)
+		configDiff, isDifferent := descriptor.CompareConfig(function.Configuration)
 		if !isDifferent {
-			fmt.Println("Config is unchanged - will not update")
+			fmt.Fprintln(options.out(), "Config is unchanged - will not update")
 		} else {
-			fmt.Println("Config is changed - differences:", configDiff)
-			result, err := svc.UpdateFunctionConfiguration(configDiff)
-			check(err)
-			fmt.Println("Config has been updated, it is now:", result)
+			// secrets are masked in the changes, never print configDiff itself
+			changes := diffConfig(configDiff, function.Configuration, descriptor)
+			fmt.Fprintln(options.out(), "Config is changed - differences:")
+			for _, change := range changes {
+				fmt.Fprintf(options.out(), "  ~ %s: %q => %q\n", change.Field, change.Before, change.After)
+			}
+			if _, err := svc.UpdateFunctionConfiguration(configDiff); err != nil {
+				return &ConfigUpdateError{FunctionName: descriptor.Function_name, Err: err}
+			}
+			result.ConfigChanged = true
+			result.ConfigChanges = changes
+			fmt.Fprintln(options.out(), "Config has been updated")
+			if err := waitForDeploy(svc, descriptor.Function_name, options, result); err != nil {
+				return err
+			}
 		}
 	} else {
-		fmt.Println("Lambda function is not deployed")
-		createNewLambda(svc, descriptor, zipfile)
+		fmt.Fprintln(options.out(), "Lambda function is not deployed")
+		created, err := createNewLambda(svc, descriptor, zipfile, options)
+		if err != nil {
+			return err
+		}
+		result.Created = true
+		result.FunctionArn = unqualifiedArn(aws.StringValue(created.FunctionArn))
+		if descriptor.Publish {
+			result.Version = aws.StringValue(created.Version)
+		}
+		if err := waitForDeploy(svc, descriptor.Function_name, options, result); err != nil {
+			return err
+		}
 	}
+	smokeTests := len(descriptor.Smoke_tests) > 0 && !options.SkipSmokeTests
+	previous := ""
+	if smokeTests && descriptor.Rollback_on_smoke_failure && isDeployed {
+		if previous, err = latestVersion(svc, descriptor.Function_name); err != nil {
+			return err
+		}
+	}
+	publish := descriptor.Publish || options.Shift != nil
+	if publish {
+		if err := publishNewVersion(svc, descriptor, result, options); err != nil {
+			return err
+		}
+	}
+	// the new version is tested before the aliases go to it
+	if smokeTests {
+		if err := smokeTestDeploy(svc, descriptor, result, previous, options); err != nil {
+			return err
+		}
+	}
+	if publish {
+		return pointAliases(svc, descriptor, result, options)
+	}
+	return nil
 }
 
-func checkIfLambdaIsDeployed(getFunctionError error) (bool) {
-	if getFunctionError == nil {
+// Waits for the function after a change, keeping the sha of its code.
+func waitForDeploy(svc LambdaAPI, functionName string, options *DeployOptions, result *DeployResult) error {
+	config, err := WaitForFunction(svc, functionName, options.WaitTimeout, options.out())
+	if err != nil {
+		return err
+	}
+	result.CodeSha256 = aws.StringValue(config.CodeSha256)
+	return nil
+}
+
+// Compares the image digest for image functions and the zip sha for the rest.
+// Code in S3 without a local zip is always considered changed.
+func codeChanged(descriptor *LambdaFunctionDesc, zipfile string, function *lambda.GetFunctionOutput) bool {
+	if descriptor.IsImage() {
+		return imageChanged(descriptor, function)
+	}
+	if zipfile == "" {
 		return true
+	}
+	return aws.StringValue(function.Configuration.CodeSha256) != Base64sha256(zipfile)
+}
+
+// Returns false if GetFunction failed because the function does not exist,
+// and the error itself for any other failure.
+func checkIfLambdaIsDeployed(getFunctionError error) (bool, error) {
+	if getFunctionError == nil {
+		return true, nil
 	} else {
-		if strings.Contains(getFunctionError.Error(), "ResourceNotFoundException") {
-			return false
+		if isNotFound(getFunctionError) {
+			return false, nil
 		} else {
-			panic(getFunctionError)
+			return false, getFunctionError
 		}
 	}
 }
 
-func createNewLambda(client *lambda.Lambda, descriptor *LambdaFunctionDesc, zipfile string) {
-	file, zipErr := loadFileContent(zipfile)
-	if zipErr != nil {
-		panic(fmt.Errorf("Unable to load %q: %s", zipfile, zipErr))
-	}
-	functionCode := lambda.FunctionCode {
-		ZipFile: file,
+func createNewLambda(client LambdaAPI, descriptor *LambdaFunctionDesc, zipfile string, options *DeployOptions) (*lambda.FunctionConfiguration, error) {
+	code, err := functionCode(descriptor, zipfile, options)
+	if err != nil {
+		return nil, &CodeUploadError{FunctionName: descriptor.Function_name, Err: err}
 	}
 	params := &lambda.CreateFunctionInput{
-		Code:         &functionCode,
+		Code:         code,
 		Description:  aws.String(descriptor.Description),
 		FunctionName: aws.String(descriptor.Function_name),
-		Handler:      aws.String(descriptor.Handler),
 		MemorySize:   aws.Int64(int64(descriptor.Memory_size)),
 		Role:         aws.String(descriptor.Role),
-		Runtime:      aws.String(descriptor.Runtime),
 		Timeout:      aws.Int64(int64(descriptor.Timeout)),
 		Publish:      aws.Bool(descriptor.Publish),
 	}
+	if descriptor.IsImage() {
+		params.PackageType = aws.String(lambda.PackageTypeImage)
+		if descriptor.Image_config != nil {
+			params.ImageConfig = descriptor.Image_config.input()
+		}
+	} else {
+		params.Handler = aws.String(descriptor.Handler)
+		params.Runtime = aws.String(descriptor.Runtime)
+	}
 	if len(descriptor.Environment) > 0 {
 		params.Environment = &lambda.Environment{
 			Variables: aws.StringMap(descriptor.Environment),
@@ -86,32 +242,41 @@ func createNewLambda(client *lambda.Lambda, descriptor *LambdaFunctionDesc, zipf
 			SubnetIds: aws.StringSlice(descriptor.Vpc_config.Subnet_ids),
 		}
 	}
-	fmt.Println("Uploading lambda function")
-	_, err := client.CreateFunction(params)
+	fmt.Fprintln(options.out(), "Uploading lambda function")
+	created, err := client.CreateFunction(params)
 	if err != nil {
 		log.Printf("[ERROR] Received %q", err)
-		if awserr, ok := zipErr.(awserr.Error); ok {
+		if awserr, ok := err.(awserr.Error); ok {
 			if awserr.Code() == "InvalidParameterValueException" {
 				log.Printf("[DEBUG] InvalidParameterValueException creating Lambda Function: %s", awserr)
 
 			}
 		}
 		log.Printf("[DEBUG] Error creating Lambda Function: %s", err)
-		panic(err)
+		return nil, &CodeUploadError{FunctionName: descriptor.Function_name, Err: err}
 	}
+	return created, nil
 }
 
-func updateExistingCode(client *lambda.Lambda, descriptor *LambdaFunctionDesc, zipfile string) {
-	file, err := loadFileContent(zipfile)
-	check(err)
+func updateExistingCode(client LambdaAPI, descriptor *LambdaFunctionDesc, zipfile string, options *DeployOptions) error {
+	code, err := functionCode(descriptor, zipfile, options)
+	if err != nil {
+		return &CodeUploadError{FunctionName: descriptor.Function_name, Err: err}
+	}
 	input := &lambda.UpdateFunctionCodeInput{
-		FunctionName: aws.String(descriptor.Function_name),
-		Publish:      aws.Bool(descriptor.Publish),
-		ZipFile:      file,
+		FunctionName:    aws.String(descriptor.Function_name),
+		ZipFile:         code.ZipFile,
+		S3Bucket:        code.S3Bucket,
+		S3Key:           code.S3Key,
+		S3ObjectVersion: code.S3ObjectVersion,
+		ImageUri:        code.ImageUri,
 	}
 	result, err := client.UpdateFunctionCode(input)
-	check(err)
-	fmt.Println("Result of code update:", result)
+	if err != nil {
+		return &CodeUploadError{FunctionName: descriptor.Function_name, Err: err}
+	}
+	fmt.Fprintln(options.out(), "Code has been updated, CodeSha256:", aws.StringValue(result.CodeSha256))
+	return nil
 }
 
 // see: https://github.com/hashicorp/terraform/blob/master/builtin/providers/aws/resource_aws_lambda_function.go
@@ -132,8 +297,12 @@ func loadFileContent(v string) ([]byte, error) {
 // Different from the one that is on AWS.
 func Base64sha256 (zipfile string) (string) {
 	file, _ := loadFileContent(zipfile)
+	return base64sha256Bytes(file)
+}
+
+func base64sha256Bytes(data []byte) string {
 	h := sha256.New()
-	h.Write(file)
+	h.Write(data)
 	shaSum := h.Sum(nil)
 	return base64.StdEncoding.EncodeToString(shaSum[:])
 }
@@ -3,19 +3,77 @@ package lambda_deploy
 import (
 	"testing"
 	"github.com/stretchr/testify/assert"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
 )
 
-/*
-type LambdaClientMock struct{
-	mock.Mock
-}
-func (m *LambdaClientMock) UpdateFunctionCode(input *lambda.UpdateFunctionCodeInput) (*lambda.FunctionConfiguration, error) {
-	args := m.Called(input)
-	return *args.Get(0).(lambda.FunctionConfiguration), args.Error(1)
-}
-*/
 func TestBase641(t *testing.T) {
 	fileName := "./testdata/test1/python_hello.zip"
 	result := Base64sha256(fileName)
 	assert.Equal(t, "MqhRu7AvFO9UcpcXI4tzTp63SMLtEm6UQhl54W1w0Ss=", result)
-}
\ No newline at end of file
+}
+
+func loadTestDescriptor(t *testing.T) *LambdaFunctionDesc {
+	lambdaDesc, err := LoadDescriptorFile("./testdata/test1/lambda-desc.yml")
+	assert.NoError(t, err)
+	return lambdaDesc.Lambda
+}
+
+func TestDeployCreatesFunction(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	err := LambdaDeploy(fake, testZip, loadTestDescriptor(t))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GetFunction", "CreateFunction", "GetFunctionConfiguration"}, fake.Calls())
+}
+
+func TestDeployUnchangedIsNoop(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadTestDescriptor(t)
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	assert.Equal(t, []string{"GetFunction", "CreateFunction", "GetFunctionConfiguration", "GetFunction"}, fake.Calls())
+}
+
+func TestDeployUpdatesConfig(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadTestDescriptor(t)
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	lambdaDesc.Memory_size = 256
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	assert.Equal(t, []string{"GetFunction", "CreateFunction", "GetFunctionConfiguration", "GetFunction", "UpdateFunctionConfiguration", "GetFunctionConfiguration"}, fake.Calls())
+
+	plan, err := PlanDeploy(fake, testZip, lambdaDesc)
+	assert.NoError(t, err)
+	assert.False(t, plan.HasChanges())
+}
+
+func TestDeployUpdatesCode(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadTestDescriptor(t)
+	// any other file will do as the first version of the code
+	assert.NoError(t, LambdaDeploy(fake, "./testdata/descriptors/vpc-descriptor.yml", lambdaDesc))
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	assert.Equal(t, []string{"GetFunction", "CreateFunction", "GetFunctionConfiguration", "GetFunction", "UpdateFunctionCode", "GetFunctionConfiguration"}, fake.Calls())
+	assert.Equal(t, Base64sha256(testZip), lambdatest.CodeSha256(fake.Code("python-hello")))
+}
+
+func TestDeployMissingZip(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	err := LambdaDeploy(fake, "./testdata/missing.zip", loadTestDescriptor(t))
+	_, ok := err.(*CodeUploadError)
+	assert.True(t, ok, "should be a code upload error")
+}
+
+func TestDeleteMissingFunction(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	err := DeleteLambda(fake, "missing")
+	_, ok := err.(*FunctionNotFoundError)
+	assert.True(t, ok, "should be a not found error")
+}
+
+func TestInvokeEchoes(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	assert.NoError(t, LambdaDeploy(fake, testZip, loadTestDescriptor(t)))
+	output, err := InvokeLambda(fake, "python-hello", `{"hello":"world"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"hello":"world"}`, output)
+}
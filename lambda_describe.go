@@ -0,0 +1,374 @@
+package lambda_deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"gopkg.in/yaml.v2"
+)
+
+// Everything about a live function: what GetFunction returns, its reserved
+// concurrency, aliases, versions, event source mappings, tags and resource
+// policy. Descriptor has the function as descriptor fields, the way export
+// writes them.
+type FunctionDescription struct {
+	FunctionName        string                `json:"function_name"`
+	Descriptor          DescriptorFields      `json:"descriptor"`
+	Configuration       FunctionConfiguration `json:"configuration"`
+	Code                FunctionCode          `json:"code"`
+	ReservedConcurrency *int64                `json:"reserved_concurrency"` // nil when none is reserved
+	Aliases             []FunctionAlias       `json:"aliases"`
+	Versions            []FunctionVersion     `json:"versions"`
+	EventSourceMappings []EventSourceMapping  `json:"event_source_mappings"`
+	Tags                map[string]string     `json:"tags"`
+	Policy              json.RawMessage       `json:"policy"` // null without a resource policy
+}
+
+// The configuration of a function as GetFunction reports it.
+type FunctionConfiguration struct {
+	FunctionArn            string             `json:"function_arn"`
+	Version                string             `json:"version"`
+	PackageType            string             `json:"package_type"`
+	Runtime                string             `json:"runtime,omitempty"`
+	Handler                string             `json:"handler,omitempty"`
+	Role                   string             `json:"role"`
+	Description            string             `json:"description"`
+	MemorySize             int64              `json:"memory_size"`
+	Timeout                int64              `json:"timeout"`
+	Environment            map[string]string  `json:"environment"`
+	VpcConfig              *FunctionVpcConfig `json:"vpc_config,omitempty"`
+	Layers                 []string           `json:"layers"`
+	Architectures          []string           `json:"architectures"`
+	TracingMode            string             `json:"tracing_mode,omitempty"`
+	KmsKeyArn              string             `json:"kms_key_arn,omitempty"`
+	CodeSize               int64              `json:"code_size"`
+	CodeSha256             string             `json:"code_sha256"`
+	LastModified           string             `json:"last_modified"`
+	RevisionId             string             `json:"revision_id"`
+	State                  string             `json:"state,omitempty"`
+	StateReason            string             `json:"state_reason,omitempty"`
+	LastUpdateStatus       string             `json:"last_update_status,omitempty"`
+	LastUpdateStatusReason string             `json:"last_update_status_reason,omitempty"`
+}
+
+type FunctionVpcConfig struct {
+	VpcId            string   `json:"vpc_id"`
+	SubnetIds        []string `json:"subnet_ids"`
+	SecurityGroupIds []string `json:"security_group_ids"`
+}
+
+// Where the code of a function comes from. The presigned download location
+// is left out, it is a credential.
+type FunctionCode struct {
+	RepositoryType   string `json:"repository_type"`
+	ImageUri         string `json:"image_uri,omitempty"`
+	ResolvedImageUri string `json:"resolved_image_uri,omitempty"`
+}
+
+type FunctionAlias struct {
+	Name            string `json:"name"`
+	AliasArn        string `json:"alias_arn"`
+	FunctionVersion string `json:"function_version"`
+	Description     string `json:"description"`
+	// The share of the traffic sent to other versions, keyed by version.
+	AdditionalVersionWeights map[string]float64 `json:"additional_version_weights,omitempty"`
+}
+
+type EventSourceMapping struct {
+	UUID                  string `json:"uuid"`
+	EventSourceArn        string `json:"event_source_arn"`
+	State                 string `json:"state"`
+	StateTransitionReason string `json:"state_transition_reason,omitempty"`
+	BatchSize             int64  `json:"batch_size"`
+	LastModified          string `json:"last_modified,omitempty"`
+	LastProcessingResult  string `json:"last_processing_result,omitempty"`
+}
+
+// Descriptor fields in order. They are encoded as a JSON object in the same
+// order.
+type DescriptorFields yaml.MapSlice
+
+func (d DescriptorFields) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("{")
+	for i, item := range d {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		key, err := json.Marshal(fmt.Sprint(item.Key))
+		if err != nil {
+			return nil, err
+		}
+		value := item.Value
+		if nested, ok := value.(yaml.MapSlice); ok {
+			value = DescriptorFields(nested)
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteString(":")
+		b.Write(data)
+	}
+	b.WriteString("}")
+	return b.Bytes(), nil
+}
+
+// Collects everything about the function. The values of its environment
+// variables are masked in the configuration and descriptor unless
+// showSecrets is set, as there is no telling which of them are secrets.
+func DescribeFunction(svc LambdaAPI, functionName string, showSecrets bool) (*FunctionDescription, error) {
+	descriptor, function, err := ExportFunction(svc, functionName)
+	if err != nil {
+		return nil, err
+	}
+	description := &FunctionDescription{
+		FunctionName:        aws.StringValue(function.Configuration.FunctionName),
+		Configuration:       describeConfiguration(function.Configuration),
+		Aliases:             make([]FunctionAlias, 0),
+		EventSourceMappings: make([]EventSourceMapping, 0),
+		Tags:                make(map[string]string),
+	}
+	if function.Code != nil {
+		description.Code = FunctionCode{
+			RepositoryType:   aws.StringValue(function.Code.RepositoryType),
+			ImageUri:         aws.StringValue(function.Code.ImageUri),
+			ResolvedImageUri: aws.StringValue(function.Code.ResolvedImageUri),
+		}
+	}
+	if !showSecrets {
+		for key := range descriptor.Environment {
+			descriptor.Environment[key] = MaskedValue
+		}
+		for key := range description.Configuration.Environment {
+			description.Configuration.Environment[key] = MaskedValue
+		}
+	}
+	description.Descriptor = DescriptorFields(exportFields(descriptor, false))
+
+	concurrency, err := svc.GetFunctionConcurrency(&lambda.GetFunctionConcurrencyInput{FunctionName: aws.String(functionName)})
+	if err != nil {
+		return nil, err
+	}
+	description.ReservedConcurrency = concurrency.ReservedConcurrentExecutions
+
+	aliasInput := &lambda.ListAliasesInput{FunctionName: aws.String(functionName)}
+	for {
+		output, err := svc.ListAliases(aliasInput)
+		if err != nil {
+			return nil, err
+		}
+		for _, alias := range output.Aliases {
+			described := FunctionAlias{
+				Name:            aws.StringValue(alias.Name),
+				AliasArn:        aws.StringValue(alias.AliasArn),
+				FunctionVersion: aws.StringValue(alias.FunctionVersion),
+				Description:     aws.StringValue(alias.Description),
+			}
+			if alias.RoutingConfig != nil && len(alias.RoutingConfig.AdditionalVersionWeights) > 0 {
+				described.AdditionalVersionWeights = aws.Float64ValueMap(alias.RoutingConfig.AdditionalVersionWeights)
+			}
+			description.Aliases = append(description.Aliases, described)
+		}
+		if output.NextMarker == nil {
+			break
+		}
+		aliasInput.Marker = output.NextMarker
+	}
+
+	description.Versions, err = ListVersions(svc, functionName)
+	if err != nil {
+		return nil, err
+	}
+
+	mappingInput := &lambda.ListEventSourceMappingsInput{FunctionName: aws.String(functionName)}
+	for {
+		output, err := svc.ListEventSourceMappings(mappingInput)
+		if err != nil {
+			return nil, err
+		}
+		for _, mapping := range output.EventSourceMappings {
+			described := EventSourceMapping{
+				UUID:                  aws.StringValue(mapping.UUID),
+				EventSourceArn:        aws.StringValue(mapping.EventSourceArn),
+				State:                 aws.StringValue(mapping.State),
+				StateTransitionReason: aws.StringValue(mapping.StateTransitionReason),
+				BatchSize:             aws.Int64Value(mapping.BatchSize),
+				LastProcessingResult:  aws.StringValue(mapping.LastProcessingResult),
+			}
+			if mapping.LastModified != nil {
+				described.LastModified = mapping.LastModified.UTC().Format(time.RFC3339)
+			}
+			description.EventSourceMappings = append(description.EventSourceMappings, described)
+		}
+		if output.NextMarker == nil {
+			break
+		}
+		mappingInput.Marker = output.NextMarker
+	}
+
+	tags, err := svc.ListTags(&lambda.ListTagsInput{Resource: function.Configuration.FunctionArn})
+	if err != nil {
+		return nil, err
+	}
+	description.Tags = aws.StringValueMap(tags.Tags)
+
+	// functions without a resource policy are reported as not found
+	policy, err := svc.GetPolicy(&lambda.GetPolicyInput{FunctionName: aws.String(functionName)})
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	if err == nil && policy.Policy != nil {
+		description.Policy = json.RawMessage(*policy.Policy)
+	}
+	return description, nil
+}
+
+func (d *FunctionDescription) String() string {
+	var b strings.Builder
+	config := d.Configuration
+	fmt.Fprintf(&b, "Function:      %s\n", d.FunctionName)
+	fmt.Fprintf(&b, "ARN:           %s\n", config.FunctionArn)
+	if config.PackageType == lambda.PackageTypeImage {
+		fmt.Fprintf(&b, "Image:         %s\n", d.Code.ImageUri)
+	} else {
+		fmt.Fprintf(&b, "Runtime:       %s, handler %s\n", config.Runtime, config.Handler)
+	}
+	fmt.Fprintf(&b, "Memory:        %d MB\n", config.MemorySize)
+	fmt.Fprintf(&b, "Timeout:       %d s\n", config.Timeout)
+	fmt.Fprintf(&b, "Code:          %d bytes, sha256 %s\n", config.CodeSize, config.CodeSha256)
+	fmt.Fprintf(&b, "Role:          %s\n", config.Role)
+	fmt.Fprintf(&b, "Last modified: %s\n", config.LastModified)
+	if config.State != "" {
+		fmt.Fprintf(&b, "State:         %s, last update %s\n", config.State, config.LastUpdateStatus)
+	}
+	if d.ReservedConcurrency != nil {
+		fmt.Fprintf(&b, "Concurrency:   %d reserved\n", *d.ReservedConcurrency)
+	} else {
+		fmt.Fprintf(&b, "Concurrency:   unreserved\n")
+	}
+
+	fmt.Fprintf(&b, "\nDescriptor:\n")
+	descriptor, _ := yaml.Marshal(yaml.MapSlice(d.Descriptor))
+	for _, line := range strings.Split(strings.TrimSuffix(string(descriptor), "\n"), "\n") {
+		fmt.Fprintf(&b, "  %s\n", line)
+	}
+
+	section := func(title string, empty bool) *tabwriter.Writer {
+		fmt.Fprintf(&b, "\n%s:\n", title)
+		if empty {
+			fmt.Fprintf(&b, "  none\n")
+		}
+		return tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	}
+
+	w := section("Aliases", len(d.Aliases) == 0)
+	for _, alias := range d.Aliases {
+		target := alias.FunctionVersion
+		versions := make([]string, 0, len(alias.AdditionalVersionWeights))
+		for version := range alias.AdditionalVersionWeights {
+			versions = append(versions, version)
+		}
+		sort.Strings(versions)
+		for _, version := range versions {
+			target += fmt.Sprintf(", %s at %g%%", version, alias.AdditionalVersionWeights[version]*100)
+		}
+		fmt.Fprintf(w, "  %s\t-> %s%s\n", alias.Name, target, column(alias.Description))
+	}
+	w.Flush()
+
+	w = section("Versions", len(d.Versions) == 0)
+	for _, version := range d.Versions {
+		fmt.Fprintf(w, "  %s\t%s\t%s%s\n", version.Version, version.CodeSha256, version.LastModified, column(version.Description))
+	}
+	w.Flush()
+
+	w = section("Event source mappings", len(d.EventSourceMappings) == 0)
+	for _, mapping := range d.EventSourceMappings {
+		fmt.Fprintf(w, "  %s\t%s\t%s\tbatch size %d\n", mapping.UUID, mapping.State, mapping.EventSourceArn, mapping.BatchSize)
+	}
+	w.Flush()
+
+	keys := make([]string, 0, len(d.Tags))
+	for key := range d.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	w = section("Tags", len(keys) == 0)
+	for _, key := range keys {
+		fmt.Fprintf(w, "  %s\t%s\n", key, d.Tags[key])
+	}
+	w.Flush()
+
+	section("Resource policy", d.Policy == nil)
+	if d.Policy != nil {
+		var policy bytes.Buffer
+		if err := json.Indent(&policy, d.Policy, "  ", "  "); err != nil {
+			policy.Write(d.Policy)
+		}
+		fmt.Fprintf(&b, "  %s\n", policy.String())
+	}
+	return b.String()
+}
+
+func describeConfiguration(config *lambda.FunctionConfiguration) FunctionConfiguration {
+	described := FunctionConfiguration{
+		FunctionArn:            aws.StringValue(config.FunctionArn),
+		Version:                aws.StringValue(config.Version),
+		PackageType:            aws.StringValue(config.PackageType),
+		Runtime:                aws.StringValue(config.Runtime),
+		Handler:                aws.StringValue(config.Handler),
+		Role:                   aws.StringValue(config.Role),
+		Description:            aws.StringValue(config.Description),
+		MemorySize:             aws.Int64Value(config.MemorySize),
+		Timeout:                aws.Int64Value(config.Timeout),
+		Environment:            make(map[string]string),
+		Layers:                 make([]string, 0, len(config.Layers)),
+		Architectures:          aws.StringValueSlice(config.Architectures),
+		KmsKeyArn:              aws.StringValue(config.KMSKeyArn),
+		CodeSize:               aws.Int64Value(config.CodeSize),
+		CodeSha256:             aws.StringValue(config.CodeSha256),
+		LastModified:           aws.StringValue(config.LastModified),
+		RevisionId:             aws.StringValue(config.RevisionId),
+		State:                  aws.StringValue(config.State),
+		StateReason:            aws.StringValue(config.StateReason),
+		LastUpdateStatus:       aws.StringValue(config.LastUpdateStatus),
+		LastUpdateStatusReason: aws.StringValue(config.LastUpdateStatusReason),
+	}
+	if described.PackageType == "" {
+		described.PackageType = lambda.PackageTypeZip
+	}
+	if config.Environment != nil {
+		described.Environment = aws.StringValueMap(config.Environment.Variables)
+	}
+	if vpc := config.VpcConfig; vpc != nil && len(vpc.SubnetIds) > 0 {
+		described.VpcConfig = &FunctionVpcConfig{
+			VpcId:            aws.StringValue(vpc.VpcId),
+			SubnetIds:        aws.StringValueSlice(vpc.SubnetIds),
+			SecurityGroupIds: aws.StringValueSlice(vpc.SecurityGroupIds),
+		}
+	}
+	for _, layer := range config.Layers {
+		described.Layers = append(described.Layers, aws.StringValue(layer.Arn))
+	}
+	if config.TracingConfig != nil {
+		described.TracingMode = aws.StringValue(config.TracingConfig.Mode)
+	}
+	return described
+}
+
+// A last, optional column, so rows without it have no trailing spaces.
+func column(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "\t" + value
+}
@@ -0,0 +1,140 @@
+package lambda_deploy
+
+import (
+	"encoding/json"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+	"gopkg.in/yaml.v2"
+)
+
+func TestDescribeFunction(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadTestDescriptor(t)
+	lambdaDesc.Publish = true
+	lambdaDesc.Aliases = map[string]*LambdaAliasDesc{"live": {Description: "production"}}
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	_, err := fake.PutFunctionConcurrency(&lambda.PutFunctionConcurrencyInput{
+		FunctionName:                 aws.String("python-hello"),
+		ReservedConcurrentExecutions: aws.Int64(5),
+	})
+	assert.NoError(t, err)
+	_, err = fake.CreateEventSourceMapping(&lambda.CreateEventSourceMappingInput{
+		FunctionName:   aws.String("python-hello"),
+		EventSourceArn: aws.String("arn:aws:sqs:us-east-1:123456789012:orders"),
+	})
+	assert.NoError(t, err)
+	_, err = fake.AddPermission(&lambda.AddPermissionInput{
+		FunctionName: aws.String("python-hello"),
+		StatementId:  aws.String("s3-invoke"),
+		Action:       aws.String("lambda:InvokeFunction"),
+		Principal:    aws.String("s3.amazonaws.com"),
+		SourceArn:    aws.String("arn:aws:s3:::uploads"),
+	})
+	assert.NoError(t, err)
+	_, err = fake.TagResource(&lambda.TagResourceInput{
+		Resource: aws.String(lambdatest.FunctionArn("python-hello")),
+		Tags:     map[string]*string{"team": aws.String("hello")},
+	})
+	assert.NoError(t, err)
+
+	description, err := DescribeFunction(fake, "python-hello", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "python-hello", description.FunctionName)
+	assert.Equal(t, int64(5), *description.ReservedConcurrency)
+	assert.Len(t, description.Aliases, 1)
+	assert.Equal(t, []FunctionVersion{{Version: "1", Aliases: []string{"live"}, CodeSha256: Base64sha256(testZip),
+		Description: description.Versions[0].Description, LastModified: description.Versions[0].LastModified}}, description.Versions)
+	assert.Len(t, description.EventSourceMappings, 1)
+	assert.Equal(t, map[string]string{"team": "hello"}, description.Tags)
+	assert.Equal(t, yaml.MapItem{Key: "function_name", Value: "python-hello"}, description.Descriptor[0])
+	assert.Contains(t, description.Descriptor, yaml.MapItem{Key: "timeout", Value: 3})
+	assert.Contains(t, description.Descriptor, yaml.MapItem{Key: "publish", Value: true})
+
+	out, err := json.Marshal(description)
+	assert.NoError(t, err)
+	var document map[string]interface{}
+	assert.NoError(t, json.Unmarshal(out, &document))
+	assert.Equal(t, "python-hello", document["descriptor"].(map[string]interface{})["function_name"])
+	assert.Equal(t, "production", document["descriptor"].(map[string]interface{})["aliases"].(map[string]interface{})["live"].(map[string]interface{})["description"])
+	assert.Contains(t, string(out), `"descriptor":{"function_name":"python-hello","description":"python hello world",`)
+	assert.Equal(t, "s3-invoke", document["policy"].(map[string]interface{})["Statement"].([]interface{})[0].(map[string]interface{})["Sid"])
+	configuration := document["configuration"].(map[string]interface{})
+	assert.Equal(t, lambdatest.FunctionArn("python-hello"), configuration["function_arn"])
+	assert.Equal(t, Base64sha256(testZip), configuration["code_sha256"])
+	assert.Equal(t, "python_hello.handler", configuration["handler"])
+	assert.Equal(t, map[string]interface{}{"repository_type": "S3"}, document["code"], "without the presigned location")
+	assert.Equal(t, "1", document["aliases"].([]interface{})[0].(map[string]interface{})["function_version"])
+	assert.Equal(t, []interface{}{"live"}, document["versions"].([]interface{})[0].(map[string]interface{})["aliases"])
+	assert.Equal(t, "arn:aws:sqs:us-east-1:123456789012:orders", document["event_source_mappings"].([]interface{})[0].(map[string]interface{})["event_source_arn"])
+	assert.NotContains(t, string(out), "Location")
+
+	text := description.String()
+	assert.Contains(t, text, "Function:      python-hello\n")
+	assert.Contains(t, text, "Concurrency:   5 reserved\n")
+	assert.Contains(t, text, "\nDescriptor:\n  function_name: python-hello\n")
+	assert.Contains(t, text, "  live  -> 1  production\n")
+	assert.Contains(t, text, "arn:aws:sqs:us-east-1:123456789012:orders  batch size 10\n")
+	assert.Contains(t, text, "  team  hello\n")
+	assert.Contains(t, text, `"Sid": "s3-invoke"`)
+}
+
+func TestDescribeFunctionWithoutExtras(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	assert.NoError(t, LambdaDeploy(fake, testZip, loadTestDescriptor(t)))
+	description, err := DescribeFunction(fake, "python-hello", false)
+	assert.NoError(t, err)
+	assert.Nil(t, description.ReservedConcurrency)
+	assert.Nil(t, description.Policy)
+	assert.Empty(t, description.Aliases)
+
+	out, err := json.Marshal(description)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), `"reserved_concurrency":null,"aliases":[],`)
+	assert.Contains(t, string(out), `"event_source_mappings":[],"tags":{},"policy":null}`)
+	text := description.String()
+	assert.Contains(t, text, "Concurrency:   unreserved\n")
+	assert.Contains(t, text, "\nAliases:\n  none\n")
+	assert.Contains(t, text, "\nResource policy:\n  none\n")
+
+	_, err = DescribeFunction(fake, "missing", false)
+	assert.IsType(t, &FunctionNotFoundError{}, err)
+}
+
+func TestDescribeMasksEnvironment(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadTestDescriptor(t)
+	lambdaDesc.Publish = true
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+
+	description, err := DescribeFunction(fake, "python-hello", false)
+	assert.NoError(t, err)
+	assert.Equal(t, MaskedValue, description.Configuration.Environment["envVar"])
+	assert.Contains(t, description.Descriptor, yaml.MapItem{Key: "environment", Value: yaml.MapSlice{{Key: "envVar", Value: MaskedValue}}})
+	out, err := json.Marshal(description)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(out), "yolatengo")
+	assert.NotContains(t, description.String(), "yolatengo")
+	config, err := fake.GetFunctionConfiguration(&lambda.GetFunctionConfigurationInput{FunctionName: aws.String("python-hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, "yolatengo", *config.Environment.Variables["envVar"], "the function itself is left alone")
+
+	description, err = DescribeFunction(fake, "python-hello", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "yolatengo", description.Configuration.Environment["envVar"])
+	assert.Contains(t, description.String(), "envVar: yolatengo")
+}
+
+func TestDescribeSortsVersionWeights(t *testing.T) {
+	description := &FunctionDescription{
+		FunctionName: "python-hello",
+		Aliases: []FunctionAlias{{
+			Name:                     "live",
+			FunctionVersion:          "1",
+			AdditionalVersionWeights: map[string]float64{"4": 0.05, "2": 0.1, "3": 0.25},
+		}},
+	}
+	assert.Contains(t, description.String(), "live  -> 1, 2 at 10%, 3 at 25%, 4 at 5%\n")
+}
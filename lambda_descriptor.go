@@ -6,22 +6,31 @@ Processes descriptor for lambda
 import (
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
-	"log"
-	"github.com/aws/aws-sdk-go/service/lambda"
 	"errors"
-	"strings"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 )
 
-func check(e error) {
-	if e != nil {
-		log.Fatalf("error: %v", e)
-		panic(e)
-	}
+// A descriptor holds either a single function under lambda:, or several
+// under functions:, keyed by name. The settings in defaults: are merged into
+// every function under functions:.
+// Stages holds overlays (e.g. dev, prod) that are merged on top of the rest
+// of the descriptor when selected, and Aws the settings for the AWS client.
+type LambdaDescriptor struct {
+	Aws       *ClientConfig
+	Lambda    *LambdaFunctionDesc
+	Defaults  *LambdaFunctionDesc
+	Functions map[string]*LambdaFunctionDesc
+	Stages    map[string]*LambdaDescriptor
 }
 
-type LambdaDescriptor struct {
-	Lambda LambdaFunctionDesc
+// Options for loading descriptors.
+type DescriptorOptions struct {
+	Stage string            // name of the overlay under stages: to apply, if any
+	Vars  map[string]string // values for ${var:name} references
 }
 
 type LambdaFunctionDesc struct {
@@ -33,8 +42,42 @@ type LambdaFunctionDesc struct {
 	Memory_size int
 	Timeout int
 	Publish bool  //default for bool is false, which fits in this case
+	Aliases map[string]*LambdaAliasDesc // pointed at the published version, requires publish
 	Environment map[string]string
 	Vpc_config *LambdaVpcConfig
+	Source *LambdaSourceDesc // to build the zip from, instead of giving one
+	Code *LambdaCodeDesc // to deploy the code through S3
+	Package_type string // Zip (default) or Image
+	Image_uri string
+	Image_config *LambdaImageConfig
+	Smoke_tests []*LambdaSmokeTest // run by deploy against the new version
+	Rollback_on_smoke_failure bool // requires publish, republishes the previous version of a function without aliases
+
+	secrets map[string]bool // environment variables resolved by ResolveSecrets
+	set     map[string]bool // boolean fields given in the YAML, so an explicit false overrides when merging
+}
+
+// The boolean fields, which can not tell an explicit false from unset.
+var descriptorBoolFields = []string{"publish", "rollback_on_smoke_failure"}
+
+func (l *LambdaFunctionDesc) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain LambdaFunctionDesc
+	if err := unmarshal((*plain)(l)); err != nil {
+		return err
+	}
+	fields := map[string]interface{}{}
+	if err := unmarshal(&fields); err != nil {
+		return err
+	}
+	for _, field := range descriptorBoolFields {
+		if _, ok := fields[field]; ok {
+			if l.set == nil {
+				l.set = make(map[string]bool)
+			}
+			l.set[field] = true
+		}
+	}
+	return nil
 }
 
 type LambdaVpcConfig struct {
@@ -56,10 +99,10 @@ func (l *LambdaFunctionDesc) Validate() error {
 	if l.Function_name == "" {
 		errorList = append(errorList, "Missing function_name")
 	}
-	if l.Handler == "" {
+	if l.Handler == "" && !l.IsImage() {
 		errorList = append(errorList, "Missing handler")
 	}
-	if l.Runtime == "" {
+	if l.Runtime == "" && !l.IsImage() {
 		errorList = append(errorList, "Missing runtime")
 	}
 	if l.Role == "" {
@@ -73,8 +116,14 @@ func (l *LambdaFunctionDesc) Validate() error {
 			errorList = append(errorList, "There must be at least 1 vpc subnet id")
 		}
 	}
+	if l.Code != nil {
+		errorList = append(errorList, l.Code.validate()...)
+	}
+	errorList = append(errorList, l.validateImage()...)
+	errorList = append(errorList, l.validateAliases()...)
+	errorList = append(errorList, l.validateSmokeTests()...)
 	if len(errorList) > 0 {
-		return errors.New("Descriptor error: " + strings.Join(errorList, ","))
+		return &DescriptorValidationError{Errors: errorList}
 	}
 	return nil
 }
@@ -140,8 +189,12 @@ func (d *LambdaFunctionDesc) CompareConfig(functionConfig *lambda.FunctionConfig
 			isDifferent = true
 		}
 	}
-	err := input.Validate()
-	check(err)
+	if d.IsImage() {
+		if newImageConfig, isDiff := d.CompareImageConfig(functionConfig.ImageConfigResponse); isDiff {
+			input.SetImageConfig(newImageConfig)
+			isDifferent = true
+		}
+	}
 	return &input, isDifferent
 }
 
@@ -217,23 +270,172 @@ func compareSlices(descriptor []string, other []*string) (bool) {
 	return  isSame
 }
 
-func LoadDescriptorFile(filename string) (*LambdaFunctionDesc) {
-	data, err := ioutil.ReadFile(filename)
-	check(err)
-	return LoadDescriptor(data)
+func LoadDescriptorFile(filename string) (*LambdaDescriptor, error) {
+	return LoadDescriptorFiles([]string{filename}, nil)
 }
 
-func unmarshalDescriptor(contents []byte) (*LambdaDescriptor) {
+// Loads several descriptor files and merges them in order, so each file
+// overrides the ones before it. The stage overlay is applied after that, and
+// the result is validated.
+func LoadDescriptorFiles(filenames []string, options *DescriptorOptions) (*LambdaDescriptor, error) {
+	if len(filenames) == 0 {
+		return nil, errors.New("Missing descriptor")
+	}
+	var lambdaParent *LambdaDescriptor
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		data, err = interpolateDescriptor(data, options, filepath.Dir(filename))
+		if err != nil {
+			return nil, err
+		}
+		layer, err := unmarshalDescriptor(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		layer.resolvePaths(filepath.Dir(filename))
+		lambdaParent = mergeDescriptor(lambdaParent, layer)
+	}
+	return loadDescriptor(lambdaParent, options)
+}
+
+func unmarshalDescriptor(contents []byte) (*LambdaDescriptor, error) {
 	lambdaParent := LambdaDescriptor{}
 	err := yaml.Unmarshal([]byte(contents), &lambdaParent)
-	check(err)
-	return &lambdaParent
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse descriptor: %s", err)
+	}
+	return &lambdaParent, nil
 }
 
-func LoadDescriptor(contents []byte) (*LambdaFunctionDesc) {
-	lambdaParent := unmarshalDescriptor(contents)
-	lambdaParent.Lambda.SetDefaults()
-	err := lambdaParent.Lambda.Validate()
-	check(err)
-	return &lambdaParent.Lambda
+func LoadDescriptor(contents []byte) (*LambdaDescriptor, error) {
+	return LoadDescriptorWithOptions(contents, nil)
+}
+
+// References to files and git are resolved relative to the working directory.
+func LoadDescriptorWithOptions(contents []byte, options *DescriptorOptions) (*LambdaDescriptor, error) {
+	contents, err := interpolateDescriptor(contents, options, ".")
+	if err != nil {
+		return nil, err
+	}
+	lambdaParent, err := unmarshalDescriptor(contents)
+	if err != nil {
+		return nil, err
+	}
+	return loadDescriptor(lambdaParent, options)
+}
+
+func loadDescriptor(lambdaParent *LambdaDescriptor, options *DescriptorOptions) (*LambdaDescriptor, error) {
+	if options != nil && options.Stage != "" {
+		overlay, ok := lambdaParent.Stages[options.Stage]
+		if !ok {
+			return nil, fmt.Errorf("Stage %q is not in the descriptor", options.Stage)
+		}
+		lambdaParent = mergeDescriptor(lambdaParent, overlay)
+	}
+	err := lambdaParent.prepare()
+	if err != nil {
+		return nil, err
+	}
+	return lambdaParent, nil
+}
+
+// Merges the defaults into each function, then sets defaults on and validates
+// every function. A single lambda: is put into Functions as well, so callers
+// only need to look there.
+func (d *LambdaDescriptor) prepare() error {
+	if d.Lambda != nil && len(d.Functions) > 0 {
+		return &DescriptorValidationError{Errors: []string{"Use either lambda or functions - but not both"}}
+	}
+	if d.Lambda == nil && len(d.Functions) == 0 {
+		return &DescriptorValidationError{Errors: []string{"Missing lambda or functions"}}
+	}
+	if d.Lambda != nil {
+		d.Lambda.SetDefaults()
+		if err := d.Lambda.Validate(); err != nil {
+			return err
+		}
+		d.Functions = map[string]*LambdaFunctionDesc{d.Lambda.Function_name: d.Lambda}
+		return nil
+	}
+
+	errorList := make([]string, 0)
+	for _, name := range d.FunctionNames() {
+		function := mergeFunctionDesc(d.Defaults, d.Functions[name])
+		if function.Function_name == "" {
+			function.Function_name = name
+		}
+		function.SetDefaults()
+		if err := function.Validate(); err != nil {
+			for _, msg := range err.(*DescriptorValidationError).Errors {
+				errorList = append(errorList, name+": "+msg)
+			}
+		}
+		d.Functions[name] = function
+	}
+	if len(errorList) > 0 {
+		return &DescriptorValidationError{Errors: errorList}
+	}
+	return nil
+}
+
+// Makes the relative paths in source: blocks relative to dir instead.
+func (d *LambdaDescriptor) resolvePaths(dir string) {
+	functions := []*LambdaFunctionDesc{d.Lambda, d.Defaults}
+	for _, function := range d.Functions {
+		functions = append(functions, function)
+	}
+	for _, function := range functions {
+		if function == nil || function.Source == nil {
+			continue
+		}
+		source := function.Source
+		if source.Directory != "" && !filepath.IsAbs(source.Directory) {
+			source.Directory = filepath.Join(dir, source.Directory)
+		}
+		for zipPath, file := range source.Extra_files {
+			if !filepath.IsAbs(file) {
+				source.Extra_files[zipPath] = filepath.Join(dir, file)
+			}
+		}
+	}
+	for _, stage := range d.Stages {
+		stage.resolvePaths(dir)
+	}
+}
+
+// Returns the keys of Functions, sorted.
+func (d *LambdaDescriptor) FunctionNames() []string {
+	names := make([]string, 0, len(d.Functions))
+	for name := range d.Functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the functions with the given names (the keys under functions:, or
+// the function_name), or all of them sorted by name when no names are given.
+func (d *LambdaDescriptor) Select(names ...string) ([]*LambdaFunctionDesc, error) {
+	if len(names) == 0 {
+		names = d.FunctionNames()
+	}
+	selected := make([]*LambdaFunctionDesc, 0, len(names))
+	for _, name := range names {
+		function, ok := d.Functions[name]
+		if !ok {
+			for _, other := range d.Functions {
+				if other.Function_name == name {
+					function, ok = other, true
+				}
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("Function %q is not in the descriptor", name)
+		}
+		selected = append(selected, function)
+	}
+	return selected, nil
 }
@@ -0,0 +1,182 @@
+package lambda_deploy
+
+// Returns a new function descriptor with the settings of override on top of
+// base. Environment variables are merged key by key, and the subnets and
+// security groups of the vpc config are taken from override when it sets them.
+// Booleans are taken from override when it is true or sets them explicitly.
+// Either may be nil.
+func mergeFunctionDesc(base, override *LambdaFunctionDesc) *LambdaFunctionDesc {
+	merged := LambdaFunctionDesc{}
+	if base != nil {
+		merged = *base
+		merged.Environment = mergeEnvironment(nil, base.Environment)
+		merged.Vpc_config = mergeVpcConfig(nil, base.Vpc_config)
+		merged.set = mergeSet(nil, base.set)
+	}
+	if override == nil {
+		return &merged
+	}
+	if override.Function_name != "" {
+		merged.Function_name = override.Function_name
+	}
+	if override.Description != "" {
+		merged.Description = override.Description
+	}
+	if override.Handler != "" {
+		merged.Handler = override.Handler
+	}
+	if override.Runtime != "" {
+		merged.Runtime = override.Runtime
+	}
+	if override.Role != "" {
+		merged.Role = override.Role
+	}
+	if override.Memory_size != 0 {
+		merged.Memory_size = override.Memory_size
+	}
+	if override.Timeout != 0 {
+		merged.Timeout = override.Timeout
+	}
+	if override.Publish || override.set["publish"] {
+		merged.Publish = override.Publish
+	}
+	if override.Source != nil {
+		merged.Source = override.Source
+	}
+	if override.Code != nil {
+		merged.Code = override.Code
+	}
+	if override.Package_type != "" {
+		merged.Package_type = override.Package_type
+	}
+	if override.Image_uri != "" {
+		merged.Image_uri = override.Image_uri
+	}
+	if override.Image_config != nil {
+		merged.Image_config = override.Image_config
+	}
+	if override.Smoke_tests != nil {
+		merged.Smoke_tests = override.Smoke_tests
+	}
+	if override.Rollback_on_smoke_failure || override.set["rollback_on_smoke_failure"] {
+		merged.Rollback_on_smoke_failure = override.Rollback_on_smoke_failure
+	}
+	if len(override.Aliases) > 0 {
+		aliases := make(map[string]*LambdaAliasDesc, len(merged.Aliases)+len(override.Aliases))
+		for name, alias := range merged.Aliases {
+			aliases[name] = alias
+		}
+		for name, alias := range override.Aliases {
+			aliases[name] = alias
+		}
+		merged.Aliases = aliases
+	}
+	merged.Environment = mergeEnvironment(merged.Environment, override.Environment)
+	merged.Vpc_config = mergeVpcConfig(merged.Vpc_config, override.Vpc_config)
+	merged.set = mergeSet(merged.set, override.set)
+	return &merged
+}
+
+func mergeSet(base, override map[string]bool) map[string]bool {
+	if base == nil && override == nil {
+		return nil
+	}
+	merged := make(map[string]bool, len(base)+len(override))
+	for k := range base {
+		merged[k] = true
+	}
+	for k := range override {
+		merged[k] = true
+	}
+	return merged
+}
+
+func mergeEnvironment(base, override map[string]string) map[string]string {
+	if base == nil && override == nil {
+		return nil
+	}
+	merged := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
+
+func mergeVpcConfig(base, override *LambdaVpcConfig) *LambdaVpcConfig {
+	if base == nil && override == nil {
+		return nil
+	}
+	merged := LambdaVpcConfig{}
+	if base != nil {
+		merged.Subnet_ids = append([]string(nil), base.Subnet_ids...)
+		merged.Security_group_ids = append([]string(nil), base.Security_group_ids...)
+	}
+	if override != nil {
+		if override.Subnet_ids != nil {
+			merged.Subnet_ids = append([]string(nil), override.Subnet_ids...)
+		}
+		if override.Security_group_ids != nil {
+			merged.Security_group_ids = append([]string(nil), override.Security_group_ids...)
+		}
+	}
+	return &merged
+}
+
+// Returns a new descriptor with override layered on top of base. Functions
+// are merged by key with mergeFunctionDesc, and so are stages.
+func mergeDescriptor(base, override *LambdaDescriptor) *LambdaDescriptor {
+	if base == nil {
+		base = &LambdaDescriptor{}
+	}
+	if override == nil {
+		override = &LambdaDescriptor{}
+	}
+	merged := LambdaDescriptor{
+		Aws: mergeClientConfig(base.Aws, override.Aws),
+	}
+	if base.Lambda != nil || override.Lambda != nil {
+		merged.Lambda = mergeFunctionDesc(base.Lambda, override.Lambda)
+	}
+	if base.Defaults != nil || override.Defaults != nil {
+		merged.Defaults = mergeFunctionDesc(base.Defaults, override.Defaults)
+	}
+	if base.Functions != nil || override.Functions != nil {
+		merged.Functions = make(map[string]*LambdaFunctionDesc)
+		for name, function := range base.Functions {
+			merged.Functions[name] = mergeFunctionDesc(function, override.Functions[name])
+		}
+		for name, function := range override.Functions {
+			if _, ok := base.Functions[name]; !ok {
+				merged.Functions[name] = mergeFunctionDesc(nil, function)
+			}
+		}
+	}
+	if base.Stages != nil || override.Stages != nil {
+		merged.Stages = make(map[string]*LambdaDescriptor)
+		for name, stage := range base.Stages {
+			merged.Stages[name] = stage
+		}
+		for name, stage := range override.Stages {
+			if baseStage, ok := base.Stages[name]; ok {
+				stage = mergeDescriptor(baseStage, stage)
+			}
+			merged.Stages[name] = stage
+		}
+	}
+	return &merged
+}
+
+func mergeClientConfig(base, override *ClientConfig) *ClientConfig {
+	if base == nil && override == nil {
+		return nil
+	}
+	merged := ClientConfig{}
+	if override != nil {
+		merged = *override
+	}
+	merged.Merge(base)
+	return &merged
+}
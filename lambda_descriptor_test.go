@@ -9,33 +9,46 @@ import (
 
 func TestLoadDescriptor(t *testing.T) {
 	fileName := "./testdata/test1/lambda-desc.yml"
-	lambdaDesc := LoadDescriptorFile(fileName)
-	assert.Equal(t, lambdaDesc.Function_name, "python-hello", "should be equal")
+	lambdaDesc, err := LoadDescriptorFile(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, lambdaDesc.Lambda.Function_name, "python-hello", "should be equal")
 }
 
 func TestLoadDescriptorNoVpc(t *testing.T) {
 	fileName := "./testdata/test1/lambda-desc.yml"
-	lambdaDesc := LoadDescriptorFile(fileName)
-	assert.Nil(t,lambdaDesc.Vpc_config, "should be nil")
+	lambdaDesc, err := LoadDescriptorFile(fileName)
+	assert.NoError(t, err)
+	assert.Nil(t,lambdaDesc.Lambda.Vpc_config, "should be nil")
 }
 
 func TestLoadDescriptorWithVpc(t *testing.T) {
 	fileName := "./testdata/descriptors/vpc-descriptor.yml"
-	lambdaDesc := LoadDescriptorFile(fileName)
-	assert.NotNil(t,lambdaDesc.Vpc_config, "should not be nil")
-	assert.Len(t, (lambdaDesc.Vpc_config).Subnet_ids, 2)
-	assert.Len(t, (lambdaDesc.Vpc_config).Security_group_ids, 2)
+	lambdaDesc, err := LoadDescriptorFile(fileName)
+	assert.NoError(t, err)
+	assert.NotNil(t,lambdaDesc.Lambda.Vpc_config, "should not be nil")
+	assert.Len(t, (lambdaDesc.Lambda.Vpc_config).Subnet_ids, 2)
+	assert.Len(t, (lambdaDesc.Lambda.Vpc_config).Security_group_ids, 2)
 }
 
 func TestLoadDescriptorWithBadVpc1(t *testing.T) {
 	fileName := "./testdata/descriptors/vpc-descriptor-bad1.yml"
 	data, err := ioutil.ReadFile(fileName)
-	check(err)
-	lambdaParent := unmarshalDescriptor(data)
+	assert.NoError(t, err)
+	lambdaParent, err := unmarshalDescriptor(data)
+	assert.NoError(t, err)
 	error := lambdaParent.Lambda.Validate()
 	assert.Error(t, error, "There should be an error ")
 }
 
+func TestLoadDescriptorWithBadVpc1ReturnsValidationError(t *testing.T) {
+	fileName := "./testdata/descriptors/vpc-descriptor-bad1.yml"
+	lambdaDesc, err := LoadDescriptorFile(fileName)
+	assert.Nil(t, lambdaDesc)
+	validationErr, ok := err.(*DescriptorValidationError)
+	assert.True(t, ok, "should be a validation error")
+	assert.Equal(t, []string{"There must be at least 1 vpc security group id"}, validationErr.Errors)
+}
+
 
 func TestCompareDescriptor1(t *testing.T) {
 	lambdaDesc := LambdaFunctionDesc{Function_name:"my-function"}
@@ -153,6 +166,7 @@ func TestCompareValidate2(t *testing.T) {
 	desc.Function_name = "test"
 	err := desc.Validate()
 	assert.NotNil(t, err, "There should be an error here")
+	assert.Len(t, err.(*DescriptorValidationError).Errors, 3)
 }
 
 func TestCompareVpcDescriptorHasNone(t *testing.T) {
@@ -240,4 +254,133 @@ func TestCompareVpcBothHaveDiffSub2(t *testing.T) {
 
 	_, isDifferent := lambdaDesc.CompareVpcConfig(&response)
 	assert.True(t, isDifferent, "Should be different")
-}
\ No newline at end of file
+}
+func TestLoadMultiDescriptor(t *testing.T) {
+	lambdaDesc, err := LoadDescriptorFile("./testdata/descriptors/multi-descriptor.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"orders-api", "orders-worker"}, lambdaDesc.FunctionNames())
+
+	api := lambdaDesc.Functions["orders-api"]
+	assert.Equal(t, "orders-api", api.Function_name)
+	assert.Equal(t, "python2.7", api.Runtime)
+	assert.Equal(t, 256, api.Memory_size)
+	assert.Equal(t, 3, api.Timeout)
+	assert.Equal(t, map[string]string{"stage": "dev", "logLevel": "debug"}, api.Environment)
+	assert.Equal(t, []string{"sg1"}, api.Vpc_config.Security_group_ids)
+
+	worker := lambdaDesc.Functions["orders-worker"]
+	assert.Equal(t, "orders-worker-v2", worker.Function_name)
+	assert.Equal(t, 1024, worker.Memory_size)
+	assert.Equal(t, map[string]string{"stage": "dev", "logLevel": "info"}, worker.Environment)
+	assert.Equal(t, []string{"subnet1", "subnet2"}, worker.Vpc_config.Subnet_ids)
+	assert.Equal(t, []string{"sg2"}, worker.Vpc_config.Security_group_ids)
+}
+
+func TestSelectFunctions(t *testing.T) {
+	lambdaDesc, err := LoadDescriptorFile("./testdata/descriptors/multi-descriptor.yml")
+	assert.NoError(t, err)
+	all, err := lambdaDesc.Select()
+	assert.NoError(t, err)
+	assert.Len(t, all, 2)
+	selected, err := lambdaDesc.Select("orders-worker-v2")
+	assert.NoError(t, err)
+	assert.Equal(t, "worker.handler", selected[0].Handler)
+	_, err = lambdaDesc.Select("missing")
+	assert.Error(t, err)
+}
+
+func TestSelectSingleFunction(t *testing.T) {
+	lambdaDesc, err := LoadDescriptorFile("./testdata/test1/lambda-desc.yml")
+	assert.NoError(t, err)
+	selected, err := lambdaDesc.Select()
+	assert.NoError(t, err)
+	assert.Equal(t, []*LambdaFunctionDesc{lambdaDesc.Lambda}, selected)
+}
+
+func TestLoadMultiDescriptorValidatesEachFunction(t *testing.T) {
+	_, err := LoadDescriptorFile("./testdata/descriptors/multi-descriptor-bad.yml")
+	assert.Equal(t, []string{"orders-api: Missing role", "orders-worker: Missing handler"},
+		err.(*DescriptorValidationError).Errors)
+}
+
+func TestLoadDescriptorWithLambdaAndFunctions(t *testing.T) {
+	_, err := LoadDescriptor([]byte("lambda:\n  function_name: a\nfunctions:\n  b:\n    handler: b\n"))
+	assert.Error(t, err)
+	_, err = LoadDescriptor([]byte("defaults:\n  runtime: java8\n"))
+	assert.Error(t, err)
+}
+
+func TestLoadDescriptorWithoutStage(t *testing.T) {
+	lambdaDesc, err := LoadDescriptorFile("./testdata/descriptors/stages-descriptor.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::111111111111:role/basic-lambda-role", lambdaDesc.Lambda.Role)
+	assert.Equal(t, 128, lambdaDesc.Lambda.Memory_size)
+	assert.Equal(t, "", lambdaDesc.Aws.Assume_role_arn)
+}
+
+func TestLoadDescriptorWithStage(t *testing.T) {
+	lambdaDesc, err := LoadDescriptorFiles([]string{"./testdata/descriptors/stages-descriptor.yml"},
+		&DescriptorOptions{Stage: "prod"})
+	assert.NoError(t, err)
+	prod := lambdaDesc.Lambda
+	assert.Equal(t, "arn:aws:iam::222222222222:role/basic-lambda-role", prod.Role)
+	assert.Equal(t, 512, prod.Memory_size)
+	assert.Equal(t, "python_hello.handler", prod.Handler)
+	assert.Equal(t, map[string]string{"stage": "prod", "logLevel": "debug"}, prod.Environment)
+	assert.Equal(t, []string{"prod-subnet1"}, prod.Vpc_config.Subnet_ids)
+	assert.Equal(t, []string{"sg1"}, prod.Vpc_config.Security_group_ids)
+	assert.Equal(t, "eu-west-1", lambdaDesc.Aws.Region)
+	assert.Equal(t, "arn:aws:iam::222222222222:role/deployer", lambdaDesc.Aws.Assume_role_arn)
+}
+
+func TestLoadDescriptorWithMissingStage(t *testing.T) {
+	_, err := LoadDescriptorFiles([]string{"./testdata/descriptors/stages-descriptor.yml"},
+		&DescriptorOptions{Stage: "qa"})
+	assert.Error(t, err)
+}
+
+func TestLoadLayeredDescriptors(t *testing.T) {
+	lambdaDesc, err := LoadDescriptorFiles([]string{
+		"./testdata/descriptors/stages-descriptor.yml",
+		"./testdata/descriptors/prod-layer.yml",
+	}, &DescriptorOptions{Stage: "prod"})
+	assert.NoError(t, err)
+	assert.Equal(t, 512, lambdaDesc.Lambda.Memory_size, "the stage is applied after the layers")
+	assert.Equal(t, map[string]string{"stage": "prod", "logLevel": "warn"}, lambdaDesc.Lambda.Environment)
+}
+
+func TestLayeredDescriptorsAreValidatedAfterMerge(t *testing.T) {
+	lambdaDesc, err := LoadDescriptorFiles([]string{
+		"./testdata/descriptors/vpc-descriptor-bad1.yml",
+		"./testdata/descriptors/vpc-descriptor.yml",
+	}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, lambdaDesc.Lambda.Vpc_config.Security_group_ids, 2)
+}
+
+func TestStageCanTurnOffBooleans(t *testing.T) {
+	contents := []byte(`
+lambda:
+  function_name: python-hello
+  handler: python_hello.handler
+  runtime: python2.7
+  role: arn:aws:iam::111111111111:role/basic-lambda-role
+  publish: true
+stages:
+  dev:
+    lambda:
+      publish: false
+  prod:
+    lambda:
+      memory_size: 512
+`)
+	dev, err := LoadDescriptorWithOptions(contents, &DescriptorOptions{Stage: "dev"})
+	assert.NoError(t, err)
+	assert.False(t, dev.Lambda.Publish, "an explicit false in the stage wins")
+	prod, err := LoadDescriptorWithOptions(contents, &DescriptorOptions{Stage: "prod"})
+	assert.NoError(t, err)
+	assert.True(t, prod.Lambda.Publish, "a stage that does not set publish keeps it")
+
+	merged := mergeFunctionDesc(&LambdaFunctionDesc{Publish: false}, &LambdaFunctionDesc{Publish: true})
+	assert.True(t, merged.Publish)
+}
@@ -0,0 +1,128 @@
+package lambda_deploy
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// Downloads the code of a version or alias of the function, $LATEST without
+// qualifier, to path with client, see DownloadFunctionCode. Returns the
+// configuration of what was downloaded.
+func DownloadLambda(svc LambdaAPI, client *http.Client, functionName, qualifier, path string) (*lambda.FunctionConfiguration, error) {
+	input := &lambda.GetFunctionInput{FunctionName: aws.String(functionName)}
+	if qualifier != "" {
+		input.Qualifier = aws.String(qualifier)
+	}
+	function, err := svc.GetFunction(input)
+	if err != nil {
+		if isNotFound(err) && qualifier != "" {
+			return nil, &FunctionNotFoundError{FunctionName: functionName + ":" + qualifier, Err: err}
+		}
+		if isNotFound(err) {
+			return nil, &FunctionNotFoundError{FunctionName: functionName, Err: err}
+		}
+		return nil, err
+	}
+	if err := DownloadFunctionCode(client, function, path); err != nil {
+		return nil, err
+	}
+	return function.Configuration, nil
+}
+
+// Downloads the code of the function from the presigned Code.Location to
+// path, checking it against the CodeSha256 of the function. The location is
+// fetched with client, so it goes through the same proxy as the AWS calls; nil
+// means http.DefaultClient.
+func DownloadFunctionCode(client *http.Client, function *lambda.GetFunctionOutput, path string) error {
+	functionName := aws.StringValue(function.Configuration.FunctionName)
+	if aws.StringValue(function.Configuration.PackageType) == lambda.PackageTypeImage {
+		return fmt.Errorf("%s is deployed from an image, there is no zip to download", functionName)
+	}
+	if function.Code == nil || function.Code.Location == nil {
+		return fmt.Errorf("There is no code location for %s", functionName)
+	}
+	code, err := downloadCode(client, *function.Code.Location)
+	if err != nil {
+		return err
+	}
+	expected := aws.StringValue(function.Configuration.CodeSha256)
+	if sha := base64sha256Bytes(code); sha != expected {
+		return fmt.Errorf("The downloaded code of %s has sha256 %s, expected %s", functionName, sha, expected)
+	}
+	return ioutil.WriteFile(path, code, 0644)
+}
+
+// Fetches the code of a function from the location GetFunction returns.
+func downloadCode(client *http.Client, location string) ([]byte, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading the code failed: %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Extracts zipfile into dir, which is created when needed. Entries that would
+// end up outside dir are refused.
+func ExtractZip(zipfile, dir string) error {
+	reader, err := zip.OpenReader(zipfile)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		path := filepath.Join(dir, file.Name)
+		if path != filepath.Clean(dir) && !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("%s has an entry outside the directory: %s", zipfile, file.Name)
+		}
+		if file.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := extractFile(file, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(file *zip.File, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	in, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	mode := file.Mode().Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
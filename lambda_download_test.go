@@ -0,0 +1,79 @@
+package lambda_deploy
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+)
+
+func TestDownloadLambda(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadTestDescriptor(t)
+	lambdaDesc.Publish = true
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	// any other file will do as the second version of the code
+	assert.NoError(t, LambdaDeploy(fake, "./testdata/descriptors/vpc-descriptor.yml", lambdaDesc))
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.zip")
+	config, err := DownloadLambda(fake, fake.HttpClient(), "python-hello", "1", out)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", *config.Version)
+	assert.Equal(t, Base64sha256(testZip), Base64sha256(out))
+
+	config, err = DownloadLambda(fake, fake.HttpClient(), "python-hello", "", out)
+	assert.NoError(t, err)
+	assert.Equal(t, "$LATEST", *config.Version)
+	assert.Equal(t, Base64sha256("./testdata/descriptors/vpc-descriptor.yml"), Base64sha256(out))
+
+	_, err = DownloadLambda(fake, fake.HttpClient(), "python-hello", "9", out)
+	assert.IsType(t, &FunctionNotFoundError{}, err)
+	assert.Equal(t, "python-hello:9", err.(*FunctionNotFoundError).FunctionName)
+}
+
+// Answers every request with code that does not match any function.
+type corruptingTransport struct{}
+
+func (corruptingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Header: make(http.Header),
+		Body: ioutil.NopCloser(strings.NewReader("corrupted")), Request: req}, nil
+}
+
+func TestDownloadLambdaChecksSha(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	client := &http.Client{Transport: corruptingTransport{}}
+	assert.NoError(t, LambdaDeploy(fake, testZip, loadTestDescriptor(t)))
+	out := filepath.Join(t.TempDir(), "out.zip")
+	_, err := DownloadLambda(fake, client, "python-hello", "", out)
+	assert.EqualError(t, err, "The downloaded code of python-hello has sha256 "+
+		base64sha256Bytes([]byte("corrupted"))+", expected "+Base64sha256(testZip))
+	_, err = os.Stat(out)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExtractZip(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, ExtractZip(testZip, filepath.Join(dir, "code")))
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "code", "python_hello.py"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(contents), "def handler")
+
+	evil := filepath.Join(dir, "evil.zip")
+	file, err := os.Create(evil)
+	assert.NoError(t, err)
+	writer := zip.NewWriter(file)
+	entry, _ := writer.Create("../outside.txt")
+	entry.Write([]byte("gotcha"))
+	assert.NoError(t, writer.Close())
+	file.Close()
+	err = ExtractZip(evil, filepath.Join(dir, "evil"))
+	assert.EqualError(t, err, evil+" has an entry outside the directory: ../outside.txt")
+	_, err = os.Stat(filepath.Join(dir, "outside.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,93 @@
+package lambda_deploy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// A field where the live function differs from its descriptor.
+type DriftField struct {
+	Field    string `json:"field"`
+	Expected string `json:"expected"` // in the descriptor
+	Actual   string `json:"actual"`   // on AWS
+}
+
+// Where a live function differs from its descriptor, typically because it
+// was edited in the console. The next deploy would undo all of it.
+type DriftReport struct {
+	FunctionName string       `json:"function_name"`
+	Missing      bool         `json:"missing"` // the function does not exist
+	CodeChecked  bool         `json:"code_checked"`
+	Fields       []DriftField `json:"fields"`
+}
+
+func (r *DriftReport) HasDrift() bool {
+	return r.Missing || len(r.Fields) > 0
+}
+
+func (r *DriftReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Function: %s\n", r.FunctionName)
+	switch {
+	case r.Missing:
+		fmt.Fprintf(&b, "Drift:    the function does not exist\n")
+	case r.HasDrift():
+		fmt.Fprintf(&b, "Drift:    %d field(s)\n", len(r.Fields))
+	default:
+		fmt.Fprintf(&b, "Drift:    none\n")
+	}
+	for _, field := range r.Fields {
+		fmt.Fprintf(&b, "  ~ %s: descriptor %q, live %q\n", field.Field, field.Expected, field.Actual)
+	}
+	if !r.CodeChecked && !r.Missing {
+		fmt.Fprintf(&b, "  (code not checked, there is no zip to compare with)\n")
+	}
+	return b.String()
+}
+
+// Compares the live function with the descriptor. The code is compared when
+// the descriptor is for an image or zipfile is given. Nothing is modified on
+// AWS.
+func DetectDrift(svc LambdaAPI, zipfile string, descriptor *LambdaFunctionDesc) (*DriftReport, error) {
+	report := &DriftReport{FunctionName: descriptor.Function_name, Fields: make([]DriftField, 0)}
+	function, err := svc.GetFunction(&lambda.GetFunctionInput{FunctionName: aws.String(descriptor.Function_name)})
+	isDeployed, err := checkIfLambdaIsDeployed(err)
+	if err != nil {
+		return nil, err
+	}
+	if !isDeployed {
+		report.Missing = true
+		return report, nil
+	}
+	config := function.Configuration
+	if err := checkPackageType(descriptor, config); err != nil {
+		expected, actual := descriptor.Package_type, aws.StringValue(config.PackageType)
+		if expected == "" {
+			expected = lambda.PackageTypeZip
+		}
+		if actual == "" {
+			actual = lambda.PackageTypeZip
+		}
+		report.Fields = append(report.Fields, DriftField{"package_type", expected, actual})
+		return report, nil
+	}
+	if descriptor.IsImage() || zipfile != "" {
+		report.CodeChecked = true
+		if codeChanged(descriptor, zipfile, function) {
+			if descriptor.IsImage() {
+				report.Fields = append(report.Fields, DriftField{"image_uri", descriptor.Image_uri, deployedImage(function)})
+			} else {
+				report.Fields = append(report.Fields, DriftField{"code_sha256", Base64sha256(zipfile), aws.StringValue(config.CodeSha256)})
+			}
+		}
+	}
+	if configDiff, isDifferent := descriptor.CompareConfig(config); isDifferent {
+		for _, change := range diffConfig(configDiff, config, descriptor) {
+			report.Fields = append(report.Fields, DriftField{change.Field, change.After, change.Before})
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,73 @@
+package lambda_deploy
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+)
+
+func TestDetectDriftMissing(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	report, err := DetectDrift(fake, testZip, loadTestDescriptor(t))
+	assert.NoError(t, err)
+	assert.True(t, report.Missing)
+	assert.True(t, report.HasDrift())
+	assert.Equal(t, "Function: python-hello\nDrift:    the function does not exist\n", report.String())
+}
+
+func TestDetectDriftNone(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadTestDescriptor(t)
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	report, err := DetectDrift(fake, testZip, lambdaDesc)
+	assert.NoError(t, err)
+	assert.False(t, report.HasDrift())
+	assert.True(t, report.CodeChecked)
+	assert.Equal(t, "Function: python-hello\nDrift:    none\n", report.String())
+
+	report, err = DetectDrift(fake, "", lambdaDesc)
+	assert.NoError(t, err)
+	assert.False(t, report.HasDrift())
+	assert.False(t, report.CodeChecked)
+}
+
+func TestDetectDriftConsoleEdits(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadTestDescriptor(t)
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	_, err := fake.UpdateFunctionConfiguration(&lambda.UpdateFunctionConfigurationInput{
+		FunctionName: aws.String("python-hello"),
+		Timeout:      aws.Int64(60),
+		Environment:  &lambda.Environment{Variables: aws.StringMap(map[string]string{"envVar": "edited"})},
+	})
+	assert.NoError(t, err)
+	// any other file will do as code uploaded by hand
+	_, err = fake.UpdateFunctionCode(&lambda.UpdateFunctionCodeInput{
+		FunctionName: aws.String("python-hello"),
+		ZipFile:      []byte("edited"),
+	})
+	assert.NoError(t, err)
+
+	report, err := DetectDrift(fake, testZip, lambdaDesc)
+	assert.NoError(t, err)
+	assert.True(t, report.HasDrift())
+	assert.Equal(t, []DriftField{
+		{"code_sha256", Base64sha256(testZip), base64sha256Bytes([]byte("edited"))},
+		{"timeout", "3", "60"},
+		{"environment", "envVar=yolatengo", "envVar=edited"},
+	}, report.Fields)
+	assert.Contains(t, report.String(), `  ~ timeout: descriptor "3", live "60"`)
+}
+
+func TestDetectDriftPackageType(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadTestDescriptor(t)
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	lambdaDesc.Package_type = "Image"
+	lambdaDesc.Image_uri = "123456789012.dkr.ecr.eu-west-1.amazonaws.com/hello:1"
+	report, err := DetectDrift(fake, "", lambdaDesc)
+	assert.NoError(t, err)
+	assert.Equal(t, []DriftField{{"package_type", "Image", "Zip"}}, report.Fields)
+}
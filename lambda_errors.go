@@ -0,0 +1,137 @@
+package lambda_deploy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Returned when a descriptor is missing required fields or has invalid
+// values. Errors holds one message per problem found.
+type DescriptorValidationError struct {
+	Errors []string
+}
+
+func (e *DescriptorValidationError) Error() string {
+	return "Descriptor error: " + strings.Join(e.Errors, ",")
+}
+
+// Returned when the lambda function does not exist on AWS.
+type FunctionNotFoundError struct {
+	FunctionName string
+	Err          error
+}
+
+func (e *FunctionNotFoundError) Error() string {
+	return fmt.Sprintf("Lambda function %q not found", e.FunctionName)
+}
+
+func (e *FunctionNotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// Returned when creating a function or uploading new code fails.
+type CodeUploadError struct {
+	FunctionName string
+	Err          error
+}
+
+func (e *CodeUploadError) Error() string {
+	return fmt.Sprintf("Unable to upload code for lambda function %q: %s", e.FunctionName, e.Err)
+}
+
+func (e *CodeUploadError) Unwrap() error {
+	return e.Err
+}
+
+// Returned when updating the configuration of an existing function fails.
+type ConfigUpdateError struct {
+	FunctionName string
+	Err          error
+}
+
+func (e *ConfigUpdateError) Error() string {
+	return fmt.Sprintf("Unable to update configuration of lambda function %q: %s", e.FunctionName, e.Err)
+}
+
+func (e *ConfigUpdateError) Unwrap() error {
+	return e.Err
+}
+
+// Returned when a function does not become ready after a create or update,
+// either because the change failed or because waiting for it timed out.
+type FunctionNotReadyError struct {
+	FunctionName     string
+	State            string
+	LastUpdateStatus string
+	ReasonCode       string // StateReasonCode or LastUpdateStatusReasonCode
+	Reason           string
+	TimedOut         bool
+}
+
+func (e *FunctionNotReadyError) Error() string {
+	msg := fmt.Sprintf("Lambda function %q is not ready (State: %s, LastUpdateStatus: %s)",
+		e.FunctionName, e.State, e.LastUpdateStatus)
+	if e.TimedOut {
+		msg = fmt.Sprintf("Timed out waiting for lambda function %q (State: %s, LastUpdateStatus: %s)",
+			e.FunctionName, e.State, e.LastUpdateStatus)
+	}
+	if e.ReasonCode != "" {
+		msg += fmt.Sprintf(": %s: %s", e.ReasonCode, e.Reason)
+	}
+	return msg
+}
+
+// Returned when a check failed while shifting traffic of an alias to a new
+// version. The alias was moved back to the previous version, unless that
+// failed too.
+type TrafficShiftError struct {
+	FunctionName    string
+	Alias           string
+	Version         string
+	PreviousVersion string
+	RollbackErr     error
+	Err             error
+}
+
+func (e *TrafficShiftError) Error() string {
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("Shifting alias %s of lambda function %q to version %s failed: %s, and rolling back to version %s failed: %s",
+			e.Alias, e.FunctionName, e.Version, e.Err, e.PreviousVersion, e.RollbackErr)
+	}
+	return fmt.Sprintf("Shifting alias %s of lambda function %q to version %s failed, rolled back to version %s: %s",
+		e.Alias, e.FunctionName, e.Version, e.PreviousVersion, e.Err)
+}
+
+func (e *TrafficShiftError) Unwrap() error {
+	return e.Err
+}
+
+// Returned when smoke tests failed after a deploy. With
+// rollback_on_smoke_failure the function was rolled back, unless that failed.
+type SmokeTestError struct {
+	FunctionName string
+	Qualifier    string
+	Failures     []string
+	RolledBack   bool
+	RollbackErr  error
+	AliasesKept  bool // the aliases were not moved to the failed version
+}
+
+func (e *SmokeTestError) Error() string {
+	msg := fmt.Sprintf("Smoke tests of lambda function %q (%s) failed: %s",
+		e.FunctionName, e.Qualifier, strings.Join(e.Failures, "; "))
+	if e.RollbackErr != nil {
+		return msg + fmt.Sprintf(", and rolling back failed: %s", e.RollbackErr)
+	}
+	if e.RolledBack {
+		return msg + ", rolled back"
+	}
+	if e.AliasesKept {
+		return msg + ", the aliases were not moved"
+	}
+	return msg
+}
+
+func isNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "ResourceNotFoundException")
+}
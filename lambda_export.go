@@ -0,0 +1,171 @@
+package lambda_deploy
+
+import (
+	"regexp"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"gopkg.in/yaml.v2"
+)
+
+// Builds a descriptor from a live function, so that deploying it changes
+// nothing. Functions with aliases get them, and publish: true. The output of
+// GetFunction is returned too, for downloading the code.
+func ExportFunction(svc LambdaAPI, functionName string) (*LambdaFunctionDesc, *lambda.GetFunctionOutput, error) {
+	function, err := svc.GetFunction(&lambda.GetFunctionInput{FunctionName: aws.String(functionName)})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil, &FunctionNotFoundError{FunctionName: functionName, Err: err}
+		}
+		return nil, nil, err
+	}
+	config := function.Configuration
+	descriptor := &LambdaFunctionDesc{
+		Function_name: aws.StringValue(config.FunctionName),
+		Description:   aws.StringValue(config.Description),
+		Handler:       aws.StringValue(config.Handler),
+		Runtime:       aws.StringValue(config.Runtime),
+		Role:          aws.StringValue(config.Role),
+		Memory_size:   int(aws.Int64Value(config.MemorySize)),
+		Timeout:       int(aws.Int64Value(config.Timeout)),
+	}
+	if config.Environment != nil && len(config.Environment.Variables) > 0 {
+		descriptor.Environment = aws.StringValueMap(config.Environment.Variables)
+	}
+	if config.VpcConfig != nil && len(config.VpcConfig.SubnetIds) > 0 {
+		descriptor.Vpc_config = &LambdaVpcConfig{
+			Subnet_ids:         aws.StringValueSlice(config.VpcConfig.SubnetIds),
+			Security_group_ids: aws.StringValueSlice(config.VpcConfig.SecurityGroupIds),
+		}
+	}
+	if aws.StringValue(config.PackageType) == lambda.PackageTypeImage {
+		descriptor.Package_type = lambda.PackageTypeImage
+		// pinned to the deployed digest, as an image given by tag is always updated
+		descriptor.Image_uri = deployedImage(function)
+		if config.ImageConfigResponse != nil && config.ImageConfigResponse.ImageConfig != nil {
+			imageConfig := config.ImageConfigResponse.ImageConfig
+			descriptor.Image_config = &LambdaImageConfig{
+				Command:           aws.StringValueSlice(imageConfig.Command),
+				Entrypoint:        aws.StringValueSlice(imageConfig.EntryPoint),
+				Working_directory: aws.StringValue(imageConfig.WorkingDirectory),
+			}
+		}
+	}
+
+	aliasInput := &lambda.ListAliasesInput{FunctionName: aws.String(functionName)}
+	for {
+		output, err := svc.ListAliases(aliasInput)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, alias := range output.Aliases {
+			if descriptor.Aliases == nil {
+				descriptor.Aliases = make(map[string]*LambdaAliasDesc)
+				descriptor.Publish = true
+			}
+			descriptor.Aliases[aws.StringValue(alias.Name)] = &LambdaAliasDesc{Description: aws.StringValue(alias.Description)}
+		}
+		if output.NextMarker == nil {
+			break
+		}
+		aliasInput.Marker = output.NextMarker
+	}
+	return descriptor, function, nil
+}
+
+// Writes the descriptor as YAML under lambda:, leaving out empty fields. With
+// redact the environment values are replaced by ${env:NAME} references, so
+// they have to be set when the descriptor is loaded. Values that look like
+// references are escaped.
+func ExportYAML(descriptor *LambdaFunctionDesc, redact bool) ([]byte, error) {
+	return yaml.Marshal(yaml.MapSlice{{Key: "lambda", Value: exportFields(descriptor, redact)}})
+}
+
+// The descriptor as ExportYAML writes it, as fields for a JSON document.
+func ExportDocument(descriptor *LambdaFunctionDesc, redact bool) DescriptorFields {
+	return DescriptorFields(exportFields(descriptor, redact))
+}
+
+// The fields of the descriptor in the order export writes them.
+func exportFields(descriptor *LambdaFunctionDesc, redact bool) yaml.MapSlice {
+	fields := yaml.MapSlice{}
+	add := func(key string, value interface{}) {
+		fields = append(fields, yaml.MapItem{Key: key, Value: value})
+	}
+	addString := func(key, value string) {
+		if value != "" {
+			add(key, escapeReferences(value))
+		}
+	}
+	addString("function_name", descriptor.Function_name)
+	addString("description", descriptor.Description)
+	addString("package_type", descriptor.Package_type)
+	addString("image_uri", descriptor.Image_uri)
+	addString("handler", descriptor.Handler)
+	addString("runtime", descriptor.Runtime)
+	addString("role", descriptor.Role)
+	add("memory_size", descriptor.Memory_size)
+	add("timeout", descriptor.Timeout)
+	if descriptor.Publish {
+		add("publish", true)
+	}
+	if len(descriptor.Aliases) > 0 {
+		aliases := yaml.MapSlice{}
+		for _, name := range descriptor.AliasNames() {
+			alias := yaml.MapSlice{}
+			if description := descriptor.Aliases[name].Description; description != "" {
+				alias = append(alias, yaml.MapItem{Key: "description", Value: escapeReferences(description)})
+			}
+			aliases = append(aliases, yaml.MapItem{Key: name, Value: alias})
+		}
+		add("aliases", aliases)
+	}
+	if len(descriptor.Environment) > 0 {
+		keys := make([]string, 0, len(descriptor.Environment))
+		for key := range descriptor.Environment {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		environment := yaml.MapSlice{}
+		for _, key := range keys {
+			value := escapeReferences(descriptor.Environment[key])
+			if redact {
+				value = "${env:" + key + "}"
+			}
+			environment = append(environment, yaml.MapItem{Key: key, Value: value})
+		}
+		add("environment", environment)
+	}
+	if descriptor.Vpc_config != nil {
+		add("vpc_config", yaml.MapSlice{
+			{Key: "subnet_ids", Value: descriptor.Vpc_config.Subnet_ids},
+			{Key: "security_group_ids", Value: descriptor.Vpc_config.Security_group_ids},
+		})
+	}
+	if imageConfig := descriptor.Image_config; imageConfig != nil {
+		config := yaml.MapSlice{}
+		if len(imageConfig.Command) > 0 {
+			config = append(config, yaml.MapItem{Key: "command", Value: imageConfig.Command})
+		}
+		if len(imageConfig.Entrypoint) > 0 {
+			config = append(config, yaml.MapItem{Key: "entrypoint", Value: imageConfig.Entrypoint})
+		}
+		if imageConfig.Working_directory != "" {
+			config = append(config, yaml.MapItem{Key: "working_directory", Value: imageConfig.Working_directory})
+		}
+		if len(config) > 0 {
+			add("image_config", config)
+		}
+	}
+	return fields
+}
+
+var exportReferencePattern = regexp.MustCompile(`\$\{\w+:[^}]*\}`)
+
+// Escapes what would be read back as a reference.
+func escapeReferences(value string) string {
+	return exportReferencePattern.ReplaceAllStringFunc(value, func(reference string) string {
+		return "$" + reference
+	})
+}
@@ -0,0 +1,83 @@
+package lambda_deploy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+)
+
+// Exports the function, and loads the exported descriptor back.
+func exportAndLoad(t *testing.T, fake *lambdatest.FakeLambda, redact bool) (*LambdaFunctionDesc, string) {
+	descriptor, function, err := ExportFunction(fake, "python-hello")
+	assert.NoError(t, err)
+	out, err := ExportYAML(descriptor, redact)
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "lambda.yml")
+	assert.NoError(t, ioutil.WriteFile(path, out, 0644))
+	loaded, err := LoadDescriptorFile(path)
+	assert.NoError(t, err)
+	if err != nil {
+		return nil, ""
+	}
+	zipfile := filepath.Join(dir, "python-hello.zip")
+	assert.NoError(t, DownloadFunctionCode(fake.HttpClient(), function, zipfile))
+	return loaded.Lambda, zipfile
+}
+
+func TestExportRoundTrips(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc, err := LoadDescriptorFile("./testdata/descriptors/vpc-descriptor.yml")
+	assert.NoError(t, err)
+	lambdaDesc.Lambda.Publish = true
+	lambdaDesc.Lambda.Aliases = map[string]*LambdaAliasDesc{"live": {Description: "production"}, "beta": {}}
+	lambdaDesc.Lambda.Environment["TEMPLATE"] = "${var:name} stays"
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc.Lambda))
+
+	// one alias per page, so they are only all exported when every page is read
+	fake.PageSize = 1
+	exported, zipfile := exportAndLoad(t, fake, false)
+	assert.Equal(t, lambdaDesc.Lambda.Aliases, exported.Aliases)
+	assert.True(t, exported.Publish)
+	assert.Equal(t, "${var:name} stays", exported.Environment["TEMPLATE"])
+	plan, err := PlanDeploy(fake, zipfile, exported)
+	assert.NoError(t, err)
+	assert.False(t, plan.HasChanges(), plan.String())
+}
+
+func TestExportRedacts(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	assert.NoError(t, LambdaDeploy(fake, testZip, loadTestDescriptor(t)))
+	descriptor, _, err := ExportFunction(fake, "python-hello")
+	assert.NoError(t, err)
+	out, err := ExportYAML(descriptor, true)
+	assert.NoError(t, err)
+	assert.Equal(t, `lambda:
+  function_name: python-hello
+  description: python hello world
+  handler: python_hello.handler
+  runtime: python2.7
+  role: arn:aws:iam::<account id>:role/basic-lambda-role
+  memory_size: 128
+  timeout: 3
+  environment:
+    envVar: ${env:envVar}
+`, string(out))
+	document, err := json.Marshal(ExportDocument(descriptor, true))
+	assert.NoError(t, err)
+	assert.Contains(t, string(document), `"timeout":3,"environment":{"envVar":"${env:envVar}"}}`)
+
+	t.Setenv("envVar", "yolatengo")
+	exported, zipfile := exportAndLoad(t, fake, true)
+	plan, err := PlanDeploy(fake, zipfile, exported)
+	assert.NoError(t, err)
+	assert.False(t, plan.HasChanges(), plan.String())
+}
+
+func TestExportMissingFunction(t *testing.T) {
+	_, _, err := ExportFunction(lambdatest.NewFakeLambda(), "missing")
+	assert.IsType(t, &FunctionNotFoundError{}, err)
+}
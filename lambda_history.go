@@ -0,0 +1,301 @@
+package lambda_deploy
+
+/*
+The deploy history. Every deploy and rollback appends a DeployRecord to a
+HistoryStore: a local JSONL file (FileHistory), a JSONL object in S3
+(S3History) or tags on the function itself (TagHistory).
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	StatusSucceeded  = "succeeded"
+	StatusFailed     = "failed"
+	StatusRolledBack = "rolled-back" // a traffic shift or smoke test failed
+)
+
+// What a deploy or rollback did to a function, and who did it from where.
+type DeployRecord struct {
+	Time             time.Time     `json:"time"`
+	FunctionName     string        `json:"function_name"`
+	FunctionArn      string        `json:"function_arn,omitempty"` // unqualified, it has the region and account
+	Action           string        `json:"action"`                 // deploy or rollback
+	Status           string        `json:"status"`
+	User             string        `json:"user,omitempty"`
+	CI               string        `json:"ci,omitempty"` // the CI system that ran the deploy
+	GitSha           string        `json:"git_sha,omitempty"`
+	DescriptorSha256 string        `json:"descriptor_sha256,omitempty"`
+	Version          string        `json:"version,omitempty"`
+	CodeSha256       string        `json:"code_sha256,omitempty"`
+	Created          bool          `json:"created,omitempty"`
+	CodeChanged      bool          `json:"code_changed,omitempty"`
+	ConfigChanges    []FieldChange `json:"config_changes,omitempty"`
+	Aliases          []string      `json:"aliases,omitempty"`
+	RollbackTarget   string        `json:"rollback_target,omitempty"` // the version a rollback restored
+	Error            string        `json:"error,omitempty"`
+}
+
+// Where deploy records are kept.
+type HistoryStore interface {
+	Append(record *DeployRecord) error
+	// The records of a function, oldest first. The function is a name, or
+	// an ARN to only get the records of the function in that region and
+	// account.
+	Records(function string) ([]*DeployRecord, error)
+}
+
+// Keeps the records in a file with one JSON record per line.
+type FileHistory struct {
+	Path string
+}
+
+func NewFileHistory(path string) *FileHistory {
+	return &FileHistory{Path: path}
+}
+
+func (h *FileHistory) Append(record *DeployRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.Path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(h.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// A missing file has no records.
+func (h *FileHistory) Records(function string) ([]*DeployRecord, error) {
+	file, err := os.Open(h.Path)
+	if os.IsNotExist(err) {
+		return make([]*DeployRecord, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readRecords(file, h.Path, function)
+}
+
+// Keeps the records in an S3 object with one JSON record per line. The object
+// is read and written back on every append, so concurrent deploys to the same
+// object can lose records.
+type S3History struct {
+	Client S3API
+	Bucket string
+	Key    string
+}
+
+func NewS3History(client S3API, bucket, key string) *S3History {
+	return &S3History{Client: client, Bucket: bucket, Key: key}
+}
+
+func (h *S3History) read() ([]byte, error) {
+	output, err := h.Client.GetObject(&s3.GetObjectInput{Bucket: aws.String(h.Bucket), Key: aws.String(h.Key)})
+	if isS3NotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+	return ioutil.ReadAll(output.Body)
+}
+
+func (h *S3History) Append(record *DeployRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	content, err := h.read()
+	if err != nil {
+		return err
+	}
+	content = append(append(content, line...), '\n')
+	_, err = h.Client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(h.Bucket),
+		Key:         aws.String(h.Key),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	return err
+}
+
+// A missing object has no records.
+func (h *S3History) Records(function string) ([]*DeployRecord, error) {
+	content, err := h.read()
+	if err != nil {
+		return nil, err
+	}
+	return readRecords(bytes.NewReader(content), "s3://"+h.Bucket+"/"+h.Key, function)
+}
+
+// Reads JSONL records, keeping those of the function.
+func readRecords(r io.Reader, name, function string) ([]*DeployRecord, error) {
+	records := make([]*DeployRecord, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := &DeployRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", name, line, err)
+		}
+		if record.isOf(function) {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// Whether the record is of the function, given as a name or an ARN. Records
+// without an ARN are only matched by name, as it is unknown which region and
+// account they were deployed to.
+func (r *DeployRecord) isOf(function string) bool {
+	if strings.HasPrefix(function, "arn:") {
+		return r.FunctionArn == unqualifiedArn(function)
+	}
+	return r.FunctionName == function
+}
+
+// Removes the version or alias from a function ARN, arn:aws:lambda:region:account:function:name.
+func unqualifiedArn(arn string) string {
+	parts := strings.SplitN(arn, ":", 8)
+	if len(parts) == 8 {
+		return strings.Join(parts[:7], ":")
+	}
+	return arn
+}
+
+// Returns the commit checked out in dir, or "" when it is not a git checkout.
+func GitSha(dir string) string {
+	sha, err := gitCommand(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return sha
+}
+
+// Reads the environment, can be replaced in tests.
+var getenv = os.Getenv
+
+// The CI systems recognised by their environment, with the variable holding
+// the user that started the build.
+var ciSystems = []struct{ name, detect, user string }{
+	{"github-actions", "GITHUB_ACTIONS", "GITHUB_ACTOR"},
+	{"gitlab-ci", "GITLAB_CI", "GITLAB_USER_LOGIN"},
+	{"circleci", "CIRCLECI", "CIRCLE_USERNAME"},
+	{"buildkite", "BUILDKITE", "BUILDKITE_BUILD_CREATOR"},
+	{"codebuild", "CODEBUILD_BUILD_ID", "CODEBUILD_INITIATOR"},
+	{"jenkins", "JENKINS_URL", "BUILD_USER_ID"},
+	{"ci", "CI", ""},
+}
+
+// Returns who is deploying and the CI system it runs on, if any.
+// LAMBDATOOL_USER overrides the user.
+func deployIdentity() (string, string) {
+	name, ci := "", ""
+	for _, system := range ciSystems {
+		if getenv(system.detect) != "" {
+			ci = system.name
+			if system.user != "" {
+				name = getenv(system.user)
+			}
+			break
+		}
+	}
+	if override := getenv("LAMBDATOOL_USER"); override != "" {
+		name = override
+	}
+	if name == "" {
+		if current, err := user.Current(); err == nil {
+			name = current.Username
+		}
+	}
+	return name, ci
+}
+
+// A sha256 over the descriptor of the function as deployed, after stages,
+// layers and variables were applied. Resolved secrets are masked: the hash
+// must not reveal them, nor change when they are rotated.
+func descriptorSha256(descriptor *LambdaFunctionDesc) string {
+	masked := *descriptor
+	if len(descriptor.Environment) > 0 {
+		masked.Environment = make(map[string]string, len(descriptor.Environment))
+		for key, value := range descriptor.Environment {
+			if descriptor.IsSecret(key) {
+				value = MaskedValue
+			}
+			masked.Environment[key] = value
+		}
+	}
+	data, err := yaml.Marshal(&masked)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Appends the outcome of a deploy or rollback to options.History. Failing to
+// record it does not fail the deploy, it is only reported.
+func recordDeploy(options *DeployOptions, action string, descriptor *LambdaFunctionDesc, result *DeployResult, err error) {
+	record := &DeployRecord{
+		Time:             time.Now().UTC(),
+		FunctionName:     result.FunctionName,
+		FunctionArn:      result.FunctionArn,
+		Action:           action,
+		Status:           StatusSucceeded,
+		GitSha:           options.GitSha,
+		DescriptorSha256: descriptorSha256(descriptor),
+		Version:          result.Version,
+		CodeSha256:       result.CodeSha256,
+		Created:          result.Created,
+		CodeChanged:      result.CodeChanged,
+		ConfigChanges:    result.ConfigChanges,
+		Aliases:          result.Aliases,
+		RollbackTarget:   result.RolledBackTo,
+	}
+	record.User, record.CI = deployIdentity()
+	if err != nil {
+		record.Status = StatusFailed
+		if _, ok := err.(*TrafficShiftError); ok {
+			record.Status = StatusRolledBack
+		}
+		if smokeErr, ok := err.(*SmokeTestError); ok && (smokeErr.RolledBack || smokeErr.AliasesKept) {
+			record.Status = StatusRolledBack
+		}
+		record.Error = err.Error()
+	}
+	if err := options.History.Append(record); err != nil {
+		fmt.Fprintln(options.out(), "Unable to record the deploy in the history:", err)
+	}
+}
@@ -0,0 +1,160 @@
+package lambda_deploy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+const (
+	historyTagPrefix      = "lambdatool:history:"
+	DefaultTagHistorySize = 10
+)
+
+// Keeps the last Size records in tags on the function itself, one tag per
+// record keyed by its time. Tag values are short, so the user and CI system,
+// config changes and errors are not kept. A function can have 50 tags, Size
+// has to leave room for the other ones.
+type TagHistory struct {
+	Client LambdaAPI
+	Size   int // 0 means DefaultTagHistorySize
+}
+
+func NewTagHistory(client LambdaAPI) *TagHistory {
+	return &TagHistory{Client: client}
+}
+
+func (h *TagHistory) arn(functionName string) (string, error) {
+	function, err := h.Client.GetFunction(&lambda.GetFunctionInput{FunctionName: aws.String(functionName)})
+	if err != nil {
+		if isNotFound(err) {
+			return "", &FunctionNotFoundError{FunctionName: functionName, Err: err}
+		}
+		return "", err
+	}
+	return aws.StringValue(function.Configuration.FunctionArn), nil
+}
+
+// Returns the history tag keys of a function, oldest first, and the records.
+func (h *TagHistory) read(arn string) ([]string, []*DeployRecord, error) {
+	output, err := h.Client.ListTags(&lambda.ListTagsInput{Resource: aws.String(arn)})
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]string, 0)
+	for key := range output.Tags {
+		if strings.HasPrefix(key, historyTagPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	arn = unqualifiedArn(arn)
+	records := make([]*DeployRecord, 0, len(keys))
+	for _, key := range keys {
+		record, err := decodeTagRecord(key, aws.StringValue(output.Tags[key]))
+		if err != nil {
+			return nil, nil, err
+		}
+		record.FunctionName = arn[strings.LastIndex(arn, ":")+1:]
+		record.FunctionArn = arn
+		records = append(records, record)
+	}
+	return keys, records, nil
+}
+
+// Adds the record, and removes the oldest ones beyond Size. A function that
+// does not exist (a failed create) can not be tagged, the record is dropped.
+func (h *TagHistory) Append(record *DeployRecord) error {
+	arn, err := h.arn(record.FunctionName)
+	if _, ok := err.(*FunctionNotFoundError); ok {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keys, _, err := h.read(arn)
+	if err != nil {
+		return err
+	}
+	key := historyTagPrefix + fmt.Sprintf("%013d", record.Time.UnixNano()/int64(time.Millisecond))
+	size := h.Size
+	if size <= 0 {
+		size = DefaultTagHistorySize
+	}
+	if len(keys) >= size {
+		_, err := h.Client.UntagResource(&lambda.UntagResourceInput{
+			Resource: aws.String(arn),
+			TagKeys:  aws.StringSlice(keys[:len(keys)-size+1]),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	_, err = h.Client.TagResource(&lambda.TagResourceInput{
+		Resource: aws.String(arn),
+		Tags:     map[string]*string{key: aws.String(encodeTagRecord(record))},
+	})
+	return err
+}
+
+// The tags are on the function itself, so the records are always of the
+// function in the region and account of the client.
+func (h *TagHistory) Records(function string) ([]*DeployRecord, error) {
+	arn, err := h.arn(function)
+	if err != nil {
+		return nil, err
+	}
+	_, records, err := h.read(arn)
+	return records, err
+}
+
+// The fields of a record kept in a tag value, separated by spaces. Tag values
+// only allow letters, digits, spaces and _.:/=+-@ and at most 256 of them.
+func encodeTagRecord(record *DeployRecord) string {
+	user := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_.:/=+-@", r) {
+			return r
+		}
+		return '_'
+	}, record.User)
+	if len(user) > 40 {
+		user = user[:40]
+	}
+	fields := []string{record.Action, record.Status, record.Version, record.CodeSha256,
+		record.GitSha, record.DescriptorSha256, record.RollbackTarget, user}
+	for i, field := range fields {
+		if field == "" {
+			fields[i] = "-"
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+func decodeTagRecord(key, value string) (*DeployRecord, error) {
+	fields := strings.Split(value, " ")
+	millis, err := strconv.ParseInt(strings.TrimPrefix(key, historyTagPrefix), 10, 64)
+	if err != nil || len(fields) != 8 {
+		return nil, fmt.Errorf("Invalid history tag %s: %q", key, value)
+	}
+	for i, field := range fields {
+		if field == "-" {
+			fields[i] = ""
+		}
+	}
+	return &DeployRecord{
+		Time:             time.Unix(0, millis*int64(time.Millisecond)).UTC(),
+		Action:           fields[0],
+		Status:           fields[1],
+		Version:          fields[2],
+		CodeSha256:       fields[3],
+		GitSha:           fields[4],
+		DescriptorSha256: fields[5],
+		RollbackTarget:   fields[6],
+		User:             fields[7],
+	}, nil
+}
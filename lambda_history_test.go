@@ -0,0 +1,145 @@
+package lambda_deploy
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+)
+
+// Replaces the environment with env.
+func fakeEnv(t *testing.T, env map[string]string) {
+	original := getenv
+	getenv = func(key string) string { return env[key] }
+	t.Cleanup(func() { getenv = original })
+}
+
+func TestFileHistory(t *testing.T) {
+	history := NewFileHistory(filepath.Join(t.TempDir(), "history", "deploys.jsonl"))
+	records, err := history.Records("python-hello")
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+
+	assert.NoError(t, history.Append(&DeployRecord{FunctionName: "python-hello", Version: "1"}))
+	assert.NoError(t, history.Append(&DeployRecord{FunctionName: "other", Version: "7"}))
+	assert.NoError(t, history.Append(&DeployRecord{FunctionName: "python-hello", Version: "2"}))
+	records, err = history.Records("python-hello")
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "2", records[1].Version)
+
+	arn := lambdatest.FunctionArn("python-hello")
+	assert.NoError(t, history.Append(&DeployRecord{FunctionName: "python-hello", FunctionArn: arn, Version: "3"}))
+	assert.NoError(t, history.Append(&DeployRecord{FunctionName: "python-hello", Version: "4",
+		FunctionArn: "arn:aws:lambda:eu-west-1:123456789012:function:python-hello"}))
+	records, err = history.Records(arn + ":3")
+	assert.NoError(t, err)
+	assert.Len(t, records, 1, "only the records of the function in its region and account")
+	assert.Equal(t, "3", records[0].Version)
+}
+
+func TestDeployRecordsHistory(t *testing.T) {
+	fakeEnv(t, map[string]string{"GITHUB_ACTIONS": "true", "GITHUB_ACTOR": "octocat"})
+	fake := lambdatest.NewFakeLambda()
+	options := &DeployOptions{History: NewFileHistory(filepath.Join(t.TempDir(), "history.jsonl")), GitSha: "abc123"}
+	lambdaDesc := loadTestDescriptor(t)
+	_, err := LambdaDeployWithOptions(fake, testZip, lambdaDesc, options)
+	assert.NoError(t, err)
+	lambdaDesc.Timeout = 30
+	_, err = LambdaDeployWithOptions(fake, testZip, lambdaDesc, options)
+	assert.NoError(t, err)
+	lambdaDesc.Code = &LambdaCodeDesc{S3_bucket: "artifacts", S3_key: "missing.zip"}
+	_, err = LambdaDeployWithOptions(fake, "", lambdaDesc, options)
+	assert.Error(t, err)
+
+	records, err := options.History.Records("python-hello")
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	created, updated, failed := records[0], records[1], records[2]
+	assert.Equal(t, "deploy", created.Action)
+	assert.Equal(t, StatusSucceeded, created.Status)
+	assert.Equal(t, "octocat", created.User)
+	assert.Equal(t, "github-actions", created.CI)
+	assert.Equal(t, "abc123", created.GitSha)
+	assert.True(t, created.Created)
+	assert.Equal(t, Base64sha256(testZip), created.CodeSha256)
+	assert.WithinDuration(t, time.Now(), created.Time, time.Minute)
+
+	assert.False(t, updated.Created)
+	assert.False(t, updated.CodeChanged)
+	assert.Equal(t, []FieldChange{{"timeout", "3", "30"}}, updated.ConfigChanges)
+	assert.Len(t, updated.DescriptorSha256, 64)
+	assert.NotEqual(t, created.DescriptorSha256, updated.DescriptorSha256)
+
+	assert.Equal(t, StatusFailed, failed.Status)
+	assert.Contains(t, failed.Error, "NoSuchKey")
+}
+
+func TestDeployIdentity(t *testing.T) {
+	fakeEnv(t, map[string]string{"GITLAB_CI": "true", "GITLAB_USER_LOGIN": "jane", "CI": "true"})
+	user, ci := deployIdentity()
+	assert.Equal(t, "jane", user)
+	assert.Equal(t, "gitlab-ci", ci)
+
+	fakeEnv(t, map[string]string{"CI": "true", "LAMBDATOOL_USER": "deploy-bot"})
+	user, ci = deployIdentity()
+	assert.Equal(t, "deploy-bot", user)
+	assert.Equal(t, "ci", ci)
+}
+
+func TestS3History(t *testing.T) {
+	s3 := lambdatest.NewFakeS3()
+	history := NewS3History(s3, "artifacts", "history/deploys.jsonl")
+	records, err := history.Records("python-hello")
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+
+	assert.NoError(t, history.Append(&DeployRecord{FunctionName: "python-hello", Version: "1"}))
+	assert.NoError(t, history.Append(&DeployRecord{FunctionName: "python-hello", Version: "2"}))
+	records, err = history.Records("python-hello")
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "2", records[1].Version)
+	assert.Contains(t, string(s3.Object("artifacts", "history/deploys.jsonl")), `"function_name":"python-hello","action":"","status":"","version":"1"`)
+}
+
+func TestTagHistory(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	assert.NoError(t, LambdaDeploy(fake, testZip, loadTestDescriptor(t)))
+	history := &TagHistory{Client: fake, Size: 2}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, version := range []string{"1", "2", "3"} {
+		assert.NoError(t, history.Append(&DeployRecord{
+			Time:         start.Add(time.Duration(i) * time.Minute),
+			FunctionName: "python-hello",
+			Action:       "deploy",
+			Status:       StatusSucceeded,
+			Version:      version,
+			CodeSha256:   Base64sha256(testZip),
+			User:         "Jane Doe <jane@example.com>",
+		}))
+	}
+	records, err := history.Records("python-hello")
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, &DeployRecord{
+		Time:         start.Add(2 * time.Minute),
+		FunctionName: "python-hello",
+		FunctionArn:  lambdatest.FunctionArn("python-hello"),
+		Action:       "deploy",
+		Status:       StatusSucceeded,
+		Version:      "3",
+		CodeSha256:   Base64sha256(testZip),
+		User:         "Jane_Doe__jane@example.com_",
+	}, records[1])
+
+	tags, err := fake.ListTags(&lambda.ListTagsInput{Resource: aws.String(lambdatest.FunctionArn("python-hello"))})
+	assert.NoError(t, err)
+	assert.Len(t, tags.Tags, 2)
+
+	// a function that failed to be created has nothing to tag
+	assert.NoError(t, history.Append(&DeployRecord{FunctionName: "missing"}))
+}
@@ -0,0 +1,156 @@
+package lambda_deploy
+
+/*
+Support for functions packaged as container images:
+
+	lambda:
+	  function_name: image-hello
+	  package_type: Image
+	  image_uri: 123456789012.dkr.ecr.eu-west-1.amazonaws.com/hello@sha256:5f70bf18...
+	  image_config:
+	    command: ["app.handler"]
+	    entrypoint: ["/lambda-entrypoint.sh"]
+	    working_directory: /var/task
+
+Image functions have no handler, runtime or zip. Their code is compared by
+image digest when image_uri is pinned to one, and by image_uri otherwise.
+*/
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// Overrides of the settings in the image.
+type LambdaImageConfig struct {
+	Command           []string
+	Entrypoint        []string
+	Working_directory string
+}
+
+func (l *LambdaFunctionDesc) IsImage() bool {
+	return l.Package_type == lambda.PackageTypeImage
+}
+
+func (l *LambdaFunctionDesc) validateImage() []string {
+	errorList := make([]string, 0)
+	switch l.Package_type {
+	case "", lambda.PackageTypeZip:
+		if l.Image_uri != "" || l.Image_config != nil {
+			errorList = append(errorList, "image_uri and image_config require package_type: Image")
+		}
+	case lambda.PackageTypeImage:
+		if l.Image_uri == "" {
+			errorList = append(errorList, "Missing image_uri")
+		}
+		if l.Handler != "" || l.Runtime != "" {
+			errorList = append(errorList, "handler and runtime can not be set for package_type: Image, use image_config")
+		}
+		if l.Source != nil || l.Code != nil {
+			errorList = append(errorList, "source and code can not be set for package_type: Image")
+		}
+	default:
+		errorList = append(errorList, fmt.Sprintf("Unknown package_type %q, use Zip or Image", l.Package_type))
+	}
+	return errorList
+}
+
+func (c *LambdaImageConfig) input() *lambda.ImageConfig {
+	config := &lambda.ImageConfig{}
+	if c == nil {
+		return config
+	}
+	if len(c.Command) > 0 {
+		config.Command = aws.StringSlice(c.Command)
+	}
+	if len(c.Entrypoint) > 0 {
+		config.EntryPoint = aws.StringSlice(c.Entrypoint)
+	}
+	if c.Working_directory != "" {
+		config.WorkingDirectory = aws.String(c.Working_directory)
+	}
+	return config
+}
+
+// Returns the image config to send when the one of the function differs from
+// the descriptor. Order matters for command and entrypoint.
+func (d *LambdaFunctionDesc) CompareImageConfig(other *lambda.ImageConfigResponse) (*lambda.ImageConfig, bool) {
+	wanted := d.Image_config.input()
+	current := &lambda.ImageConfig{}
+	if other != nil && other.ImageConfig != nil {
+		current = other.ImageConfig
+	}
+	if strings.Join(aws.StringValueSlice(wanted.Command), "\x00") != strings.Join(aws.StringValueSlice(current.Command), "\x00") ||
+		strings.Join(aws.StringValueSlice(wanted.EntryPoint), "\x00") != strings.Join(aws.StringValueSlice(current.EntryPoint), "\x00") ||
+		aws.StringValue(wanted.WorkingDirectory) != aws.StringValue(current.WorkingDirectory) {
+		return wanted, true
+	}
+	return nil, false
+}
+
+func formatImageConfig(config *lambda.ImageConfig) string {
+	if config == nil {
+		return ""
+	}
+	parts := make([]string, 0, 3)
+	if len(config.Command) > 0 {
+		parts = append(parts, fmt.Sprintf("command=%v", aws.StringValueSlice(config.Command)))
+	}
+	if len(config.EntryPoint) > 0 {
+		parts = append(parts, fmt.Sprintf("entrypoint=%v", aws.StringValueSlice(config.EntryPoint)))
+	}
+	if config.WorkingDirectory != nil {
+		parts = append(parts, "working_directory="+*config.WorkingDirectory)
+	}
+	return strings.Join(parts, " ")
+}
+
+// The digest an image uri is pinned to, without the sha256: prefix, or ""
+// for an image given by tag.
+func imageDigest(imageUri string) string {
+	if i := strings.Index(imageUri, "@sha256:"); i >= 0 {
+		return imageUri[i+len("@sha256:"):]
+	}
+	return ""
+}
+
+// Reports whether the image of the descriptor differs from the deployed one.
+// The CodeSha256 of an image function is the digest of its image. A tag can
+// be moved to another image at any time, and Lambda only resolves it when the
+// code is updated, so an image given by tag is always considered changed.
+func imageChanged(descriptor *LambdaFunctionDesc, function *lambda.GetFunctionOutput) bool {
+	if digest := imageDigest(descriptor.Image_uri); digest != "" {
+		return digest != aws.StringValue(function.Configuration.CodeSha256)
+	}
+	return true
+}
+
+func deployedImage(function *lambda.GetFunctionOutput) string {
+	if function.Code == nil {
+		return ""
+	}
+	if function.Code.ResolvedImageUri != nil {
+		return *function.Code.ResolvedImageUri
+	}
+	return aws.StringValue(function.Code.ImageUri)
+}
+
+// The package type of a function can not be changed by an update.
+func checkPackageType(descriptor *LambdaFunctionDesc, config *lambda.FunctionConfiguration) error {
+	current := aws.StringValue(config.PackageType)
+	if current == "" {
+		current = lambda.PackageTypeZip
+	}
+	wanted := descriptor.Package_type
+	if wanted == "" {
+		wanted = lambda.PackageTypeZip
+	}
+	if current != wanted {
+		return fmt.Errorf("%s has package type %s and can not be changed to %s, delete it first",
+			descriptor.Function_name, current, wanted)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package lambda_deploy
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+)
+
+func loadImageDescriptor(t *testing.T) *LambdaFunctionDesc {
+	lambdaDesc, err := LoadDescriptorFile("./testdata/image/image-descriptor.yml")
+	assert.NoError(t, err)
+	return lambdaDesc.Lambda
+}
+
+func TestDeployImage(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadImageDescriptor(t)
+	assert.NoError(t, LambdaDeploy(fake, "", lambdaDesc))
+
+	result, err := fake.GetFunction(&lambda.GetFunctionInput{FunctionName: aws.String("image-hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, lambda.PackageTypeImage, *result.Configuration.PackageType)
+	assert.Nil(t, result.Configuration.Handler)
+	assert.Equal(t, "5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef", *result.Configuration.CodeSha256)
+	assert.Equal(t, []string{"app.handler"}, aws.StringValueSlice(result.Configuration.ImageConfigResponse.ImageConfig.Command))
+
+	plan, err := PlanDeploy(fake, "", lambdaDesc)
+	assert.NoError(t, err)
+	assert.False(t, plan.HasChanges())
+}
+
+func TestDeployImageDigestAndConfigChange(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadImageDescriptor(t)
+	assert.NoError(t, LambdaDeploy(fake, "", lambdaDesc))
+
+	lambdaDesc.Image_uri = "123456789012.dkr.ecr.us-east-1.amazonaws.com/hello@sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	lambdaDesc.Image_config.Entrypoint = []string{"/lambda-entrypoint.sh"}
+	plan, err := PlanDeploy(fake, "", lambdaDesc)
+	assert.NoError(t, err)
+	assert.True(t, plan.CodeChanged)
+	assert.Equal(t, "image_uri", plan.Changes[0].Field)
+	assert.Equal(t, "image_config", plan.Changes[1].Field)
+	assert.Equal(t, "command=[app.handler] entrypoint=[/lambda-entrypoint.sh] working_directory=/var/task", plan.Changes[1].After)
+
+	assert.NoError(t, LambdaDeploy(fake, "", lambdaDesc))
+	assert.Equal(t, []string{"GetFunction", "CreateFunction", "GetFunctionConfiguration", "GetFunction", "GetFunction", "UpdateFunctionCode", "GetFunctionConfiguration", "UpdateFunctionConfiguration", "GetFunctionConfiguration"}, fake.Calls())
+}
+
+func TestDeployImageByTag(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadImageDescriptor(t)
+	lambdaDesc.Image_uri = "123456789012.dkr.ecr.us-east-1.amazonaws.com/hello:1.0"
+	assert.NoError(t, LambdaDeploy(fake, "", lambdaDesc))
+	lambdaDesc.Image_uri = "123456789012.dkr.ecr.us-east-1.amazonaws.com/hello:1.1"
+	assert.NoError(t, LambdaDeploy(fake, "", lambdaDesc))
+	assert.Equal(t, []string{"GetFunction", "CreateFunction", "GetFunctionConfiguration", "GetFunction", "UpdateFunctionCode", "GetFunctionConfiguration"}, fake.Calls())
+}
+
+func TestDeployImageTagPointingAtNewDigest(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadImageDescriptor(t)
+	lambdaDesc.Image_uri = "123456789012.dkr.ecr.us-east-1.amazonaws.com/hello:latest"
+	fake.PushImage(lambdaDesc.Image_uri, "1111111111111111111111111111111111111111111111111111111111111111")
+	assert.NoError(t, LambdaDeploy(fake, "", lambdaDesc))
+
+	fake.PushImage(lambdaDesc.Image_uri, "2222222222222222222222222222222222222222222222222222222222222222")
+	plan, err := PlanDeploy(fake, "", lambdaDesc)
+	assert.NoError(t, err)
+	assert.True(t, plan.CodeChanged)
+	assert.Equal(t, FieldChange{"image_uri", "123456789012.dkr.ecr.us-east-1.amazonaws.com/hello@sha256:1111111111111111111111111111111111111111111111111111111111111111", lambdaDesc.Image_uri}, plan.Changes[0])
+	report, err := DetectDrift(fake, "", lambdaDesc)
+	assert.NoError(t, err)
+	assert.True(t, report.HasDrift())
+
+	result, err := LambdaDeployWithOptions(fake, "", lambdaDesc, &DeployOptions{})
+	assert.NoError(t, err)
+	assert.True(t, result.CodeChanged)
+	assert.Equal(t, "2222222222222222222222222222222222222222222222222222222222222222", result.CodeSha256)
+}
+
+func TestDeployCanNotChangePackageType(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	assert.NoError(t, LambdaDeploy(fake, testZip, loadTestDescriptor(t)))
+	lambdaDesc := loadImageDescriptor(t)
+	lambdaDesc.Function_name = "python-hello"
+	err := LambdaDeploy(fake, "", lambdaDesc)
+	assert.EqualError(t, err, "python-hello has package type Zip and can not be changed to Image, delete it first")
+}
+
+func TestImageDescriptorValidation(t *testing.T) {
+	lambdaDesc := loadImageDescriptor(t)
+	lambdaDesc.Runtime = "python3.6"
+	lambdaDesc.Image_uri = ""
+	err := lambdaDesc.Validate()
+	assert.Equal(t, []string{"Missing image_uri", "handler and runtime can not be set for package_type: Image, use image_config"},
+		err.(*DescriptorValidationError).Errors)
+
+	zipDesc := loadTestDescriptor(t)
+	zipDesc.Image_uri = "hello:latest"
+	err = zipDesc.Validate()
+	assert.Equal(t, []string{"image_uri and image_config require package_type: Image"},
+		err.(*DescriptorValidationError).Errors)
+}
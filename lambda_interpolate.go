@@ -0,0 +1,205 @@
+package lambda_deploy
+
+/*
+Resolves ${source:name} references in descriptors. The sources are:
+
+	${env:NAME}   environment variable
+	${var:NAME}   variable given in DescriptorOptions.Vars
+	${git:sha}    commit checked out in the descriptor's directory
+	${git:branch} branch checked out in the descriptor's directory
+	${file:PATH}  contents of a file, relative to the descriptor's directory
+
+$${ is left as a literal ${.
+*/
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+var referencePattern = regexp.MustCompile(`\$?\$\{(\w+):([^}]*)\}`)
+
+// Runs git in dir, can be replaced in tests.
+var gitCommand = func(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// Resolves the references of one descriptor file.
+type interpolator struct {
+	vars      map[string]string
+	gitValues map[string]string
+	baseDir   string
+	errors    []string
+}
+
+// Replaces the references in the values of a descriptor before it is
+// decoded, so they can also give numbers and booleans, like
+// memory_size: ${var:memory}. The references are resolved in the parsed YAML,
+// so values can not change the structure of the descriptor. Only the stage
+// selected in options is kept, the others are dropped without being resolved.
+// baseDir is the directory of the descriptor, used for git: and file:
+// references. All references that can not be resolved are reported together.
+// Contents that do not parse are returned as they are, for the decoder to
+// report.
+func interpolateDescriptor(contents []byte, options *DescriptorOptions, baseDir string) ([]byte, error) {
+	var tree yaml.MapSlice
+	if err := yaml.Unmarshal(contents, &tree); err != nil {
+		return contents, nil
+	}
+	r := &interpolator{
+		vars:      map[string]string{},
+		gitValues: map[string]string{},
+		baseDir:   baseDir,
+		errors:    make([]string, 0),
+	}
+	stage := ""
+	if options != nil {
+		stage = options.Stage
+		if options.Vars != nil {
+			r.vars = options.Vars
+		}
+	}
+	for i, item := range tree {
+		if item.Key != "stages" {
+			tree[i].Value = r.value(item.Value)
+			continue
+		}
+		stages, _ := item.Value.(yaml.MapSlice)
+		selected := yaml.MapSlice{}
+		for _, overlay := range stages {
+			if overlay.Key == stage {
+				selected = append(selected, yaml.MapItem{Key: overlay.Key, Value: r.value(overlay.Value)})
+			}
+		}
+		tree[i].Value = selected
+	}
+	if len(r.errors) > 0 {
+		return nil, &DescriptorValidationError{Errors: r.errors}
+	}
+	return yaml.Marshal(tree)
+}
+
+// Interpolates the strings in a parsed YAML value, and in the values of its
+// maps and lists. Map keys are left alone.
+func (r *interpolator) value(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		return r.scalar(value)
+	case yaml.MapSlice:
+		for i, item := range value {
+			value[i].Value = r.value(item.Value)
+		}
+	case []interface{}:
+		for i, v := range value {
+			value[i] = r.value(v)
+		}
+	}
+	return value
+}
+
+// A value that resolves to a number or boolean is one, so it can be decoded
+// into the numeric and boolean fields; string fields still get the text. It
+// only counts when it reads back the same, so 0123 or yes stay strings.
+func (r *interpolator) scalar(value string) interface{} {
+	resolved := r.string(value)
+	if resolved == value {
+		return value
+	}
+	var typed interface{}
+	if err := yaml.Unmarshal([]byte(resolved), &typed); err != nil {
+		return resolved
+	}
+	switch typed.(type) {
+	case int, int64, uint64, float64, bool:
+		if out, err := yaml.Marshal(typed); err == nil && strings.TrimSuffix(string(out), "\n") == resolved {
+			return typed
+		}
+	}
+	return resolved
+}
+
+func (r *interpolator) string(value string) string {
+	return referencePattern.ReplaceAllStringFunc(value, func(reference string) string {
+		if strings.HasPrefix(reference, "$$") {
+			return reference[1:]
+		}
+		match := referencePattern.FindStringSubmatch(reference)
+		source, name := match[1], match[2]
+		resolved, err := resolveReference(source, name, r.vars, r.gitValues, r.baseDir)
+		if err != nil {
+			r.errors = append(r.errors, fmt.Sprintf("Unresolved reference %s: %s", reference, err))
+			return reference
+		}
+		return resolved
+	})
+}
+
+func resolveReference(source, name string, vars, gitValues map[string]string, baseDir string) (string, error) {
+	switch source {
+	case "env":
+		if value, ok := os.LookupEnv(name); ok {
+			return value, nil
+		}
+		return "", fmt.Errorf("environment variable %q is not set", name)
+	case "var":
+		if value, ok := vars[name]; ok {
+			return value, nil
+		}
+		return "", fmt.Errorf("variable %q is not set", name)
+	case "git":
+		if value, ok := gitValues[name]; ok {
+			return value, nil
+		}
+		var args []string
+		switch name {
+		case "sha":
+			args = []string{"rev-parse", "HEAD"}
+		case "branch":
+			args = []string{"rev-parse", "--abbrev-ref", "HEAD"}
+		default:
+			return "", fmt.Errorf("unknown git value %q, use sha or branch", name)
+		}
+		value, err := gitCommand(baseDir, args...)
+		if err != nil {
+			return "", fmt.Errorf("git failed: %s", err)
+		}
+		gitValues[name] = value
+		return value, nil
+	case "file":
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		data, err := loadFileContent(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	default:
+		return "", fmt.Errorf("unknown source %q", source)
+	}
+}
+
+// Reads variables for ${var:name} references from a yaml file with one
+// name: value pair per line.
+func LoadVarsFile(filename string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]string{}
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return nil, fmt.Errorf("Unable to parse vars file %q: %s", filename, err)
+	}
+	return vars, nil
+}
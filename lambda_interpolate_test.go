@@ -0,0 +1,122 @@
+package lambda_deploy
+
+import (
+	"os"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func fakeGit(t *testing.T) {
+	original := gitCommand
+	gitCommand = func(dir string, args ...string) (string, error) {
+		if args[1] == "--abbrev-ref" {
+			return "master", nil
+		}
+		return "abc123", nil
+	}
+	t.Cleanup(func() { gitCommand = original })
+}
+
+func TestInterpolateDescriptor(t *testing.T) {
+	fakeGit(t)
+	os.Setenv("LAMBDATOOL_TEST_ACCOUNT", "123456789012")
+	defer os.Unsetenv("LAMBDATOOL_TEST_ACCOUNT")
+	vars, err := LoadVarsFile("./testdata/interpolate/vars.yml")
+	assert.NoError(t, err)
+	vars["build"] = "42"
+
+	lambdaDesc, err := LoadDescriptorFiles([]string{"./testdata/interpolate/lambda.yml"},
+		&DescriptorOptions{Vars: vars})
+	assert.NoError(t, err)
+	assert.Equal(t, "build 42 from master@abc123", lambdaDesc.Lambda.Description)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/basic-lambda-role", lambdaDesc.Lambda.Role)
+	assert.Equal(t, "1.2.3", lambdaDesc.Lambda.Environment["version"])
+	assert.Equal(t, "${notAReference:x}", lambdaDesc.Lambda.Environment["template"])
+}
+
+func TestInterpolateReportsEveryUnresolvedReference(t *testing.T) {
+	fakeGit(t)
+	os.Unsetenv("LAMBDATOOL_TEST_ACCOUNT")
+	_, err := LoadDescriptorFiles([]string{"./testdata/interpolate/lambda.yml"}, nil)
+	validationErr, ok := err.(*DescriptorValidationError)
+	assert.True(t, ok, "should be a validation error")
+	assert.Len(t, validationErr.Errors, 2)
+	assert.Contains(t, validationErr.Errors[0], "${var:build}")
+	assert.Contains(t, validationErr.Errors[1], "${env:LAMBDATOOL_TEST_ACCOUNT}")
+}
+
+func TestInterpolateUnknownSource(t *testing.T) {
+	_, err := interpolateDescriptor([]byte("lambda:\n  description: ${foo:bar} ${git:tag}\n"), nil, ".")
+	assert.Len(t, err.(*DescriptorValidationError).Errors, 2)
+}
+
+func TestInterpolateNumbersAndBooleans(t *testing.T) {
+	contents := []byte(`
+lambda:
+  function_name: python-hello
+  description: build ${var:build}
+  handler: python_hello.handler
+  runtime: python2.7
+  role: arn:aws:iam::123456789012:role/basic-lambda-role
+  memory_size: ${var:memory}
+  timeout: ${env:LAMBDATOOL_TEST_TIMEOUT}
+  publish: ${var:publish}
+  environment:
+    BUILD: ${var:build}
+    ZIP_CODE: ${var:zip}
+stages:
+  prod:
+    lambda:
+      memory_size: ${var:prod_memory}
+`)
+	os.Setenv("LAMBDATOOL_TEST_TIMEOUT", "30")
+	defer os.Unsetenv("LAMBDATOOL_TEST_TIMEOUT")
+	vars := map[string]string{"build": "42", "memory": "512", "publish": "true", "zip": "0123"}
+	lambdaDesc, err := LoadDescriptorWithOptions(contents, &DescriptorOptions{Vars: vars})
+	assert.NoError(t, err, "the unselected stage is not resolved")
+	assert.Equal(t, 512, lambdaDesc.Lambda.Memory_size)
+	assert.Equal(t, 30, lambdaDesc.Lambda.Timeout)
+	assert.True(t, lambdaDesc.Lambda.Publish)
+	assert.Equal(t, "build 42", lambdaDesc.Lambda.Description)
+	assert.Equal(t, "42", lambdaDesc.Lambda.Environment["BUILD"])
+	assert.Equal(t, "0123", lambdaDesc.Lambda.Environment["ZIP_CODE"])
+
+	vars["prod_memory"] = "1024"
+	lambdaDesc, err = LoadDescriptorWithOptions(contents, &DescriptorOptions{Stage: "prod", Vars: vars})
+	assert.NoError(t, err)
+	assert.Equal(t, 1024, lambdaDesc.Lambda.Memory_size)
+
+	vars["memory"] = "lots"
+	_, err = LoadDescriptorWithOptions(contents, &DescriptorOptions{Vars: vars})
+	assert.Error(t, err, "a value that is no number can not be a memory size")
+}
+
+func TestInterpolatedValuesCanNotChangeTheStructure(t *testing.T) {
+	contents := []byte(`
+# the role is ${env:LAMBDATOOL_TEST_UNSET} in comments
+lambda:
+  function_name: python-hello
+  handler: python_hello.handler
+  runtime: python2.7
+  role: arn:aws:iam::123456789012:role/basic-lambda-role
+  environment:
+    greeting: ${var:greeting}
+  smoke_tests:
+    - payload: {"name": "${var:greeting}"}
+stages:
+  prod:
+    lambda:
+      description: ${env:LAMBDATOOL_TEST_UNSET}
+`)
+	greeting := "hello\nrole: arn:aws:iam::999999999999:role/admin\ntimeout: 900"
+	lambdaDesc, err := LoadDescriptorWithOptions(contents, &DescriptorOptions{Vars: map[string]string{"greeting": greeting}})
+	assert.NoError(t, err, "references in comments and unselected stages are not resolved")
+	assert.Equal(t, greeting, lambdaDesc.Lambda.Environment["greeting"])
+	assert.Equal(t, "arn:aws:iam::123456789012:role/basic-lambda-role", lambdaDesc.Lambda.Role)
+	assert.Equal(t, 3, lambdaDesc.Lambda.Timeout)
+	assert.Equal(t, map[interface{}]interface{}{"name": greeting}, lambdaDesc.Lambda.Smoke_tests[0].Payload)
+
+	_, err = LoadDescriptorWithOptions(contents, &DescriptorOptions{Stage: "prod", Vars: map[string]string{"greeting": "hi"}})
+	assert.Error(t, err, "the references of the selected stage are resolved")
+	assert.Contains(t, err.Error(), "${env:LAMBDATOOL_TEST_UNSET}")
+}
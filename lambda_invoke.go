@@ -1,17 +1,68 @@
 package lambda_deploy
 
 import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/lambda"
 )
 
-func InvokeLambda(client *lambda.Lambda, functionName, body string) (string) {
+// The outcome of an invoke, as shown by invoke. Payload is kept as JSON when
+// it is, and as a string otherwise.
+type InvokeResult struct {
+	FunctionName    string      `json:"function_name"`
+	StatusCode      int64       `json:"status_code"`
+	ExecutedVersion string      `json:"executed_version"`
+	FunctionError   string      `json:"function_error,omitempty"`
+	Payload         interface{} `json:"payload"`
+}
+
+func NewInvokeResult(functionName string, output *lambda.InvokeOutput) *InvokeResult {
+	result := &InvokeResult{
+		FunctionName:    functionName,
+		StatusCode:      aws.Int64Value(output.StatusCode),
+		ExecutedVersion: aws.StringValue(output.ExecutedVersion),
+		FunctionError:   aws.StringValue(output.FunctionError),
+		Payload:         string(output.Payload),
+	}
+	if json.Valid(output.Payload) {
+		result.Payload = json.RawMessage(output.Payload)
+	}
+	return result
+}
+
+func InvokeLambda(client LambdaAPI, functionName, body string) (string, error) {
+	var payload []byte
+	if body != "" {
+		payload = []byte(body)
+	}
+	out, err := InvokeFunction(client, functionName, "", payload)
+	if err != nil {
+		return "", err
+	}
+	return string(out.Payload), nil
+}
+
+// Invokes a version or alias of the function, or $LATEST without qualifier.
+// Errors raised by the function are in the FunctionError of the output.
+func InvokeFunction(client LambdaAPI, functionName, qualifier string, payload []byte) (*lambda.InvokeOutput, error) {
 	invoke := lambda.InvokeInput{}
 	invoke.SetFunctionName(functionName)
-	if body != "" {
-		invoke.SetPayload([]byte(body))
+	if qualifier != "" {
+		invoke.SetQualifier(qualifier)
+	}
+	if payload != nil {
+		invoke.SetPayload(payload)
 	}
 	out, err := client.Invoke(&invoke)
-	check(err)
-	//fmt.Println(out)
-	return string(out.Payload)
+	if err != nil {
+		if isNotFound(err) && qualifier != "" {
+			return nil, &FunctionNotFoundError{FunctionName: functionName + ":" + qualifier, Err: err}
+		}
+		if isNotFound(err) {
+			return nil, &FunctionNotFoundError{FunctionName: functionName, Err: err}
+		}
+		return nil, err
+	}
+	return out, nil
 }
@@ -0,0 +1,282 @@
+package lambda_deploy
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// A function as shown by list.
+type FunctionSummary struct {
+	FunctionName string `json:"function_name"`
+	Region       string `json:"region,omitempty"` // set when listing several regions
+	Runtime      string `json:"runtime"`
+	PackageType  string `json:"package_type"`
+	MemorySize   int64  `json:"memory_size"`
+	Timeout      int64  `json:"timeout"`
+	LastModified string `json:"last_modified"`
+	Description  string `json:"description"`
+}
+
+// The time format of LastModified, e.g. 2020-01-01T12:00:00.000+0000.
+const lastModifiedFormat = "2006-01-02T15:04:05.999-0700"
+
+// Which functions to list, and in what order. The zero value lists all of
+// them by name.
+type ListOptions struct {
+	NamePrefix    string
+	NameRegex     *regexp.Regexp
+	Runtime       string            // exact, or a family without version like python, see runtimeMatches
+	Tags          map[string]string // all must be set, an empty value matches any value
+	ModifiedSince time.Time
+	SortBy        string // name (default), runtime, memory, timeout or last-modified
+	Reverse       bool
+}
+
+var listSortFields = []string{"name", "runtime", "memory", "timeout", "last-modified"}
+
+func (o *ListOptions) Validate() error {
+	if o.SortBy == "" {
+		return nil
+	}
+	for _, field := range listSortFields {
+		if o.SortBy == field {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid sort field %q, use one of %s", o.SortBy, strings.Join(listSortFields, ", "))
+}
+
+// Parses --tag values, key=value or just key for any value.
+func ParseTagFilters(filters []string) map[string]string {
+	tags := make(map[string]string, len(filters))
+	for _, filter := range filters {
+		parts := strings.SplitN(filter, "=", 2)
+		if len(parts) == 2 {
+			tags[parts[0]] = parts[1]
+		} else {
+			tags[parts[0]] = ""
+		}
+	}
+	return tags
+}
+
+// Parses a point in time given as a date (2006-01-02), a RFC 3339 time or a
+// duration before now (36h).
+func ParseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid time %q, give a date (2006-01-02), a RFC 3339 time or a duration (36h)", value)
+}
+
+// Lists the functions matching options, going through all pages.
+func ListFunctions(client LambdaAPI, options *ListOptions) ([]FunctionSummary, error) {
+	functions, err := listFunctions(client, options)
+	if err != nil {
+		return nil, err
+	}
+	sortFunctions(functions, options)
+	return functions, nil
+}
+
+func listFunctions(client LambdaAPI, options *ListOptions) ([]FunctionSummary, error) {
+	configs := make([]*lambda.FunctionConfiguration, 0)
+	err := client.ListFunctionsPages(&lambda.ListFunctionsInput{}, func(page *lambda.ListFunctionsOutput, lastPage bool) bool {
+		configs = append(configs, page.Functions...)
+		return true
+	})
+	if err != nil {
+		return nil, listError(err)
+	}
+	functions := make([]FunctionSummary, 0, len(configs))
+	for _, config := range configs {
+		function := functionSummary(config)
+		if !options.matches(function) {
+			continue
+		}
+		if len(options.Tags) > 0 {
+			tags, err := client.ListTags(&lambda.ListTagsInput{Resource: config.FunctionArn})
+			if err != nil {
+				return nil, err
+			}
+			if !matchesTags(tags.Tags, options.Tags) {
+				continue
+			}
+		}
+		functions = append(functions, function)
+	}
+	return functions, nil
+}
+
+func (o *ListOptions) matches(function FunctionSummary) bool {
+	if !strings.HasPrefix(function.FunctionName, o.NamePrefix) {
+		return false
+	}
+	if o.NameRegex != nil && !o.NameRegex.MatchString(function.FunctionName) {
+		return false
+	}
+	if o.Runtime != "" && !runtimeMatches(function.Runtime, o.Runtime) {
+		return false
+	}
+	if !o.ModifiedSince.IsZero() && function.lastModified().Before(o.ModifiedSince) {
+		return false
+	}
+	return true
+}
+
+// A runtime with a version (python3.12, nodejs18.x) only matches itself, so
+// python3.1 does not match python3.12. One without (python, nodejs, provided)
+// matches every version of that family.
+func runtimeMatches(runtime, wanted string) bool {
+	if strings.IndexAny(wanted, "0123456789.") >= 0 {
+		return runtime == wanted
+	}
+	if i := strings.IndexAny(runtime, "0123456789."); i >= 0 {
+		runtime = runtime[:i]
+	}
+	return runtime == wanted
+}
+
+func matchesTags(tags map[string]*string, wanted map[string]string) bool {
+	for key, value := range wanted {
+		tag, ok := tags[key]
+		if !ok || (value != "" && aws.StringValue(tag) != value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f FunctionSummary) lastModified() time.Time {
+	t, _ := time.Parse(lastModifiedFormat, f.LastModified)
+	return t
+}
+
+func sortFunctions(functions []FunctionSummary, options *ListOptions) {
+	less := func(a, b FunctionSummary) bool {
+		switch options.SortBy {
+		case "runtime":
+			if a.Runtime != b.Runtime {
+				return a.Runtime < b.Runtime
+			}
+		case "memory":
+			if a.MemorySize != b.MemorySize {
+				return a.MemorySize < b.MemorySize
+			}
+		case "timeout":
+			if a.Timeout != b.Timeout {
+				return a.Timeout < b.Timeout
+			}
+		case "last-modified":
+			if !a.lastModified().Equal(b.lastModified()) {
+				return a.lastModified().Before(b.lastModified())
+			}
+		}
+		if a.FunctionName != b.FunctionName {
+			return a.FunctionName < b.FunctionName
+		}
+		return a.Region < b.Region
+	}
+	sort.SliceStable(functions, func(i, j int) bool {
+		if options.Reverse {
+			return less(functions[j], functions[i])
+		}
+		return less(functions[i], functions[j])
+	})
+}
+
+func functionSummary(function *lambda.FunctionConfiguration) FunctionSummary {
+	packageType := aws.StringValue(function.PackageType)
+	if packageType == "" {
+		packageType = lambda.PackageTypeZip
+	}
+	return FunctionSummary{
+		FunctionName: aws.StringValue(function.FunctionName),
+		Runtime:      aws.StringValue(function.Runtime),
+		PackageType:  packageType,
+		MemorySize:   aws.Int64Value(function.MemorySize),
+		Timeout:      aws.Int64Value(function.Timeout),
+		LastModified: aws.StringValue(function.LastModified),
+		Description:  aws.StringValue(function.Description),
+	}
+}
+
+// The regions lambda is offered in, in the partition of region (aws when it
+// is not known).
+func LambdaRegions(region string) []string {
+	partition := endpoints.AwsPartition()
+	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		partition = p
+	}
+	regions := make([]string, 0)
+	if service, ok := partition.Services()[endpoints.LambdaServiceID]; ok {
+		for id := range service.Regions() {
+			regions = append(regions, id)
+		}
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+// Lists the functions in all regions at once, with a client per region from
+// newClient. Regions that are not enabled for the account are skipped. The
+// functions found are returned also when some regions failed.
+func ListFunctionsInRegions(regions []string, newClient func(region string) (LambdaAPI, error), options *ListOptions) ([]FunctionSummary, error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	functions := make([]FunctionSummary, 0)
+	failures := make(map[string]error)
+	for _, region := range regions {
+		wg.Add(1)
+		go func(region string) {
+			defer wg.Done()
+			client, err := newClient(region)
+			var found []FunctionSummary
+			if err == nil {
+				found, err = listFunctions(client, options)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if !isRegionDisabled(err) {
+					failures[region] = err
+				}
+				return
+			}
+			for _, function := range found {
+				function.Region = region
+				functions = append(functions, function)
+			}
+		}(region)
+	}
+	wg.Wait()
+	sortFunctions(functions, options)
+	if len(failures) > 0 {
+		failed := make([]string, 0, len(failures))
+		for region, err := range failures {
+			failed = append(failed, fmt.Sprintf("%s: %s", region, err))
+		}
+		sort.Strings(failed)
+		return functions, fmt.Errorf("Listing functions failed in %d region(s): %s", len(failed), strings.Join(failed, "; "))
+	}
+	return functions, nil
+}
+
+// Opt-in regions that are not enabled reject the credentials.
+func isRegionDisabled(err error) bool {
+	return strings.Contains(err.Error(), "UnrecognizedClientException")
+}
@@ -0,0 +1,194 @@
+package lambda_deploy
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+)
+
+// Deploys python-hello and copies of it with other names, runtimes and
+// memory sizes, three functions per page.
+func listFake(t *testing.T) *lambdatest.FakeLambda {
+	fake := lambdatest.NewFakeLambda()
+	fake.PageSize = 3
+	for _, function := range []struct {
+		name, runtime string
+		memory        int
+	}{
+		{"python-hello", "python2.7", 128},
+		{"orders-api", "nodejs18.x", 512},
+		{"orders-worker", "python3.12", 256},
+		{"billing", "java17", 1024},
+		{"orders-report", "python3.12", 128},
+	} {
+		lambdaDesc := loadTestDescriptor(t)
+		lambdaDesc.Function_name = function.name
+		lambdaDesc.Runtime = function.runtime
+		lambdaDesc.Memory_size = function.memory
+		assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	}
+	return fake
+}
+
+func functionNames(functions []FunctionSummary) []string {
+	names := make([]string, len(functions))
+	for i, function := range functions {
+		names[i] = function.FunctionName
+		if function.Region != "" {
+			names[i] = function.Region + "/" + names[i]
+		}
+	}
+	return names
+}
+
+func TestListFunctions(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	assert.NoError(t, LambdaDeploy(fake, testZip, loadTestDescriptor(t)))
+	functions, err := ListFunctions(fake, &ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, functions, 1)
+	function := functions[0]
+	assert.NotEmpty(t, function.LastModified)
+	function.LastModified = ""
+	assert.Equal(t, FunctionSummary{
+		FunctionName: "python-hello",
+		Runtime:      "python2.7",
+		PackageType:  "Zip",
+		MemorySize:   128,
+		Timeout:      3,
+		Description:  "python hello world",
+	}, function)
+}
+
+func TestListFunctionsPaginates(t *testing.T) {
+	fake := listFake(t)
+	functions, err := ListFunctions(fake, &ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"billing", "orders-api", "orders-report", "orders-worker", "python-hello"}, functionNames(functions))
+	listCalls := 0
+	for _, call := range fake.Calls() {
+		if call == "ListFunctions" {
+			listCalls++
+		}
+	}
+	assert.Equal(t, 2, listCalls)
+
+	lambdas, err := ListLambdas(fake)
+	assert.NoError(t, err)
+	assert.Contains(t, lambdas, "orders-report")
+	assert.Contains(t, lambdas, "python-hello")
+}
+
+func TestListFunctionsFilters(t *testing.T) {
+	fake := listFake(t)
+	_, err := fake.TagResource(&lambda.TagResourceInput{
+		Resource: aws.String(lambdatest.FunctionArn("orders-api")),
+		Tags:     map[string]*string{"team": aws.String("orders"), "env": aws.String("prod")},
+	})
+	assert.NoError(t, err)
+	_, err = fake.TagResource(&lambda.TagResourceInput{
+		Resource: aws.String(lambdatest.FunctionArn("orders-worker")),
+		Tags:     map[string]*string{"team": aws.String("orders"), "env": aws.String("dev")},
+	})
+	assert.NoError(t, err)
+
+	list := func(options *ListOptions) []string {
+		functions, err := ListFunctions(fake, options)
+		assert.NoError(t, err)
+		return functionNames(functions)
+	}
+	assert.Equal(t, []string{"orders-api", "orders-report", "orders-worker"}, list(&ListOptions{NamePrefix: "orders-"}))
+	assert.Equal(t, []string{"orders-report", "orders-worker"}, list(&ListOptions{NameRegex: regexp.MustCompile("-(worker|report)$")}))
+	assert.Equal(t, []string{"orders-report", "orders-worker", "python-hello"}, list(&ListOptions{Runtime: "python"}))
+	assert.Equal(t, []string{"orders-report", "orders-worker"}, list(&ListOptions{Runtime: "python3.12"}))
+	assert.Empty(t, list(&ListOptions{Runtime: "python3.1"}))
+	assert.Empty(t, list(&ListOptions{Runtime: "pyth"}))
+	assert.Equal(t, []string{"orders-api"}, list(&ListOptions{Runtime: "nodejs"}))
+	assert.Equal(t, []string{"orders-api", "orders-worker"}, list(&ListOptions{Tags: ParseTagFilters([]string{"team"})}))
+	assert.Equal(t, []string{"orders-api"}, list(&ListOptions{Tags: ParseTagFilters([]string{"team=orders", "env=prod"})}))
+	assert.Equal(t, []string{"orders-worker"}, list(&ListOptions{NamePrefix: "orders", Runtime: "python", Tags: map[string]string{"env": ""}}))
+	assert.Len(t, list(&ListOptions{ModifiedSince: time.Now().Add(-time.Hour)}), 5)
+	assert.Empty(t, list(&ListOptions{ModifiedSince: time.Now().Add(time.Hour)}))
+}
+
+func TestListFunctionsSorting(t *testing.T) {
+	fake := listFake(t)
+	list := func(options *ListOptions) []string {
+		assert.NoError(t, options.Validate())
+		functions, err := ListFunctions(fake, options)
+		assert.NoError(t, err)
+		return functionNames(functions)
+	}
+	assert.Equal(t, []string{"python-hello", "orders-worker", "orders-report", "orders-api", "billing"}, list(&ListOptions{Reverse: true}))
+	assert.Equal(t, []string{"orders-report", "python-hello", "orders-worker", "orders-api", "billing"}, list(&ListOptions{SortBy: "memory"}))
+	assert.Equal(t, []string{"billing", "orders-api", "orders-worker", "python-hello", "orders-report"}, list(&ListOptions{SortBy: "memory", Reverse: true}))
+	assert.Equal(t, []string{"billing", "orders-api", "python-hello", "orders-report", "orders-worker"}, list(&ListOptions{SortBy: "runtime"}))
+	assert.EqualError(t, (&ListOptions{SortBy: "size"}).Validate(), `Invalid sort field "size", use one of name, runtime, memory, timeout, last-modified`)
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2020, 3, 10, 12, 0, 0, 0, time.UTC)
+	since, err := ParseSince("36h", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 3, 9, 0, 0, 0, 0, time.UTC), since)
+	since, err = ParseSince("2020-03-01", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), since)
+	since, err = ParseSince("2020-03-01T08:30:00+02:00", now)
+	assert.NoError(t, err)
+	assert.True(t, since.Equal(time.Date(2020, 3, 1, 6, 30, 0, 0, time.UTC)))
+	_, err = ParseSince("last week", now)
+	assert.Error(t, err)
+}
+
+// A client for a region where listing fails.
+type failingLambda struct {
+	LambdaAPI
+	err error
+}
+
+func (f *failingLambda) ListFunctionsPages(*lambda.ListFunctionsInput, func(*lambda.ListFunctionsOutput, bool) bool) error {
+	return f.err
+}
+
+func TestListFunctionsInRegions(t *testing.T) {
+	west := lambdatest.NewFakeLambda()
+	east := listFake(t)
+	lambdaDesc := loadTestDescriptor(t)
+	assert.NoError(t, LambdaDeploy(west, testZip, lambdaDesc))
+	lambdaDesc.Function_name = "orders-api"
+	assert.NoError(t, LambdaDeploy(west, testZip, lambdaDesc))
+	clients := map[string]LambdaAPI{
+		"us-east-1":  east,
+		"eu-west-1":  west,
+		"ap-south-2": &failingLambda{err: awserr.New("UnrecognizedClientException", "The security token included in the request is invalid.", nil)},
+	}
+	newClient := func(region string) (LambdaAPI, error) { return clients[region], nil }
+
+	functions, err := ListFunctionsInRegions([]string{"us-east-1", "eu-west-1", "ap-south-2"}, newClient, &ListOptions{NamePrefix: "orders-api"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eu-west-1/orders-api", "us-east-1/orders-api"}, functionNames(functions))
+
+	functions, err = ListFunctionsInRegions([]string{"us-east-1", "eu-west-1"}, newClient, &ListOptions{SortBy: "memory", Reverse: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"us-east-1/billing", "us-east-1/orders-api", "us-east-1/orders-worker", "us-east-1/python-hello", "eu-west-1/python-hello", "us-east-1/orders-report", "eu-west-1/orders-api"}, functionNames(functions))
+
+	clients["sa-east-1"] = &failingLambda{err: errors.New("connection refused")}
+	functions, err = ListFunctionsInRegions([]string{"us-east-1", "eu-west-1", "sa-east-1"}, newClient, &ListOptions{})
+	assert.EqualError(t, err, "Listing functions failed in 1 region(s): sa-east-1: connection refused")
+	assert.Len(t, functions, 7)
+}
+
+func TestLambdaRegions(t *testing.T) {
+	regions := LambdaRegions("eu-west-1")
+	assert.Contains(t, regions, "us-east-1")
+	assert.Contains(t, regions, "eu-west-1")
+	assert.NotContains(t, regions, "cn-north-1")
+	assert.Equal(t, []string{"cn-north-1", "cn-northwest-1"}, LambdaRegions("cn-north-1"))
+}
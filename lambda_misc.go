@@ -1,33 +1,77 @@
 package lambda_deploy
 
 import (
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"strings"
 	"fmt"
 )
 
-func ListLambdas(client *lambda.Lambda) (string) {
-	input := lambda.ListFunctionsInput{}
-	resp, err := client.ListFunctions(&input)
+func ListLambdas(client LambdaAPI) (string, error) {
+	resp := &lambda.ListFunctionsOutput{}
+	err := client.ListFunctionsPages(&lambda.ListFunctionsInput{}, func(page *lambda.ListFunctionsOutput, lastPage bool) bool {
+		resp.Functions = append(resp.Functions, page.Functions...)
+		return true
+	})
 	if err != nil {
-		if strings.Contains(err.Error(), "NoCredentialProviders") {
-			fmt.Println("Error: please check your AWS credentials")
-		}
-		panic(err)
+		return "", listError(err)
 	}
-	return resp.String()
+	return resp.String(), nil
 }
 
-func DeleteLambda(client *lambda.Lambda, functionName string) {
+func listError(err error) error {
+	if strings.Contains(err.Error(), "NoCredentialProviders") {
+		return fmt.Errorf("please check your AWS credentials: %s", err)
+	}
+	return err
+}
+
+func DeleteLambda(client LambdaAPI, functionName string) error {
 	deletionRequest := lambda.DeleteFunctionInput{FunctionName:&functionName}
 	_, err := client.DeleteFunction(&deletionRequest)
-	check(err)
+	if isNotFound(err) {
+		return &FunctionNotFoundError{FunctionName: functionName, Err: err}
+	}
+	return err
 }
 
 
-func LambdaAccountSettings(client *lambda.Lambda) (string) {
+func LambdaAccountSettings(client LambdaAPI) (string, error) {
 	input := lambda.GetAccountSettingsInput{}
 	result, err := client.GetAccountSettings(&input)
-	check(err)
-	return result.String()
+	if err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// The limits and usage of the account, as shown by account.
+type AccountSettings struct {
+	TotalCodeSizeLimit             int64 `json:"total_code_size_limit"`
+	CodeSizeZippedLimit            int64 `json:"code_size_zipped_limit"`
+	CodeSizeUnzippedLimit          int64 `json:"code_size_unzipped_limit"`
+	ConcurrentExecutionsLimit      int64 `json:"concurrent_executions_limit"`
+	UnreservedConcurrentExecutions int64 `json:"unreserved_concurrent_executions"`
+	TotalCodeSize                  int64 `json:"total_code_size"`
+	FunctionCount                  int64 `json:"function_count"`
+}
+
+func GetAccountSettings(client LambdaAPI) (*AccountSettings, error) {
+	result, err := client.GetAccountSettings(&lambda.GetAccountSettingsInput{})
+	if err != nil {
+		return nil, err
+	}
+	settings := &AccountSettings{}
+	if limit := result.AccountLimit; limit != nil {
+		settings.TotalCodeSizeLimit = aws.Int64Value(limit.TotalCodeSize)
+		settings.CodeSizeZippedLimit = aws.Int64Value(limit.CodeSizeZipped)
+		settings.CodeSizeUnzippedLimit = aws.Int64Value(limit.CodeSizeUnzipped)
+		settings.ConcurrentExecutionsLimit = aws.Int64Value(limit.ConcurrentExecutions)
+		settings.UnreservedConcurrentExecutions = aws.Int64Value(limit.UnreservedConcurrentExecutions)
+	}
+	if usage := result.AccountUsage; usage != nil {
+		settings.TotalCodeSize = aws.Int64Value(usage.TotalCodeSize)
+		settings.FunctionCount = aws.Int64Value(usage.FunctionCount)
+	}
+	return settings, nil
 }
@@ -0,0 +1,40 @@
+package lambda_deploy
+
+import (
+	"encoding/json"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+)
+
+func TestGetAccountSettings(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	assert.NoError(t, LambdaDeploy(fake, testZip, loadTestDescriptor(t)))
+	settings, err := GetAccountSettings(fake)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), settings.FunctionCount)
+	assert.True(t, settings.TotalCodeSize > 0)
+	assert.True(t, settings.ConcurrentExecutionsLimit > 0)
+}
+
+func TestInvokeResult(t *testing.T) {
+	result := NewInvokeResult("python-hello", &lambda.InvokeOutput{
+		StatusCode:      aws.Int64(200),
+		ExecutedVersion: aws.String("$LATEST"),
+		Payload:         []byte(`{"message": "hello"}`),
+	})
+	out, err := json.Marshal(result)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"function_name":"python-hello","status_code":200,"executed_version":"$LATEST","payload":{"message":"hello"}}`, string(out))
+
+	result = NewInvokeResult("python-hello", &lambda.InvokeOutput{
+		StatusCode:    aws.Int64(200),
+		FunctionError: aws.String("Unhandled"),
+		Payload:       []byte("not json"),
+	})
+	out, err = json.Marshal(result)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"function_name":"python-hello","status_code":200,"executed_version":"","function_error":"Unhandled","payload":"not json"}`, string(out))
+}
@@ -0,0 +1,184 @@
+package lambda_deploy
+
+/*
+Builds deployment zips from a source directory. The zips are deterministic:
+entries are sorted, and timestamps and permissions are fixed, so the same
+sources always give the same CodeSha256 and deploy can skip the upload.
+*/
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The timestamp of every entry, the earliest time a zip file can hold.
+var zipEntryTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Describes how to build the zip for a function. Relative paths are relative
+// to the descriptor.
+type LambdaSourceDesc struct {
+	Directory   string
+	Include     []string          // globs of files to include, default all files
+	Exclude     []string          // globs of files to leave out
+	Extra_files map[string]string // path in the zip: local file
+}
+
+// Lists the files to put in the zip, as path in the zip: local file.
+//
+// Globs are matched against paths relative to the directory, using / as
+// separator. * matches within a path segment and ** any number of segments.
+// A glob without / is matched against the name of the file, or of any
+// directory it is in, so "*.pyc" and "tests" work at any depth.
+func (s *LambdaSourceDesc) Files() (map[string]string, error) {
+	files := make(map[string]string)
+	if s.Directory != "" {
+		err := filepath.Walk(s.Directory, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(s.Directory, file)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if len(s.Include) > 0 && !matchAnyGlob(s.Include, rel) {
+				return nil
+			}
+			if matchAnyGlob(s.Exclude, rel) {
+				return nil
+			}
+			files[rel] = file
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for zipPath, file := range s.Extra_files {
+		files[path.Clean(filepath.ToSlash(zipPath))] = file
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No files to package in %q", s.Directory)
+	}
+	return files, nil
+}
+
+// Builds the zip from the source and writes it to zipfile.
+func BuildZip(source *LambdaSourceDesc, zipfile string) error {
+	files, err := source.Files()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out, err := os.Create(zipfile)
+	if err != nil {
+		return err
+	}
+	writer := zip.NewWriter(out)
+	for _, name := range names {
+		if err := addZipEntry(writer, name, files[name]); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func addZipEntry(writer *zip.Writer, name, file string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: zipEntryTime,
+	}
+	// only the executable bit is kept, as it matters for e.g. custom runtimes
+	if info.Mode()&0111 != 0 {
+		header.SetMode(0755)
+	} else {
+		header.SetMode(0644)
+	}
+	entry, err := writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, in)
+	return err
+}
+
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchGlob(pattern, name string) bool {
+	pattern = strings.TrimSuffix(filepath.ToSlash(pattern), "/")
+	segments := strings.Split(name, "/")
+	if !strings.Contains(pattern, "/") {
+		for _, segment := range segments {
+			if ok, _ := path.Match(pattern, segment); ok {
+				return true
+			}
+		}
+		return false
+	}
+	patternSegments := strings.Split(strings.TrimPrefix(pattern, "./"), "/")
+	// a match of one of the parent directories includes everything below it
+	for i := 1; i <= len(segments); i++ {
+		if matchSegments(patternSegments, segments[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
@@ -0,0 +1,109 @@
+package lambda_deploy
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+func zipEntries(t *testing.T, zipfile string) map[string]*zip.File {
+	reader, err := zip.OpenReader(zipfile)
+	assert.NoError(t, err)
+	t.Cleanup(func() { reader.Close() })
+	entries := make(map[string]*zip.File)
+	for _, f := range reader.File {
+		entries[f.Name] = f
+	}
+	return entries
+}
+
+func TestPackageFromDescriptor(t *testing.T) {
+	lambdaDesc, err := LoadDescriptorFiles([]string{"./testdata/package/lambda.yml"}, nil)
+	assert.NoError(t, err)
+	source := lambdaDesc.Lambda.Source
+	assert.Equal(t, filepath.Join("testdata", "package", "src"), source.Directory)
+
+	zipfile := filepath.Join(t.TempDir(), "packaged.zip")
+	assert.NoError(t, BuildZip(source, zipfile))
+	entries := zipEntries(t, zipfile)
+	names := make([]string, 0)
+	for name := range entries {
+		names = append(names, name)
+	}
+	assert.ElementsMatch(t, []string{"bootstrap", "config.yml", "handler.py", "lib/greeting.py"}, names)
+	assert.Equal(t, os.FileMode(0755), entries["bootstrap"].Mode().Perm())
+	assert.Equal(t, os.FileMode(0644), entries["handler.py"].Mode().Perm())
+	assert.True(t, entries["handler.py"].Modified.Equal(zipEntryTime))
+}
+
+// Copies the files under src to a new temporary directory, keeping their
+// modes, so tests can change them.
+func copyTestdata(t *testing.T, src string) string {
+	dir := t.TempDir()
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dir, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, info.Mode())
+	})
+	assert.NoError(t, err)
+	return dir
+}
+
+func TestPackageIsDeterministic(t *testing.T) {
+	src := copyTestdata(t, "./testdata/package/src")
+	source := &LambdaSourceDesc{Directory: src}
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.zip")
+	assert.NoError(t, BuildZip(source, first))
+
+	now := time.Now()
+	assert.NoError(t, os.Chtimes(filepath.Join(src, "handler.py"), now, now))
+	second := filepath.Join(dir, "second.zip")
+	assert.NoError(t, BuildZip(source, second))
+
+	assert.Equal(t, Base64sha256(first), Base64sha256(second))
+}
+
+func TestPackageInclude(t *testing.T) {
+	source := &LambdaSourceDesc{
+		Directory: "./testdata/package/src",
+		Include:   []string{"**/*.py"},
+		Exclude:   []string{"tests/"},
+	}
+	files, err := source.Files()
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	assert.Contains(t, files, "handler.py")
+	assert.Contains(t, files, "lib/greeting.py")
+}
+
+func TestPackageWithoutFiles(t *testing.T) {
+	source := &LambdaSourceDesc{Directory: "./testdata/package/src", Include: []string{"*.java"}}
+	err := BuildZip(source, filepath.Join(t.TempDir(), "empty.zip"))
+	assert.Error(t, err)
+}
+
+func TestMatchGlob(t *testing.T) {
+	assert.True(t, matchGlob("*.pyc", "lib/__pycache__/greeting.pyc"))
+	assert.True(t, matchGlob("tests", "tests/test_handler.py"))
+	assert.True(t, matchGlob("lib/**", "lib/__pycache__/greeting.pyc"))
+	assert.True(t, matchGlob("**/greeting.py", "lib/greeting.py"))
+	assert.True(t, matchGlob("**/greeting.py", "greeting.py"))
+	assert.True(t, matchGlob("lib", "lib/greeting.py"))
+	assert.False(t, matchGlob("lib/*.py", "handler.py"))
+	assert.False(t, matchGlob("tests/*.py", "lib/tests.py"))
+}
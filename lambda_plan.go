@@ -0,0 +1,226 @@
+package lambda_deploy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+const (
+	PlanActionCreate = "create"
+	PlanActionUpdate = "update"
+	PlanActionNoop   = "no-op"
+)
+
+// A single field that a deploy would change. Before is empty when the
+// function does not exist yet.
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// Describes what LambdaDeploy would do for a descriptor, without doing it.
+type DeployPlan struct {
+	FunctionName string        `json:"function_name"`
+	Action       string        `json:"action"`
+	CodeChanged  bool          `json:"code_changed"`
+	Changes      []FieldChange `json:"changes"`
+}
+
+func (p *DeployPlan) HasChanges() bool {
+	return p.Action != PlanActionNoop
+}
+
+func (p *DeployPlan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Function: %s\n", p.FunctionName)
+	fmt.Fprintf(&b, "Action:   %s\n", p.Action)
+	marker := "~"
+	if p.Action == PlanActionCreate {
+		marker = "+"
+	}
+	for _, change := range p.Changes {
+		if p.Action == PlanActionCreate {
+			fmt.Fprintf(&b, "  %s %s: %q\n", marker, change.Field, change.After)
+		} else {
+			fmt.Fprintf(&b, "  %s %s: %q => %q\n", marker, change.Field, change.Before, change.After)
+		}
+	}
+	return b.String()
+}
+
+// Fetches the live function and works out what a deploy of the descriptor
+// and zipfile would change. Nothing is modified on AWS. zipfile may be empty
+// when the descriptor points at code in S3.
+func PlanDeploy(svc LambdaAPI, zipfile string, descriptor *LambdaFunctionDesc) (*DeployPlan, error) {
+	getFunctionInput := lambda.GetFunctionInput{FunctionName: &(descriptor.Function_name)}
+	result, err := svc.GetFunction(&getFunctionInput)
+	isDeployed, err := checkIfLambdaIsDeployed(err)
+	if err != nil {
+		return nil, err
+	}
+
+	if isDeployed {
+		if err := checkPackageType(descriptor, result.Configuration); err != nil {
+			return nil, err
+		}
+		return planUpdate(descriptor, zipfile, result), nil
+	}
+	return planCreate(descriptor, zipfile), nil
+}
+
+func planCreate(descriptor *LambdaFunctionDesc, zipfile string) *DeployPlan {
+	plan := &DeployPlan{
+		FunctionName: descriptor.Function_name,
+		Action:       PlanActionCreate,
+		CodeChanged:  true,
+		Changes:      make([]FieldChange, 0),
+	}
+	if descriptor.IsImage() {
+		plan.add("package_type", "", descriptor.Package_type)
+		plan.add("image_uri", "", descriptor.Image_uri)
+	} else if zipfile != "" {
+		plan.add("code_sha256", "", Base64sha256(zipfile))
+	}
+	if descriptor.HasS3Code() {
+		plan.add("code", "", descriptor.Code.String())
+	}
+	plan.add("description", "", descriptor.Description)
+	if descriptor.IsImage() {
+		if descriptor.Image_config != nil {
+			plan.add("image_config", "", formatImageConfig(descriptor.Image_config.input()))
+		}
+	} else {
+		plan.add("handler", "", descriptor.Handler)
+		plan.add("runtime", "", descriptor.Runtime)
+	}
+	plan.add("role", "", descriptor.Role)
+	plan.add("memory_size", "", strconv.Itoa(descriptor.Memory_size))
+	plan.add("timeout", "", strconv.Itoa(descriptor.Timeout))
+	plan.add("publish", "", strconv.FormatBool(descriptor.Publish))
+	if len(descriptor.Environment) > 0 {
+		plan.add("environment", "", formatEnvironment(aws.StringMap(descriptor.Environment), descriptor))
+	}
+	if descriptor.Vpc_config != nil {
+		plan.add("vpc_config", "", formatVpc(
+			aws.StringSlice(descriptor.Vpc_config.Subnet_ids),
+			aws.StringSlice(descriptor.Vpc_config.Security_group_ids)))
+	}
+	return plan
+}
+
+func planUpdate(descriptor *LambdaFunctionDesc, zipfile string, function *lambda.GetFunctionOutput) *DeployPlan {
+	plan := &DeployPlan{
+		FunctionName: descriptor.Function_name,
+		Action:       PlanActionNoop,
+		Changes:      make([]FieldChange, 0),
+	}
+	config := function.Configuration
+	if codeChanged(descriptor, zipfile, function) {
+		plan.CodeChanged = true
+		switch {
+		case descriptor.IsImage():
+			plan.add("image_uri", deployedImage(function), descriptor.Image_uri)
+		case zipfile == "" && descriptor.HasS3Code():
+			// code in S3 without a local zip can not be compared, so it is always updated
+			plan.add("code", aws.StringValue(config.CodeSha256), descriptor.Code.String())
+		default:
+			plan.add("code_sha256", aws.StringValue(config.CodeSha256), Base64sha256(zipfile))
+		}
+	}
+	configDiff, isDifferent := descriptor.CompareConfig(config)
+	if isDifferent {
+		plan.Changes = append(plan.Changes, diffConfig(configDiff, config, descriptor)...)
+	}
+	if len(plan.Changes) > 0 {
+		plan.Action = PlanActionUpdate
+	}
+	return plan
+}
+
+func (p *DeployPlan) add(field, before, after string) {
+	p.Changes = append(p.Changes, FieldChange{Field: field, Before: before, After: after})
+}
+
+// Turns the update input produced by CompareConfig into before/after pairs.
+// Secrets in the environment of the descriptor are masked on both sides.
+func diffConfig(input *lambda.UpdateFunctionConfigurationInput, config *lambda.FunctionConfiguration, descriptor *LambdaFunctionDesc) []FieldChange {
+	changes := make([]FieldChange, 0)
+	if input.Description != nil {
+		changes = append(changes, FieldChange{"description", aws.StringValue(config.Description), *input.Description})
+	}
+	if input.Handler != nil {
+		changes = append(changes, FieldChange{"handler", aws.StringValue(config.Handler), *input.Handler})
+	}
+	if input.Runtime != nil {
+		changes = append(changes, FieldChange{"runtime", aws.StringValue(config.Runtime), *input.Runtime})
+	}
+	if input.Role != nil {
+		changes = append(changes, FieldChange{"role", aws.StringValue(config.Role), *input.Role})
+	}
+	if input.MemorySize != nil {
+		changes = append(changes, FieldChange{"memory_size", formatInt(config.MemorySize), formatInt(input.MemorySize)})
+	}
+	if input.Timeout != nil {
+		changes = append(changes, FieldChange{"timeout", formatInt(config.Timeout), formatInt(input.Timeout)})
+	}
+	if input.Environment != nil {
+		before := ""
+		if config.Environment != nil {
+			before = formatEnvironment(config.Environment.Variables, descriptor)
+		}
+		changes = append(changes, FieldChange{"environment", before, formatEnvironment(input.Environment.Variables, descriptor)})
+	}
+	if input.VpcConfig != nil {
+		before := ""
+		if config.VpcConfig != nil {
+			before = formatVpc(config.VpcConfig.SubnetIds, config.VpcConfig.SecurityGroupIds)
+		}
+		changes = append(changes, FieldChange{"vpc_config", before, formatVpc(input.VpcConfig.SubnetIds, input.VpcConfig.SecurityGroupIds)})
+	}
+	if input.ImageConfig != nil {
+		before := ""
+		if config.ImageConfigResponse != nil {
+			before = formatImageConfig(config.ImageConfigResponse.ImageConfig)
+		}
+		changes = append(changes, FieldChange{"image_config", before, formatImageConfig(input.ImageConfig)})
+	}
+	return changes
+}
+
+func formatInt(v *int64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatInt(*v, 10)
+}
+
+func formatEnvironment(vars map[string]*string, descriptor *LambdaFunctionDesc) string {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		value := aws.StringValue(vars[k])
+		if descriptor.IsSecret(k) {
+			value = MaskedValue
+		}
+		pairs = append(pairs, k+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func formatVpc(subnets, securityGroups []*string) string {
+	if len(subnets) == 0 && len(securityGroups) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("subnet_ids=%v security_group_ids=%v",
+		aws.StringValueSlice(subnets), aws.StringValueSlice(securityGroups))
+}
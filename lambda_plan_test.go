@@ -0,0 +1,55 @@
+package lambda_deploy
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const testZip = "./testdata/test1/python_hello.zip"
+
+func TestPlanCreate(t *testing.T) {
+	lambdaDesc, err := LoadDescriptorFile("./testdata/test1/lambda-desc.yml")
+	assert.NoError(t, err)
+	plan := planCreate(lambdaDesc.Lambda, testZip)
+	assert.Equal(t, PlanActionCreate, plan.Action)
+	assert.True(t, plan.HasChanges())
+	assert.Equal(t, "code_sha256", plan.Changes[0].Field)
+	assert.Equal(t, "MqhRu7AvFO9UcpcXI4tzTp63SMLtEm6UQhl54W1w0Ss=", plan.Changes[0].After)
+}
+
+func TestPlanUpdateNoop(t *testing.T) {
+	lambdaDesc := LambdaFunctionDesc{Function_name: "my-function", Memory_size: 128}
+	config := lambda.FunctionConfiguration{
+		CodeSha256: aws.String("MqhRu7AvFO9UcpcXI4tzTp63SMLtEm6UQhl54W1w0Ss="),
+		MemorySize: aws.Int64(128),
+	}
+	plan := planUpdate(&lambdaDesc, testZip, &lambda.GetFunctionOutput{Configuration: &config})
+	assert.Equal(t, PlanActionNoop, plan.Action)
+	assert.False(t, plan.HasChanges())
+	assert.Len(t, plan.Changes, 0)
+}
+
+func TestPlanUpdateChanges(t *testing.T) {
+	lambdaDesc := LambdaFunctionDesc{
+		Function_name: "my-function",
+		Memory_size: 256,
+		Environment: map[string]string{"key": "new"},
+	}
+	config := lambda.FunctionConfiguration{
+		CodeSha256: aws.String("other"),
+		MemorySize: aws.Int64(128),
+		Environment: &lambda.EnvironmentResponse{
+			Variables: aws.StringMap(map[string]string{"key": "old"}),
+		},
+	}
+	plan := planUpdate(&lambdaDesc, testZip, &lambda.GetFunctionOutput{Configuration: &config})
+	assert.Equal(t, PlanActionUpdate, plan.Action)
+	assert.True(t, plan.CodeChanged)
+	assert.Equal(t, []FieldChange{
+		{"code_sha256", "other", "MqhRu7AvFO9UcpcXI4tzTp63SMLtEm6UQhl54W1w0Ss="},
+		{"memory_size", "128", "256"},
+		{"environment", "key=old", "key=new"},
+	}, plan.Changes)
+}
@@ -0,0 +1,185 @@
+package lambda_deploy
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// Replays the history of a function into the versions it went through, the
+// one deployed last at the end. A rollback replaces the version it restored
+// and drops the ones after it, so rolling back again goes further back.
+// Only records of functionArn count: versions of a function with the same
+// name in another region or account mean nothing here.
+func deployedVersions(records []*DeployRecord, functionArn string) []string {
+	versions := make([]string, 0)
+	for _, record := range records {
+		if record.FunctionArn != functionArn || record.Status != StatusSucceeded || record.Version == "" {
+			continue
+		}
+		if record.Action == "rollback" {
+			for len(versions) > 0 && versions[len(versions)-1] != record.RollbackTarget {
+				versions = versions[:len(versions)-1]
+			}
+			if len(versions) > 0 {
+				versions = versions[:len(versions)-1]
+			}
+		}
+		if len(versions) == 0 || versions[len(versions)-1] != record.Version {
+			versions = append(versions, record.Version)
+		}
+	}
+	return versions
+}
+
+// Goes back to version to, or without it to the version deployed before the
+// current one according to options.History. The aliases of the descriptor
+// are pointed at that version; a descriptor without aliases gets the code and
+// configuration of that version deployed and published again.
+func Rollback(svc LambdaAPI, descriptor *LambdaFunctionDesc, to string, options *DeployOptions) (*DeployResult, error) {
+	result := &DeployResult{FunctionName: descriptor.Function_name, Action: "rollback"}
+	err := rollback(svc, descriptor, to, options, result)
+	if options.History != nil && result.RolledBackTo != "" {
+		recordDeploy(options, "rollback", descriptor, result, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func rollback(svc LambdaAPI, descriptor *LambdaFunctionDesc, to string, options *DeployOptions, result *DeployResult) error {
+	functionName := descriptor.Function_name
+	if to == "" {
+		if options.History == nil {
+			return fmt.Errorf("No deploy history to find the version to roll back %s to, give the version", functionName)
+		}
+		function, err := svc.GetFunction(&lambda.GetFunctionInput{FunctionName: aws.String(functionName)})
+		if err != nil {
+			if isNotFound(err) {
+				return &FunctionNotFoundError{FunctionName: functionName, Err: err}
+			}
+			return err
+		}
+		functionArn := unqualifiedArn(aws.StringValue(function.Configuration.FunctionArn))
+		records, err := options.History.Records(functionArn)
+		if err != nil {
+			return err
+		}
+		versions := deployedVersions(records, functionArn)
+		if len(versions) < 2 {
+			return fmt.Errorf("No earlier version of %s in the deploy history to roll back to", functionName)
+		}
+		to = versions[len(versions)-2]
+		fmt.Fprintf(options.out(), "Rolling back %s from version %s to %s\n", functionName, versions[len(versions)-1], to)
+	}
+	if !isVersionNumber(to) {
+		return fmt.Errorf("Can only roll back to a published version, not %q", to)
+	}
+	target, err := svc.GetFunction(&lambda.GetFunctionInput{
+		FunctionName: aws.String(functionName),
+		Qualifier:    aws.String(to),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return &FunctionNotFoundError{FunctionName: functionName + ":" + to, Err: err}
+		}
+		return err
+	}
+	result.FunctionArn = unqualifiedArn(aws.StringValue(target.Configuration.FunctionArn))
+	result.RolledBackTo = to
+	result.CodeSha256 = aws.StringValue(target.Configuration.CodeSha256)
+
+	if len(descriptor.Aliases) > 0 {
+		result.Version = to
+		for _, alias := range descriptor.AliasNames() {
+			description := ""
+			if aliasDesc := descriptor.Aliases[alias]; aliasDesc != nil {
+				description = aliasDesc.Description
+			}
+			if err := PointAlias(svc, functionName, alias, to, description, options.out()); err != nil {
+				return &ConfigUpdateError{FunctionName: functionName, Err: fmt.Errorf("Unable to update alias %s: %s", alias, err)}
+			}
+			result.Aliases = append(result.Aliases, alias)
+		}
+		return nil
+	}
+	return restoreVersion(svc, target, options, result)
+}
+
+// Deploys the code and configuration of a published version to $LATEST, and
+// publishes it as a new version.
+func restoreVersion(svc LambdaAPI, target *lambda.GetFunctionOutput, options *DeployOptions, result *DeployResult) error {
+	config := target.Configuration
+	functionName := result.FunctionName
+	codeInput := &lambda.UpdateFunctionCodeInput{FunctionName: aws.String(functionName)}
+	if aws.StringValue(config.PackageType) == lambda.PackageTypeImage {
+		codeInput.ImageUri = target.Code.ResolvedImageUri
+		if codeInput.ImageUri == nil {
+			codeInput.ImageUri = target.Code.ImageUri
+		}
+	} else {
+		code, err := downloadCode(options.HttpClient, aws.StringValue(target.Code.Location))
+		if err != nil {
+			return &CodeUploadError{FunctionName: functionName, Err: err}
+		}
+		if sha := base64sha256Bytes(code); sha != aws.StringValue(config.CodeSha256) {
+			return &CodeUploadError{FunctionName: functionName,
+				Err: fmt.Errorf("the downloaded code has sha256 %s, expected %s", sha, aws.StringValue(config.CodeSha256))}
+		}
+		codeInput.ZipFile = code
+	}
+	fmt.Fprintf(options.out(), "Restoring the code of version %s\n", result.RolledBackTo)
+	if _, err := svc.UpdateFunctionCode(codeInput); err != nil {
+		return &CodeUploadError{FunctionName: functionName, Err: err}
+	}
+	if err := waitForDeploy(svc, functionName, options, result); err != nil {
+		return err
+	}
+	fmt.Fprintf(options.out(), "Restoring the configuration of version %s\n", result.RolledBackTo)
+	if _, err := svc.UpdateFunctionConfiguration(versionConfig(functionName, config)); err != nil {
+		return &ConfigUpdateError{FunctionName: functionName, Err: err}
+	}
+	if err := waitForDeploy(svc, functionName, options, result); err != nil {
+		return err
+	}
+	version, err := PublishVersion(svc, functionName, result.CodeSha256, "")
+	if err != nil {
+		return &ConfigUpdateError{FunctionName: functionName, Err: fmt.Errorf("Unable to publish version: %s", err)}
+	}
+	fmt.Fprintln(options.out(), "Published version:", version)
+	result.Version = version
+	return nil
+}
+
+// The configuration update that sets everything the descriptor manages back
+// to how it is in config.
+func versionConfig(functionName string, config *lambda.FunctionConfiguration) *lambda.UpdateFunctionConfigurationInput {
+	input := &lambda.UpdateFunctionConfigurationInput{
+		FunctionName: aws.String(functionName),
+		Description:  aws.String(aws.StringValue(config.Description)),
+		Role:         config.Role,
+		MemorySize:   config.MemorySize,
+		Timeout:      config.Timeout,
+		Environment:  &lambda.Environment{Variables: map[string]*string{}},
+		VpcConfig:    &lambda.VpcConfig{SubnetIds: []*string{}, SecurityGroupIds: []*string{}},
+	}
+	if aws.StringValue(config.PackageType) == lambda.PackageTypeImage {
+		input.ImageConfig = &lambda.ImageConfig{}
+		if config.ImageConfigResponse != nil && config.ImageConfigResponse.ImageConfig != nil {
+			input.ImageConfig = config.ImageConfigResponse.ImageConfig
+		}
+	} else {
+		input.Handler = config.Handler
+		input.Runtime = config.Runtime
+	}
+	if config.Environment != nil && config.Environment.Variables != nil {
+		input.Environment.Variables = config.Environment.Variables
+	}
+	if config.VpcConfig != nil {
+		input.VpcConfig.SubnetIds = config.VpcConfig.SubnetIds
+		input.VpcConfig.SecurityGroupIds = config.VpcConfig.SecurityGroupIds
+	}
+	return input
+}
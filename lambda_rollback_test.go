@@ -0,0 +1,119 @@
+package lambda_deploy
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+)
+
+func TestDeployedVersions(t *testing.T) {
+	arn := lambdatest.FunctionArn("python-hello")
+	deploy := func(version, status string) *DeployRecord {
+		return &DeployRecord{FunctionArn: arn, Action: "deploy", Version: version, Status: status}
+	}
+	rollback := func(version, target string) *DeployRecord {
+		return &DeployRecord{FunctionArn: arn, Action: "rollback", Version: version, RollbackTarget: target, Status: StatusSucceeded}
+	}
+	assert.Equal(t, []string{}, deployedVersions(nil, arn))
+	assert.Equal(t, []string{"1", "2", "4"}, deployedVersions([]*DeployRecord{
+		deploy("1", StatusSucceeded),
+		{FunctionArn: "arn:aws:lambda:eu-west-1:123456789012:function:python-hello", Action: "deploy", Version: "9", Status: StatusSucceeded},
+		{FunctionName: "python-hello", Action: "deploy", Version: "8", Status: StatusSucceeded},
+		deploy("2", StatusSucceeded),
+		deploy("2", StatusSucceeded),
+		deploy("3", StatusRolledBack),
+		deploy("", StatusFailed),
+		deploy("4", StatusSucceeded),
+	}, arn))
+	// rolled back 3 -> 2, then 2 -> 1 by republishing 1 as 4
+	assert.Equal(t, []string{"4"}, deployedVersions([]*DeployRecord{
+		deploy("1", StatusSucceeded),
+		deploy("2", StatusSucceeded),
+		deploy("3", StatusSucceeded),
+		rollback("2", "2"),
+		rollback("4", "1"),
+	}, arn))
+}
+
+func TestRollbackAliases(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	options := &DeployOptions{History: NewFileHistory(filepath.Join(t.TempDir(), "history.jsonl"))}
+	lambdaDesc := loadTestDescriptor(t)
+	lambdaDesc.Publish = true
+	lambdaDesc.Aliases = map[string]*LambdaAliasDesc{"live": {Description: "production"}}
+	_, err := LambdaDeployWithOptions(fake, testZip, lambdaDesc, options)
+	assert.NoError(t, err)
+	lambdaDesc.Timeout = 30
+	_, err = LambdaDeployWithOptions(fake, testZip, lambdaDesc, options)
+	assert.NoError(t, err)
+	// the same function in another region is not rolled back to
+	assert.NoError(t, options.History.Append(&DeployRecord{FunctionName: "python-hello", Action: "deploy", Status: StatusSucceeded,
+		Version: "7", FunctionArn: "arn:aws:lambda:eu-west-1:123456789012:function:python-hello"}))
+
+	result, err := Rollback(fake, lambdaDesc, "", options)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", result.Version)
+	assert.Equal(t, "rollback", result.Action)
+	assert.Equal(t, "1", *getAlias(t, fake, "live").FunctionVersion)
+
+	records, err := options.History.Records(lambdatest.FunctionArn("python-hello"))
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, lambdatest.FunctionArn("python-hello"), records[0].FunctionArn)
+	assert.Equal(t, "rollback", records[2].Action)
+	assert.Equal(t, []string{"live"}, records[2].Aliases)
+	assert.Equal(t, Base64sha256(testZip), records[2].CodeSha256)
+
+	_, err = Rollback(fake, lambdaDesc, "", options)
+	assert.EqualError(t, err, "No earlier version of python-hello in the deploy history to roll back to")
+
+	result, err = Rollback(fake, lambdaDesc, "2", &DeployOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "2", *getAlias(t, fake, "live").FunctionVersion)
+}
+
+func TestRollbackRepublishes(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	options := &DeployOptions{History: NewFileHistory(filepath.Join(t.TempDir(), "history.jsonl")), HttpClient: fake.HttpClient()}
+	lambdaDesc := loadTestDescriptor(t)
+	lambdaDesc.Publish = true
+	_, err := LambdaDeployWithOptions(fake, testZip, lambdaDesc, options)
+	assert.NoError(t, err)
+	lambdaDesc.Timeout = 30
+	delete(lambdaDesc.Environment, "envVar")
+	// any other file will do as the second version of the code
+	_, err = LambdaDeployWithOptions(fake, "./testdata/descriptors/vpc-descriptor.yml", lambdaDesc, options)
+	assert.NoError(t, err)
+
+	result, err := Rollback(fake, lambdaDesc, "", options)
+	assert.NoError(t, err)
+	assert.Equal(t, "3", result.Version)
+	assert.Equal(t, "1", result.RolledBackTo)
+
+	zip, _ := ioutil.ReadFile(testZip)
+	assert.Equal(t, zip, fake.Code("python-hello"))
+	config, err := fake.GetFunctionConfiguration(&lambda.GetFunctionConfigurationInput{FunctionName: aws.String("python-hello"), Qualifier: aws.String("3")})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), *config.Timeout)
+	assert.Equal(t, "yolatengo", *config.Environment.Variables["envVar"])
+
+	// the next rollback has nothing left before version 1
+	_, err = Rollback(fake, lambdaDesc, "", options)
+	assert.Error(t, err)
+}
+
+func TestRollbackToMissingVersion(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadTestDescriptor(t)
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	_, err := Rollback(fake, lambdaDesc, "9", &DeployOptions{})
+	assert.IsType(t, &FunctionNotFoundError{}, err)
+	_, err = Rollback(fake, lambdaDesc, "$LATEST", &DeployOptions{})
+	assert.EqualError(t, err, `Can only roll back to a published version, not "$LATEST"`)
+	_, err = Rollback(fake, lambdaDesc, "", &DeployOptions{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,252 @@
+package lambda_deploy
+
+/*
+Deploys code through S3 instead of sending the zip inline, which is needed for
+zips above the direct upload limit. The descriptor either points at an object
+that is already in S3:
+
+	code:
+	  s3_bucket: my-artifacts
+	  s3_key: builds/app-1.2.3.zip
+	  s3_object_version: 3HL4kqtJlcpXroDTDmJ+rmSpXd3dIbrHY
+
+or only names a bucket, in which case the zip is staged there under a key
+derived from its content, and only uploaded when that key does not exist yet.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/mitchellh/go-homedir"
+)
+
+// The largest zip Lambda accepts inline in CreateFunction or UpdateFunctionCode.
+const InlineZipLimit = 50 * 1024 * 1024
+
+// Zips larger than this are uploaded in parts of this size. S3 requires parts
+// of at least 5 MB, except for the last one.
+var multipartPartSize int64 = 16 * 1024 * 1024
+
+// Where the code of a function is in S3. Without S3_key the zip is staged in
+// S3_bucket under S3_prefix.
+type LambdaCodeDesc struct {
+	S3_bucket         string
+	S3_key            string
+	S3_object_version string
+	S3_prefix         string
+}
+
+// The S3 operations used to stage zips and keep the deploy history, a subset
+// of s3iface.S3API.
+type S3API interface {
+	HeadObject(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+	GetObject(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	PutObject(*s3.PutObjectInput) (*s3.PutObjectOutput, error)
+	CreateMultipartUpload(*s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(*s3.UploadPartInput) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(*s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(*s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error)
+}
+
+var _ S3API = s3iface.S3API(nil)
+
+func NewS3Client(sess *session.Session) *s3.S3 {
+	return s3.New(sess)
+}
+
+// Reports whether the code is taken from an existing S3 object rather than
+// from the zip.
+func (l *LambdaFunctionDesc) HasS3Code() bool {
+	return l.Code != nil && l.Code.S3_key != ""
+}
+
+func (c *LambdaCodeDesc) validate() []string {
+	errorList := make([]string, 0)
+	if c.S3_bucket == "" {
+		errorList = append(errorList, "Missing code.s3_bucket")
+	}
+	if c.S3_object_version != "" && c.S3_key == "" {
+		errorList = append(errorList, "code.s3_object_version requires code.s3_key")
+	}
+	if c.S3_prefix != "" && c.S3_key != "" {
+		errorList = append(errorList, "code.s3_prefix can not be combined with code.s3_key")
+	}
+	return errorList
+}
+
+func (c *LambdaCodeDesc) String() string {
+	location := "s3://" + c.S3_bucket + "/" + c.S3_key
+	if c.S3_object_version != "" {
+		location += "?versionId=" + c.S3_object_version
+	}
+	return location
+}
+
+// The key a zip is staged under: the hex sha256 of its content, so the same
+// zip is only uploaded once.
+func StagedKey(prefix, functionName, zipfile string) (string, error) {
+	filename, err := homedir.Expand(zipfile)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return path.Join(prefix, functionName, hex.EncodeToString(h.Sum(nil))+".zip"), nil
+}
+
+// Uploads zipfile to bucket/key unless the object already exists. Reports
+// whether it was uploaded.
+func StageZip(client S3API, bucket, key, zipfile string) (bool, error) {
+	_, err := client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		return false, nil
+	}
+	if !isS3NotFound(err) {
+		return false, err
+	}
+	filename, err := homedir.Expand(zipfile)
+	if err != nil {
+		return false, err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info.Size() > multipartPartSize {
+		return true, multipartUpload(client, bucket, key, file, info.Size())
+	}
+	_, err = client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   file,
+	})
+	return true, err
+}
+
+func multipartUpload(client S3API, bucket, key string, file *os.File, size int64) error {
+	upload, err := client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	parts := make([]*s3.CompletedPart, 0)
+	for offset, number := int64(0), int64(1); offset < size; offset, number = offset+multipartPartSize, number+1 {
+		length := multipartPartSize
+		if offset+length > size {
+			length = size - offset
+		}
+		part, err := client.UploadPart(&s3.UploadPartInput{
+			Bucket:        aws.String(bucket),
+			Key:           aws.String(key),
+			UploadId:      upload.UploadId,
+			PartNumber:    aws.Int64(number),
+			ContentLength: aws.Int64(length),
+			Body:          io.NewSectionReader(file, offset, length),
+		})
+		if err != nil {
+			client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(bucket),
+				Key:      aws.String(key),
+				UploadId: upload.UploadId,
+			})
+			return fmt.Errorf("Upload of part %d failed: %s", number, err)
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: part.ETag, PartNumber: aws.Int64(number)})
+	}
+	_, err = client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+// HeadObject has no body, so a missing object shows up as a bare 404.
+func isS3NotFound(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == 404 {
+		return true
+	}
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchKey
+	}
+	return false
+}
+
+// Works out the code to send to Lambda: the image, the S3 object of the
+// descriptor, the zip staged in S3, or the zip itself.
+func functionCode(descriptor *LambdaFunctionDesc, zipfile string, options *DeployOptions) (*lambda.FunctionCode, error) {
+	if descriptor.IsImage() {
+		return &lambda.FunctionCode{ImageUri: aws.String(descriptor.Image_uri)}, nil
+	}
+	if descriptor.HasS3Code() {
+		code := &lambda.FunctionCode{
+			S3Bucket: aws.String(descriptor.Code.S3_bucket),
+			S3Key:    aws.String(descriptor.Code.S3_key),
+		}
+		if descriptor.Code.S3_object_version != "" {
+			code.S3ObjectVersion = aws.String(descriptor.Code.S3_object_version)
+		}
+		return code, nil
+	}
+	bucket, prefix := options.S3Bucket, ""
+	if descriptor.Code != nil {
+		bucket, prefix = descriptor.Code.S3_bucket, descriptor.Code.S3_prefix
+	}
+	if bucket == "" {
+		file, err := loadFileContent(zipfile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load %q: %s", zipfile, err)
+		}
+		if len(file) > InlineZipLimit {
+			return nil, fmt.Errorf("%q is %d bytes, more than the %d that can be uploaded directly, stage it via S3 with code.s3_bucket",
+				zipfile, len(file), InlineZipLimit)
+		}
+		return &lambda.FunctionCode{ZipFile: file}, nil
+	}
+	if options.S3 == nil {
+		return nil, fmt.Errorf("No S3 client to stage %q in %s", zipfile, bucket)
+	}
+	key, err := StagedKey(prefix, descriptor.Function_name, zipfile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load %q: %s", zipfile, err)
+	}
+	uploaded, err := StageZip(options.S3, bucket, key, zipfile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to stage %q in s3://%s/%s: %s", zipfile, bucket, key, err)
+	}
+	if uploaded {
+		fmt.Fprintf(options.out(), "Uploaded %s to s3://%s/%s\n", zipfile, bucket, key)
+	} else {
+		fmt.Fprintf(options.out(), "s3://%s/%s already exists - will not upload\n", bucket, key)
+	}
+	return &lambda.FunctionCode{S3Bucket: aws.String(bucket), S3Key: aws.String(key)}, nil
+}
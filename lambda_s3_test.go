@@ -0,0 +1,101 @@
+package lambda_deploy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+)
+
+func TestDeployStagesZipInS3(t *testing.T) {
+	fakeS3 := lambdatest.NewFakeS3()
+	fake := lambdatest.NewFakeLambda()
+	fake.S3 = fakeS3
+	lambdaDesc := loadTestDescriptor(t)
+	lambdaDesc.Code = &LambdaCodeDesc{S3_bucket: "artifacts", S3_prefix: "builds"}
+	options := &DeployOptions{S3: fakeS3}
+
+	_, err := LambdaDeployWithOptions(fake, testZip, lambdaDesc, options)
+	assert.NoError(t, err)
+	key, err := StagedKey("builds", lambdaDesc.Function_name, testZip)
+	assert.NoError(t, err)
+	assert.Equal(t, "builds/python-hello/", key[:len("builds/python-hello/")])
+	zip, _ := ioutil.ReadFile(testZip)
+	assert.Equal(t, zip, fakeS3.Object("artifacts", key))
+	assert.Equal(t, zip, fake.Code(lambdaDesc.Function_name))
+
+	// the same zip is not uploaded again
+	uploaded, err := StageZip(fakeS3, "artifacts", key, testZip)
+	assert.NoError(t, err)
+	assert.False(t, uploaded)
+	assert.Equal(t, []string{"HeadObject", "PutObject", "HeadObject"}, fakeS3.Calls())
+}
+
+func TestDeployStagesWithBucketFromOptions(t *testing.T) {
+	fakeS3 := lambdatest.NewFakeS3()
+	fake := lambdatest.NewFakeLambda()
+	fake.S3 = fakeS3
+	lambdaDesc := loadTestDescriptor(t)
+	_, err := LambdaDeployWithOptions(fake, testZip, lambdaDesc, &DeployOptions{S3: fakeS3, S3Bucket: "artifacts"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"HeadObject", "PutObject"}, fakeS3.Calls())
+}
+
+func TestDeployFromExistingS3Object(t *testing.T) {
+	fakeS3 := lambdatest.NewFakeS3()
+	fake := lambdatest.NewFakeLambda()
+	fake.S3 = fakeS3
+	zip, _ := ioutil.ReadFile(testZip)
+	fakeS3.SetObject("artifacts", "app-1.zip", zip)
+	lambdaDesc := loadTestDescriptor(t)
+	lambdaDesc.Code = &LambdaCodeDesc{S3_bucket: "artifacts", S3_key: "app-1.zip"}
+
+	plan, err := PlanDeploy(fake, "", lambdaDesc)
+	assert.NoError(t, err)
+	assert.Contains(t, plan.String(), "s3://artifacts/app-1.zip")
+
+	_, err = LambdaDeployWithOptions(fake, "", lambdaDesc, &DeployOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, zip, fake.Code(lambdaDesc.Function_name))
+	// without a local zip to compare with, the code is always updated
+	_, err = LambdaDeployWithOptions(fake, "", lambdaDesc, &DeployOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GetFunction", "GetFunction", "CreateFunction", "GetFunctionConfiguration", "GetFunction", "UpdateFunctionCode", "GetFunctionConfiguration"}, fake.Calls())
+	assert.Empty(t, fakeS3.Calls())
+}
+
+func TestDeployFromMissingS3Object(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	fake.S3 = lambdatest.NewFakeS3()
+	lambdaDesc := loadTestDescriptor(t)
+	lambdaDesc.Code = &LambdaCodeDesc{S3_bucket: "artifacts", S3_key: "missing.zip"}
+	_, err := LambdaDeployWithOptions(fake, "", lambdaDesc, &DeployOptions{})
+	assert.IsType(t, &CodeUploadError{}, err)
+}
+
+func TestStageZipMultipart(t *testing.T) {
+	original := multipartPartSize
+	multipartPartSize = 100
+	defer func() { multipartPartSize = original }()
+
+	zipfile := filepath.Join(t.TempDir(), "big.zip")
+	content := bytes.Repeat([]byte("0123456789"), 25)
+	assert.NoError(t, ioutil.WriteFile(zipfile, content, 0644))
+	fakeS3 := lambdatest.NewFakeS3()
+	uploaded, err := StageZip(fakeS3, "artifacts", "big.zip", zipfile)
+	assert.NoError(t, err)
+	assert.True(t, uploaded)
+	assert.Equal(t, content, fakeS3.Object("artifacts", "big.zip"))
+	assert.Equal(t, []string{"HeadObject", "CreateMultipartUpload", "UploadPart", "UploadPart", "UploadPart", "CompleteMultipartUpload"}, fakeS3.Calls())
+}
+
+func TestCodeDescriptorValidation(t *testing.T) {
+	lambdaDesc := loadTestDescriptor(t)
+	lambdaDesc.Code = &LambdaCodeDesc{S3_key: "app.zip", S3_prefix: "builds"}
+	err := lambdaDesc.Validate()
+	assert.Error(t, err)
+	assert.Equal(t, []string{"Missing code.s3_bucket", "code.s3_prefix can not be combined with code.s3_key"},
+		err.(*DescriptorValidationError).Errors)
+}
@@ -0,0 +1,164 @@
+package lambda_deploy
+
+/*
+Resolves secret references in environment variables, so secrets do not have
+to be written in the descriptor:
+
+	ssm:/prod/db/password               SSM parameter (decrypted)
+	secretsmanager:prod/api-key         Secrets Manager secret string
+	secretsmanager:prod/api-key#field   field of a Secrets Manager JSON secret
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+const (
+	ssmPrefix            = "ssm:"
+	secretsManagerPrefix = "secretsmanager:"
+
+	// Shown instead of the value of a secret in diffs and plans.
+	MaskedValue = "********"
+)
+
+// Looks up the value for a secret reference, e.g. "ssm:/prod/db/password".
+type SecretResolver interface {
+	ResolveSecret(reference string) (string, error)
+}
+
+// The SSM operations used to resolve ssm: references, a subset of ssmiface.SSMAPI.
+type SSMAPI interface {
+	GetParameter(*ssm.GetParameterInput) (*ssm.GetParameterOutput, error)
+}
+
+// The Secrets Manager operations used to resolve secretsmanager: references,
+// a subset of secretsmanageriface.SecretsManagerAPI.
+type SecretsManagerAPI interface {
+	GetSecretValue(*secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+var _ SSMAPI = ssmiface.SSMAPI(nil)
+var _ SecretsManagerAPI = secretsmanageriface.SecretsManagerAPI(nil)
+
+// Resolves ssm: and secretsmanager: references using the AWS APIs.
+type AwsSecretResolver struct {
+	SSM            SSMAPI
+	SecretsManager SecretsManagerAPI
+}
+
+func NewSecretResolver(sess *session.Session) *AwsSecretResolver {
+	return &AwsSecretResolver{
+		SSM:            ssm.New(sess),
+		SecretsManager: secretsmanager.New(sess),
+	}
+}
+
+func IsSecretReference(value string) bool {
+	return strings.HasPrefix(value, ssmPrefix) || strings.HasPrefix(value, secretsManagerPrefix)
+}
+
+func (r *AwsSecretResolver) ResolveSecret(reference string) (string, error) {
+	switch {
+	case strings.HasPrefix(reference, ssmPrefix):
+		name := strings.TrimPrefix(reference, ssmPrefix)
+		output, err := r.SSM.GetParameter(&ssm.GetParameterInput{
+			Name:           aws.String(name),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return "", err
+		}
+		return aws.StringValue(output.Parameter.Value), nil
+	case strings.HasPrefix(reference, secretsManagerPrefix):
+		secretId := strings.TrimPrefix(reference, secretsManagerPrefix)
+		field := ""
+		if i := strings.LastIndex(secretId, "#"); i >= 0 {
+			secretId, field = secretId[:i], secretId[i+1:]
+		}
+		output, err := r.SecretsManager.GetSecretValue(&secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secretId),
+		})
+		if err != nil {
+			return "", err
+		}
+		if field == "" {
+			return aws.StringValue(output.SecretString), nil
+		}
+		return secretField(aws.StringValue(output.SecretString), field)
+	default:
+		return "", fmt.Errorf("not a secret reference: %q", reference)
+	}
+}
+
+func secretField(secret, field string) (string, error) {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, can not get field %q", field)
+	}
+	value, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", field)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+// Replaces every secret reference in the environment with its value. The
+// variables are remembered as secrets, so their values are masked in plans.
+// All references that can not be resolved are reported together.
+func (l *LambdaFunctionDesc) ResolveSecrets(resolver SecretResolver) error {
+	keys := make([]string, 0, len(l.Environment))
+	for k, v := range l.Environment {
+		if IsSecretReference(v) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	errorList := make([]string, 0)
+	for _, k := range keys {
+		value, err := resolver.ResolveSecret(l.Environment[k])
+		if err != nil {
+			errorList = append(errorList, fmt.Sprintf("%s (%s): %s", k, l.Environment[k], err))
+			continue
+		}
+		l.Environment[k] = value
+		if l.secrets == nil {
+			l.secrets = make(map[string]bool)
+		}
+		l.secrets[k] = true
+	}
+	if len(errorList) > 0 {
+		return fmt.Errorf("Unable to resolve secrets for %s: %s", l.Function_name, strings.Join(errorList, ", "))
+	}
+	return nil
+}
+
+// Reports whether the environment variable holds a resolved secret.
+func (l *LambdaFunctionDesc) IsSecret(key string) bool {
+	return l.secrets[key]
+}
+
+// Reports whether any of the functions has a secret reference left to resolve.
+func HasSecretReferences(functions []*LambdaFunctionDesc) bool {
+	for _, function := range functions {
+		for _, v := range function.Environment {
+			if IsSecretReference(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package lambda_deploy
+
+import (
+	"bytes"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+)
+
+func newFakeSecretResolver() *AwsSecretResolver {
+	return &AwsSecretResolver{
+		SSM: lambdatest.NewFakeSSM(map[string]string{
+			"/prod/db/password": "hunter2",
+		}),
+		SecretsManager: lambdatest.NewFakeSecretsManager(map[string]string{
+			"prod/api-key": `{"key": "abc", "retries": 3}`,
+			"prod/token": "plain-token",
+		}),
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	lambdaDesc := LambdaFunctionDesc{Function_name: "my-function", Environment: map[string]string{
+		"DB_PASSWORD": "ssm:/prod/db/password",
+		"API_KEY": "secretsmanager:prod/api-key#key",
+		"RETRIES": "secretsmanager:prod/api-key#retries",
+		"TOKEN": "secretsmanager:prod/token",
+		"STAGE": "prod",
+	}}
+	err := lambdaDesc.ResolveSecrets(newFakeSecretResolver())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"DB_PASSWORD": "hunter2",
+		"API_KEY": "abc",
+		"RETRIES": "3",
+		"TOKEN": "plain-token",
+		"STAGE": "prod",
+	}, lambdaDesc.Environment)
+	assert.True(t, lambdaDesc.IsSecret("DB_PASSWORD"))
+	assert.False(t, lambdaDesc.IsSecret("STAGE"))
+}
+
+func TestResolveSecretsReportsAllFailures(t *testing.T) {
+	lambdaDesc := LambdaFunctionDesc{Function_name: "my-function", Environment: map[string]string{
+		"A": "ssm:/missing",
+		"B": "secretsmanager:prod/api-key#missing",
+		"C": "secretsmanager:prod/token#field",
+	}}
+	err := lambdaDesc.ResolveSecrets(newFakeSecretResolver())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "A (ssm:/missing)")
+	assert.Contains(t, err.Error(), "has no field \"missing\"")
+	assert.Contains(t, err.Error(), "not a JSON object")
+}
+
+func TestSecretsAreMaskedInPlan(t *testing.T) {
+	lambdaDesc := LambdaFunctionDesc{Function_name: "my-function", Memory_size: 128, Environment: map[string]string{
+		"DB_PASSWORD": "ssm:/prod/db/password",
+		"STAGE": "prod",
+	}}
+	assert.True(t, HasSecretReferences([]*LambdaFunctionDesc{&lambdaDesc}))
+	assert.NoError(t, lambdaDesc.ResolveSecrets(newFakeSecretResolver()))
+	assert.False(t, HasSecretReferences([]*LambdaFunctionDesc{&lambdaDesc}))
+
+	config := lambda.FunctionConfiguration{
+		CodeSha256: aws.String(Base64sha256(testZip)),
+		MemorySize: aws.Int64(128),
+		Environment: &lambda.EnvironmentResponse{
+			Variables: aws.StringMap(map[string]string{"DB_PASSWORD": "old-password", "STAGE": "prod"}),
+		},
+	}
+	plan := planUpdate(&lambdaDesc, testZip, &lambda.GetFunctionOutput{Configuration: &config})
+	assert.Equal(t, []FieldChange{
+		{"environment", "DB_PASSWORD=********,STAGE=prod", "DB_PASSWORD=********,STAGE=prod"},
+	}, plan.Changes)
+	assert.NotContains(t, plan.String(), "hunter2")
+	assert.NotContains(t, plan.String(), "old-password")
+
+	configDiff, _ := lambdaDesc.CompareConfig(&config)
+	assert.NotContains(t, configDiff.String(), "hunter2")
+}
+
+func TestSecretsAreNotPrintedByDeploy(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadTestDescriptor(t)
+	lambdaDesc.Environment["DB_PASSWORD"] = "ssm:/prod/db/password"
+	assert.NoError(t, lambdaDesc.ResolveSecrets(newFakeSecretResolver()))
+	var out bytes.Buffer
+	options := &DeployOptions{Out: &out}
+	_, err := LambdaDeployWithOptions(fake, testZip, lambdaDesc, options)
+	assert.NoError(t, err)
+	lambdaDesc.Timeout = 30
+	lambdaDesc.Environment["DB_PASSWORD"] = "rotated-password"
+	_, err = LambdaDeployWithOptions(fake, "./testdata/descriptors/vpc-descriptor.yml", lambdaDesc, options)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Config is changed - differences:")
+	assert.Contains(t, out.String(), "DB_PASSWORD=********")
+	assert.NotContains(t, out.String(), "hunter2")
+	assert.NotContains(t, out.String(), "rotated-password")
+}
+
+func TestDescriptorShaDoesNotDependOnSecrets(t *testing.T) {
+	lambdaDesc := loadTestDescriptor(t)
+	lambdaDesc.Environment["DB_PASSWORD"] = "ssm:/prod/db/password"
+	assert.NoError(t, lambdaDesc.ResolveSecrets(newFakeSecretResolver()))
+	sha := descriptorSha256(lambdaDesc)
+	assert.Equal(t, "hunter2", lambdaDesc.Environment["DB_PASSWORD"], "the descriptor itself is not masked")
+
+	lambdaDesc.Environment["DB_PASSWORD"] = "rotated-password"
+	assert.Equal(t, sha, descriptorSha256(lambdaDesc))
+	lambdaDesc.Environment["envVar"] = "changed"
+	assert.NotEqual(t, sha, descriptorSha256(lambdaDesc))
+}
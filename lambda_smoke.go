@@ -0,0 +1,313 @@
+package lambda_deploy
+
+/*
+Smoke tests run by deploy against the new version, before the aliases are
+moved to it. With
+
+	lambda:
+	  smoke_tests:
+	    - name: hello
+	      payload: {"name": "world"}
+	      max_duration: 2s
+	      expect:
+	        - path: $.message
+	          equals: hello world
+	        - path: $.items[0].id
+	          matches: ^[0-9]+$
+	  rollback_on_smoke_failure: true
+
+every test invokes the function and fails when the status is not 200, the
+function raised an error, it took too long or an expectation on the response
+does not hold. A failure leaves the aliases where they are.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+type LambdaSmokeTest struct {
+	Name         string
+	Payload      interface{} // a JSON string, or YAML that is sent as JSON
+	Qualifier    string      // version or alias to invoke, default the deployed version
+	Status       int         // default 200
+	Max_duration string      // e.g. 500ms or 2s, measured around the invoke
+	Expect       []*LambdaJsonAssertion
+}
+
+// An expectation on the value at Path in the response. Values are compared
+// as text: strings as they are, everything else as JSON.
+type LambdaJsonAssertion struct {
+	Path    string // like $.items[0].id or $['key with spaces']
+	Equals  *string
+	Matches string // a regular expression
+}
+
+func (l *LambdaFunctionDesc) validateSmokeTests() []string {
+	errorList := make([]string, 0)
+	for i, test := range l.Smoke_tests {
+		name := test.name(i)
+		if _, err := test.payload(); err != nil {
+			errorList = append(errorList, fmt.Sprintf("Invalid payload of %s: %s", name, err))
+		}
+		if test.Max_duration != "" {
+			if _, err := time.ParseDuration(test.Max_duration); err != nil {
+				errorList = append(errorList, fmt.Sprintf("Invalid max_duration of %s: %s", name, err))
+			}
+		}
+		for _, assertion := range test.Expect {
+			if _, err := parseJSONPath(assertion.Path); err != nil {
+				errorList = append(errorList, fmt.Sprintf("Invalid path in %s: %s", name, err))
+			}
+			if assertion.Equals == nil && assertion.Matches == "" {
+				errorList = append(errorList, fmt.Sprintf("Expectation on %s in %s needs equals or matches", assertion.Path, name))
+			}
+			if _, err := regexp.Compile(assertion.Matches); err != nil {
+				errorList = append(errorList, fmt.Sprintf("Invalid matches in %s: %s", name, err))
+			}
+		}
+	}
+	if l.Rollback_on_smoke_failure && !l.Publish {
+		errorList = append(errorList, "rollback_on_smoke_failure requires publish: true")
+	}
+	return errorList
+}
+
+func (t *LambdaSmokeTest) name(i int) string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return fmt.Sprintf("smoke test %d", i+1)
+}
+
+func (t *LambdaSmokeTest) payload() ([]byte, error) {
+	switch payload := t.Payload.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(payload), nil
+	default:
+		return json.Marshal(jsonValue(payload))
+	}
+}
+
+// Converts the maps yaml decodes to ones json can encode.
+func jsonValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			converted[fmt.Sprint(k)] = jsonValue(v)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(value))
+		for i, v := range value {
+			converted[i] = jsonValue(v)
+		}
+		return converted
+	default:
+		return value
+	}
+}
+
+// Runs the smoke tests of the descriptor against qualifier, unless a test
+// names its own, reporting each to out. Returns a description of every
+// failure.
+func RunSmokeTests(svc LambdaAPI, descriptor *LambdaFunctionDesc, qualifier string, out io.Writer) []string {
+	failures := make([]string, 0)
+	for i, test := range descriptor.Smoke_tests {
+		name := test.name(i)
+		target := qualifier
+		if test.Qualifier != "" {
+			target = test.Qualifier
+		}
+		took, err := test.run(svc, descriptor.Function_name, target)
+		if err != nil {
+			fmt.Fprintf(out, "Smoke test %s against %s: FAILED: %s\n", name, target, err)
+			failures = append(failures, fmt.Sprintf("%s: %s", name, err))
+		} else {
+			fmt.Fprintf(out, "Smoke test %s against %s: passed (%s)\n", name, target, took)
+		}
+	}
+	return failures
+}
+
+func (t *LambdaSmokeTest) run(svc LambdaAPI, functionName, qualifier string) (time.Duration, error) {
+	payload, err := t.payload()
+	if err != nil {
+		return 0, err
+	}
+	start := waitNow()
+	output, err := InvokeFunction(svc, functionName, qualifier, payload)
+	took := waitNow().Sub(start)
+	if err != nil {
+		return took, err
+	}
+	status := t.Status
+	if status == 0 {
+		status = 200
+	}
+	if int(aws.Int64Value(output.StatusCode)) != status {
+		return took, fmt.Errorf("status %d, expected %d", aws.Int64Value(output.StatusCode), status)
+	}
+	if output.FunctionError != nil {
+		return took, fmt.Errorf("function error %s: %s", *output.FunctionError, output.Payload)
+	}
+	if t.Max_duration != "" {
+		max, _ := time.ParseDuration(t.Max_duration)
+		if took > max {
+			return took, fmt.Errorf("took %s, more than %s", took, max)
+		}
+	}
+	if len(t.Expect) == 0 {
+		return took, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(output.Payload))
+	decoder.UseNumber()
+	var response interface{}
+	if err := decoder.Decode(&response); err != nil {
+		return took, fmt.Errorf("response is not JSON: %s", err)
+	}
+	for _, assertion := range t.Expect {
+		if err := assertion.check(response); err != nil {
+			return took, err
+		}
+	}
+	return took, nil
+}
+
+func (a *LambdaJsonAssertion) check(response interface{}) error {
+	path, err := parseJSONPath(a.Path)
+	if err != nil {
+		return err
+	}
+	value, err := path.lookup(response)
+	if err != nil {
+		return fmt.Errorf("%s: %s", a.Path, err)
+	}
+	text := jsonText(value)
+	if a.Equals != nil && text != *a.Equals {
+		return fmt.Errorf("%s is %q, expected %q", a.Path, text, *a.Equals)
+	}
+	if a.Matches != "" && !regexp.MustCompile(a.Matches).MatchString(text) {
+		return fmt.Errorf("%s is %q, which does not match %s", a.Path, text, a.Matches)
+	}
+	return nil
+}
+
+func jsonText(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	default:
+		data, _ := json.Marshal(value)
+		return string(data)
+	}
+}
+
+// The steps of a JSONPath, object keys (string) and array indexes (int).
+// Only the plain $.a.b[0]['c'] form is supported.
+type jsonPath []interface{}
+
+var jsonPathKey = regexp.MustCompile(`^\.([A-Za-z_$][A-Za-z0-9_$-]*)`)
+var jsonPathIndex = regexp.MustCompile(`^\[(\d+)\]`)
+var jsonPathQuoted = regexp.MustCompile(`^\[(?:'([^']*)'|"([^"]*)")\]`)
+
+func parseJSONPath(path string) (jsonPath, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("JSONPath %q must start with $", path)
+	}
+	steps := make(jsonPath, 0)
+	rest := path[1:]
+	for rest != "" {
+		if match := jsonPathKey.FindStringSubmatch(rest); match != nil {
+			steps = append(steps, match[1])
+			rest = rest[len(match[0]):]
+		} else if match := jsonPathIndex.FindStringSubmatch(rest); match != nil {
+			index, _ := strconv.Atoi(match[1])
+			steps = append(steps, index)
+			rest = rest[len(match[0]):]
+		} else if match := jsonPathQuoted.FindStringSubmatch(rest); match != nil {
+			steps = append(steps, match[1]+match[2])
+			rest = rest[len(match[0]):]
+		} else {
+			return nil, fmt.Errorf("JSONPath %q can not be parsed at %q", path, rest)
+		}
+	}
+	return steps, nil
+}
+
+func (p jsonPath) lookup(value interface{}) (interface{}, error) {
+	for _, step := range p {
+		switch step := step.(type) {
+		case string:
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("no key %q in %s", step, jsonText(value))
+			}
+			if value, ok = object[step]; !ok {
+				return nil, fmt.Errorf("no key %q in %s", step, jsonText(object))
+			}
+		case int:
+			array, ok := value.([]interface{})
+			if !ok || step >= len(array) {
+				return nil, fmt.Errorf("no index %d in %s", step, jsonText(value))
+			}
+			value = array[step]
+		}
+	}
+	return value, nil
+}
+
+// The version published last, which a failed smoke test of a function
+// without aliases rolls back to. Empty when none was published.
+func latestVersion(svc LambdaAPI, functionName string) (string, error) {
+	versions, err := ListVersions(svc, functionName)
+	if err != nil || len(versions) == 0 {
+		return "", err
+	}
+	return versions[len(versions)-1].Version, nil
+}
+
+// Runs the smoke tests against the new version, before any alias is moved to
+// it. When they fail the aliases are left where they are; a function without
+// aliases has nothing but $LATEST to protect, so with previous set that
+// version is deployed and published again.
+func smokeTestDeploy(svc LambdaAPI, descriptor *LambdaFunctionDesc, result *DeployResult, previous string, options *DeployOptions) error {
+	qualifier := result.Version
+	if qualifier == "" {
+		qualifier = "$LATEST"
+	}
+	failures := RunSmokeTests(svc, descriptor, qualifier, options.out())
+	if len(failures) == 0 {
+		return nil
+	}
+	smokeErr := &SmokeTestError{FunctionName: descriptor.Function_name, Qualifier: qualifier, Failures: failures}
+	smokeErr.AliasesKept = len(descriptor.Aliases) > 0 || options.Shift != nil
+	if smokeErr.AliasesKept || previous == "" || previous == result.Version {
+		return smokeErr
+	}
+	target, err := svc.GetFunction(&lambda.GetFunctionInput{
+		FunctionName: aws.String(descriptor.Function_name),
+		Qualifier:    aws.String(previous),
+	})
+	if err == nil {
+		restored := &DeployResult{FunctionName: descriptor.Function_name, RolledBackTo: previous}
+		err = restoreVersion(svc, target, options, restored)
+	}
+	smokeErr.RollbackErr = err
+	smokeErr.RolledBack = err == nil
+	return smokeErr
+}
@@ -0,0 +1,173 @@
+package lambda_deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+)
+
+func loadSmokeDescriptor(t *testing.T) *LambdaFunctionDesc {
+	lambdaDesc, err := LoadDescriptorFile("./testdata/descriptors/smoke-descriptor.yml")
+	assert.NoError(t, err)
+	return lambdaDesc.Lambda
+}
+
+// Answers hello <name>, or fails for the given name.
+func helloHandler(failFor string) lambdatest.InvokeHandler {
+	return func(payload []byte) ([]byte, error) {
+		var event map[string]string
+		json.Unmarshal(payload, &event)
+		if event["name"] == failFor && failFor != "" {
+			return nil, errors.New("no " + failFor + " here")
+		}
+		message := "hello"
+		if event["name"] != "" {
+			message += " " + event["name"]
+		}
+		return json.Marshal(map[string]interface{}{"message": message, "items": []map[string]int{{"id": 42}}})
+	}
+}
+
+func TestParseJSONPath(t *testing.T) {
+	path, err := parseJSONPath(`$.items[0]['id'].x["a b"]`)
+	assert.NoError(t, err)
+	assert.Equal(t, jsonPath{"items", 0, "id", "x", "a b"}, path)
+	path, err = parseJSONPath("$")
+	assert.NoError(t, err)
+	assert.Empty(t, path)
+	_, err = parseJSONPath("items[0]")
+	assert.EqualError(t, err, `JSONPath "items[0]" must start with $`)
+	_, err = parseJSONPath("$.items[*]")
+	assert.EqualError(t, err, `JSONPath "$.items[*]" can not be parsed at "[*]"`)
+}
+
+func TestValidateSmokeTests(t *testing.T) {
+	lambdaDesc := loadSmokeDescriptor(t)
+	assert.NoError(t, lambdaDesc.Validate())
+	lambdaDesc.Publish = false
+	lambdaDesc.Smoke_tests[0].Max_duration = "2"
+	lambdaDesc.Smoke_tests[0].Expect = append(lambdaDesc.Smoke_tests[0].Expect,
+		&LambdaJsonAssertion{Path: "$.a"}, &LambdaJsonAssertion{Path: "a", Matches: "("})
+	err := lambdaDesc.Validate()
+	assert.IsType(t, &DescriptorValidationError{}, err)
+	assert.Equal(t, []string{
+		`Invalid max_duration of hello: time: missing unit in duration "2"`,
+		"Expectation on $.a in hello needs equals or matches",
+		`Invalid path in hello: JSONPath "a" must start with $`,
+		"Invalid matches in hello: error parsing regexp: missing closing ): `(`",
+		"rollback_on_smoke_failure requires publish: true",
+	}, err.(*DescriptorValidationError).Errors)
+}
+
+func TestDeployRunsSmokeTests(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	fake.Handlers["python-hello"] = helloHandler("")
+	result, err := LambdaDeployWithOptions(fake, testZip, loadSmokeDescriptor(t), &DeployOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", result.Version)
+	calls := fake.Calls()
+	assert.Equal(t, []string{"Invoke", "Invoke"}, calls[len(calls)-2:])
+}
+
+func TestSmokeTestFailures(t *testing.T) {
+	fakeClock(t)
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadSmokeDescriptor(t)
+	lambdaDesc.Rollback_on_smoke_failure = false
+	assert.NoError(t, LambdaDeploy(fake, testZip, loadTestDescriptor(t)))
+
+	fake.Handlers["python-hello"] = helloHandler("world")
+	assert.Equal(t, []string{
+		`hello: function error Unhandled: {"errorMessage":"no world here","errorType":"Error"}`,
+	}, RunSmokeTests(fake, lambdaDesc, "$LATEST", ioutil.Discard))
+
+	fake.Handlers["python-hello"] = func(payload []byte) ([]byte, error) {
+		waitSleep(3 * time.Second)
+		return []byte(`{"message": "hi", "items": []}`), nil
+	}
+	assert.Equal(t, []string{
+		"hello: took 3s, more than 2s",
+		`empty: $.message is "hi", expected "hello"`,
+	}, RunSmokeTests(fake, lambdaDesc, "$LATEST", ioutil.Discard))
+
+	lambdaDesc.Smoke_tests[0].Max_duration = ""
+	assert.Equal(t, []string{`hello: $.message is "hi", expected "hello world"`},
+		RunSmokeTests(fake, lambdaDesc, "", ioutil.Discard)[:1])
+	lambdaDesc.Smoke_tests[0].Expect = lambdaDesc.Smoke_tests[0].Expect[1:]
+	assert.Equal(t, []string{`hello: $.items[0]['id']: no index 0 in []`},
+		RunSmokeTests(fake, lambdaDesc, "", ioutil.Discard)[:1])
+
+	lambdaDesc.Smoke_tests[0].Status = 202
+	assert.Equal(t, []string{"hello: status 200, expected 202"}, RunSmokeTests(fake, lambdaDesc, "", ioutil.Discard)[:1])
+
+	lambdaDesc.Smoke_tests[0].Qualifier = "7"
+	assert.Equal(t, `hello: Lambda function "python-hello:7" not found`, RunSmokeTests(fake, lambdaDesc, "", ioutil.Discard)[0])
+}
+
+func TestDeploySmokeTestsKeepAliases(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	fake.Handlers["python-hello"] = helloHandler("")
+	lambdaDesc := loadSmokeDescriptor(t)
+	lambdaDesc.Aliases = map[string]*LambdaAliasDesc{"live": {Description: "production"}}
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+
+	fake.Handlers["python-hello:2"] = helloHandler("world")
+	lambdaDesc.Timeout = 30
+	lambdaDesc.Rollback_on_smoke_failure = false
+	calls := len(fake.Calls())
+	_, err := LambdaDeployWithOptions(fake, testZip, lambdaDesc, &DeployOptions{})
+	assert.EqualError(t, err, `Smoke tests of lambda function "python-hello" (2) failed: `+
+		`hello: function error Unhandled: {"errorMessage":"no world here","errorType":"Error"}, the aliases were not moved`)
+	assert.True(t, err.(*SmokeTestError).AliasesKept)
+	assert.False(t, err.(*SmokeTestError).RolledBack)
+	assert.Equal(t, "1", *getAlias(t, fake, "live").FunctionVersion)
+	assert.NotContains(t, fake.Calls()[calls:], "UpdateAlias", "the alias never pointed at the failed version")
+
+	// nor is traffic shifted to it
+	fake.Handlers["python-hello:3"] = helloHandler("world")
+	lambdaDesc.Timeout = 35
+	calls = len(fake.Calls())
+	_, err = LambdaDeployWithOptions(fake, testZip, lambdaDesc, &DeployOptions{Shift: &ShiftOptions{Alias: "live"}})
+	assert.True(t, err.(*SmokeTestError).AliasesKept)
+	assert.NotContains(t, fake.Calls()[calls:], "UpdateAlias")
+
+	// skipping them leaves the new version live
+	lambdaDesc.Timeout = 40
+	result, err := LambdaDeployWithOptions(fake, testZip, lambdaDesc, &DeployOptions{SkipSmokeTests: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "4", result.Version)
+	assert.Equal(t, "4", *getAlias(t, fake, "live").FunctionVersion)
+}
+
+func TestDeploySmokeTestsRepublishPreviousVersion(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	fake.Handlers["python-hello"] = helloHandler("")
+	lambdaDesc := loadSmokeDescriptor(t)
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+
+	fake.Handlers["python-hello:2"] = helloHandler("world")
+	lambdaDesc.Timeout = 30
+	_, err := LambdaDeployWithOptions(fake, testZip, lambdaDesc, &DeployOptions{HttpClient: fake.HttpClient()})
+	assert.IsType(t, &SmokeTestError{}, err)
+	config, err := fake.GetFunctionConfiguration(&lambda.GetFunctionConfigurationInput{
+		FunctionName: aws.String("python-hello"), Qualifier: aws.String("3")})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), *config.Timeout)
+}
+
+func TestDeploySmokeTestsWithoutRollback(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	fake.Handlers["python-hello"] = helloHandler("world")
+	lambdaDesc := loadSmokeDescriptor(t)
+	lambdaDesc.Rollback_on_smoke_failure = false
+	_, err := LambdaDeployWithOptions(fake, testZip, lambdaDesc, &DeployOptions{})
+	assert.IsType(t, &SmokeTestError{}, err)
+	assert.False(t, err.(*SmokeTestError).RolledBack)
+	assert.Equal(t, []string{"GetFunction", "CreateFunction", "GetFunctionConfiguration", "Invoke", "Invoke"}, fake.Calls())
+}
@@ -0,0 +1,225 @@
+package lambda_deploy
+
+/*
+Moving an alias to a new version gradually. With
+
+	lambdadeploy deploy -d lambda.yml --alias live --strategy canary10percent5minutes
+
+the new version gets 10% of the traffic of live for 5 minutes before live is
+moved to it, and with linear10percentevery1minute the share grows by 10% every
+minute. When a check fails along the way live is moved back to the version it
+pointed to before.
+*/
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// How traffic is moved to a new version: in one step of Percent followed by
+// the rest (canary), or Percent more every Interval (linear).
+type TrafficStrategy struct {
+	Linear   bool
+	Percent  int
+	Interval time.Duration
+}
+
+var (
+	canaryPattern = regexp.MustCompile(`^canary(\d+)percent(\d+)minutes?$`)
+	linearPattern = regexp.MustCompile(`^linear(\d+)percentevery(\d+)minutes?$`)
+)
+
+// Parses a strategy name like canary10percent5minutes,
+// linear10percentevery1minute or allatonce, ignoring case. All at once
+// is returned as nil.
+func ParseStrategy(name string) (*TrafficStrategy, error) {
+	lower := strings.ToLower(name)
+	if lower == "" || lower == "allatonce" {
+		return nil, nil
+	}
+	strategy := &TrafficStrategy{}
+	match := canaryPattern.FindStringSubmatch(lower)
+	if match == nil {
+		match = linearPattern.FindStringSubmatch(lower)
+		strategy.Linear = true
+	}
+	if match == nil {
+		return nil, fmt.Errorf("Unknown strategy %q, use canary<N>percent<M>minutes, linear<N>percentevery<M>minutes or allatonce", name)
+	}
+	strategy.Percent, _ = strconv.Atoi(match[1])
+	minutes, _ := strconv.Atoi(match[2])
+	strategy.Interval = time.Duration(minutes) * time.Minute
+	if strategy.Percent < 1 || strategy.Percent > 99 {
+		return nil, fmt.Errorf("Invalid strategy %q, the percentage must be between 1 and 99", name)
+	}
+	if minutes < 1 {
+		return nil, fmt.Errorf("Invalid strategy %q, the interval must be at least 1 minute", name)
+	}
+	return strategy, nil
+}
+
+func (s *TrafficStrategy) String() string {
+	if s == nil {
+		return "AllAtOnce"
+	}
+	unit := "Minutes"
+	if s.Interval == time.Minute {
+		unit = "Minute"
+	}
+	if s.Linear {
+		return fmt.Sprintf("Linear%dPercentEvery%d%s", s.Percent, int(s.Interval/time.Minute), unit)
+	}
+	return fmt.Sprintf("Canary%dPercent%d%s", s.Percent, int(s.Interval/time.Minute), unit)
+}
+
+// The weights of the new version before it gets all of the traffic.
+func (s *TrafficStrategy) Weights() []float64 {
+	if s == nil {
+		return nil
+	}
+	if !s.Linear {
+		return []float64{float64(s.Percent) / 100}
+	}
+	weights := make([]float64, 0)
+	for percent := s.Percent; percent < 100; percent += s.Percent {
+		weights = append(weights, float64(percent)/100)
+	}
+	return weights
+}
+
+type ShiftOptions struct {
+	Alias    string
+	Strategy *TrafficStrategy // nil moves the alias all at once
+
+	// Payload to invoke the new version with before shifting traffic and
+	// after every step, empty for no invoke check.
+	HealthCheck string
+	// Shell command run at the same points, with the function, alias,
+	// versions and weight in LAMBDA_* environment variables. A non-zero
+	// exit status fails the check.
+	CheckCommand string
+}
+
+// Runs a check command, can be replaced in tests.
+var checkCommand = func(command string, env []string, out io.Writer) error {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// Moves alias to version following the strategy of options, running the
+// checks before the first step and after each one. When a check fails the
+// alias is moved back and a TrafficShiftError returned. An alias that does
+// not exist yet is created pointing at version, there is no traffic to shift.
+// A nil aliasDesc keeps the description of the alias. Progress, and the
+// output of the check command, goes to out.
+func ShiftTraffic(svc LambdaAPI, functionName, version string, aliasDesc *LambdaAliasDesc, options *ShiftOptions, out io.Writer) error {
+	alias := options.Alias
+	existing, err := svc.GetAlias(&lambda.GetAliasInput{
+		FunctionName: aws.String(functionName),
+		Name:         aws.String(alias),
+	})
+	description := ""
+	if aliasDesc != nil {
+		description = aliasDesc.Description
+	}
+	if isNotFound(err) {
+		return PointAlias(svc, functionName, alias, version, description, out)
+	}
+	if err != nil {
+		return err
+	}
+	if aliasDesc == nil {
+		description = aws.StringValue(existing.Description)
+	}
+	previous := aws.StringValue(existing.FunctionVersion)
+	if previous == version {
+		return PointAlias(svc, functionName, alias, version, description, out)
+	}
+
+	check := func(weight float64) error {
+		if options.HealthCheck != "" {
+			if err := invokeCheck(svc, functionName, version, options.HealthCheck); err != nil {
+				return err
+			}
+		}
+		if options.CheckCommand != "" {
+			env := []string{
+				"LAMBDA_FUNCTION_NAME=" + functionName,
+				"LAMBDA_ALIAS=" + alias,
+				"LAMBDA_VERSION=" + version,
+				"LAMBDA_PREVIOUS_VERSION=" + previous,
+				"LAMBDA_WEIGHT=" + strconv.FormatFloat(weight, 'f', -1, 64),
+			}
+			if err := checkCommand(options.CheckCommand, env, out); err != nil {
+				return fmt.Errorf("check command failed: %s", err)
+			}
+		}
+		return nil
+	}
+	rollback := func(err error) error {
+		fmt.Fprintf(out, "Moving alias %s back to %s: %s\n", alias, previous, err)
+		shiftErr := &TrafficShiftError{FunctionName: functionName, Alias: alias, Version: version, PreviousVersion: previous, Err: err}
+		shiftErr.RollbackErr = setWeight(svc, functionName, alias, previous, version, 0)
+		return shiftErr
+	}
+
+	fmt.Fprintf(out, "Shifting alias %s from %s to %s (%s)\n", alias, previous, version, options.Strategy)
+	if err := check(0); err != nil {
+		return rollback(err)
+	}
+	for _, weight := range options.Strategy.Weights() {
+		if err := setWeight(svc, functionName, alias, previous, version, weight); err != nil {
+			return rollback(err)
+		}
+		fmt.Fprintf(out, "Alias %s sends %.0f%% of the traffic to %s, waiting %s\n", alias, weight*100, version, options.Strategy.Interval)
+		waitSleep(options.Strategy.Interval)
+		if err := check(weight); err != nil {
+			return rollback(err)
+		}
+	}
+	return PointAlias(svc, functionName, alias, version, description, out)
+}
+
+// Points alias at primary, sending weight of the traffic to version.
+// A zero weight removes the routing.
+func setWeight(svc LambdaAPI, functionName, alias, primary, version string, weight float64) error {
+	weights := map[string]*float64{}
+	if weight > 0 {
+		weights[version] = aws.Float64(weight)
+	}
+	_, err := svc.UpdateAlias(&lambda.UpdateAliasInput{
+		FunctionName:    aws.String(functionName),
+		Name:            aws.String(alias),
+		FunctionVersion: aws.String(primary),
+		RoutingConfig:   &lambda.AliasRoutingConfiguration{AdditionalVersionWeights: weights},
+	})
+	return err
+}
+
+// Invokes the version with payload, failing on errors raised by the function.
+func invokeCheck(svc LambdaAPI, functionName, version, payload string) error {
+	output, err := svc.Invoke(&lambda.InvokeInput{
+		FunctionName: aws.String(functionName),
+		Qualifier:    aws.String(version),
+		Payload:      []byte(payload),
+	})
+	if err != nil {
+		return fmt.Errorf("health check failed: %s", err)
+	}
+	if output.FunctionError != nil {
+		return fmt.Errorf("health check failed: %s error: %s", *output.FunctionError, output.Payload)
+	}
+	return nil
+}
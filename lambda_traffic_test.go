@@ -0,0 +1,150 @@
+package lambda_deploy
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+)
+
+func TestParseStrategy(t *testing.T) {
+	strategy, err := ParseStrategy("canary10percent5minutes")
+	assert.NoError(t, err)
+	assert.Equal(t, &TrafficStrategy{Percent: 10, Interval: 5 * time.Minute}, strategy)
+	assert.Equal(t, []float64{0.1}, strategy.Weights())
+	assert.Equal(t, "Canary10Percent5Minutes", strategy.String())
+
+	strategy, err = ParseStrategy("Linear25PercentEvery1Minute")
+	assert.NoError(t, err)
+	assert.Equal(t, &TrafficStrategy{Linear: true, Percent: 25, Interval: time.Minute}, strategy)
+	assert.Equal(t, []float64{0.25, 0.5, 0.75}, strategy.Weights())
+	assert.Equal(t, "Linear25PercentEvery1Minute", strategy.String())
+
+	strategy, err = ParseStrategy("AllAtOnce")
+	assert.NoError(t, err)
+	assert.Nil(t, strategy)
+	assert.Nil(t, strategy.Weights())
+
+	_, err = ParseStrategy("canary100percent5minutes")
+	assert.EqualError(t, err, `Invalid strategy "canary100percent5minutes", the percentage must be between 1 and 99`)
+	_, err = ParseStrategy("linear10percentevery0minutes")
+	assert.EqualError(t, err, `Invalid strategy "linear10percentevery0minutes", the interval must be at least 1 minute`)
+	_, err = ParseStrategy("bluegreen")
+	assert.Error(t, err)
+}
+
+// Deploys version 1 behind live, and changes the descriptor so the next
+// deploy publishes version 2.
+func deployLive(t *testing.T, fake *lambdatest.FakeLambda) *LambdaFunctionDesc {
+	lambdaDesc := loadTestDescriptor(t)
+	lambdaDesc.Publish = true
+	lambdaDesc.Aliases = map[string]*LambdaAliasDesc{"live": {Description: "production"}}
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	lambdaDesc.Timeout = 30
+	return lambdaDesc
+}
+
+func getAlias(t *testing.T, fake *lambdatest.FakeLambda, name string) *lambda.AliasConfiguration {
+	alias, err := fake.GetAlias(&lambda.GetAliasInput{FunctionName: aws.String("python-hello"), Name: aws.String(name)})
+	assert.NoError(t, err)
+	return alias
+}
+
+// Replaces the check command with one recording the weight of the alias it
+// sees, failing with err from the given check on.
+func fakeCheckCommand(t *testing.T, fake *lambdatest.FakeLambda, failAt int, err error) *[]map[string]*float64 {
+	original := checkCommand
+	seen := make([]map[string]*float64, 0)
+	checkCommand = func(command string, env []string, out io.Writer) error {
+		assert.Equal(t, "./check.sh", command)
+		assert.Contains(t, env, "LAMBDA_VERSION=2")
+		assert.Contains(t, env, "LAMBDA_PREVIOUS_VERSION=1")
+		weights := map[string]*float64{}
+		if routing := getAlias(t, fake, "live").RoutingConfig; routing != nil {
+			weights = routing.AdditionalVersionWeights
+		}
+		seen = append(seen, weights)
+		if len(seen) == failAt {
+			return err
+		}
+		return nil
+	}
+	t.Cleanup(func() { checkCommand = original })
+	return &seen
+}
+
+func TestDeployCanary(t *testing.T) {
+	delays := fakeClock(t)
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := deployLive(t, fake)
+	seen := fakeCheckCommand(t, fake, 0, nil)
+	strategy, _ := ParseStrategy("canary10percent5minutes")
+
+	result, err := LambdaDeployWithOptions(fake, testZip, lambdaDesc, &DeployOptions{Shift: &ShiftOptions{
+		Alias: "live", Strategy: strategy, HealthCheck: "{}", CheckCommand: "./check.sh",
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, "2", result.Version)
+	assert.Equal(t, []map[string]*float64{{}, {"2": aws.Float64(0.1)}}, *seen)
+	assert.Equal(t, []time.Duration{5 * time.Minute}, *delays)
+
+	alias := getAlias(t, fake, "live")
+	assert.Equal(t, "2", *alias.FunctionVersion)
+	assert.Equal(t, "production", *alias.Description)
+	assert.Nil(t, alias.RoutingConfig)
+}
+
+func TestDeployLinearRollsBack(t *testing.T) {
+	delays := fakeClock(t)
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := deployLive(t, fake)
+	seen := fakeCheckCommand(t, fake, 3, errors.New("exit status 1"))
+	strategy, _ := ParseStrategy("linear25percentevery2minutes")
+
+	_, err := LambdaDeployWithOptions(fake, testZip, lambdaDesc, &DeployOptions{Shift: &ShiftOptions{
+		Alias: "live", Strategy: strategy, CheckCommand: "./check.sh",
+	}})
+	assert.EqualError(t, err, `Shifting alias live of lambda function "python-hello" to version 2 failed, rolled back to version 1: check command failed: exit status 1`)
+	assert.IsType(t, &TrafficShiftError{}, err)
+	assert.Equal(t, []map[string]*float64{{}, {"2": aws.Float64(0.25)}, {"2": aws.Float64(0.5)}}, *seen)
+	assert.Equal(t, []time.Duration{2 * time.Minute, 2 * time.Minute}, *delays)
+
+	alias := getAlias(t, fake, "live")
+	assert.Equal(t, "1", *alias.FunctionVersion)
+	assert.Nil(t, alias.RoutingConfig)
+}
+
+func TestDeployHealthCheckFailsBeforeShifting(t *testing.T) {
+	fakeClock(t)
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := deployLive(t, fake)
+	fake.Handlers["python-hello:2"] = func(payload []byte) ([]byte, error) {
+		return nil, errors.New("boom")
+	}
+	strategy, _ := ParseStrategy("canary10percent5minutes")
+
+	_, err := LambdaDeployWithOptions(fake, testZip, lambdaDesc, &DeployOptions{Shift: &ShiftOptions{
+		Alias: "live", Strategy: strategy, HealthCheck: "{}",
+	}})
+	assert.IsType(t, &TrafficShiftError{}, err)
+	assert.Contains(t, err.Error(), `health check failed: Unhandled error: {"errorMessage":"boom","errorType":"Error"}`)
+	calls := fake.Calls()
+	assert.Equal(t, []string{"GetAlias", "Invoke", "UpdateAlias"}, calls[len(calls)-3:])
+	assert.Equal(t, "1", *getAlias(t, fake, "live").FunctionVersion)
+}
+
+func TestDeployShiftCreatesMissingAlias(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	strategy, _ := ParseStrategy("canary10percent5minutes")
+	// --alias publishes even when the descriptor does not
+	result, err := LambdaDeployWithOptions(fake, testZip, loadTestDescriptor(t), &DeployOptions{Shift: &ShiftOptions{
+		Alias: "live", Strategy: strategy,
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", result.Version)
+	assert.Equal(t, "1", *getAlias(t, fake, "live").FunctionVersion)
+}
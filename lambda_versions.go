@@ -0,0 +1,255 @@
+package lambda_deploy
+
+/*
+Publishing versions and pointing aliases at them. With
+
+	lambda:
+	  publish: true
+	  aliases:
+	    live:
+	      description: what users get
+	    staging:
+
+every deploy publishes a version (Lambda hands back the latest version when
+nothing changed since it was published), and creates or moves live and
+staging to it.
+*/
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+type LambdaAliasDesc struct {
+	Description string
+}
+
+// A published version of a function, with the aliases pointing at it.
+type FunctionVersion struct {
+	Version      string   `json:"version"`
+	Aliases      []string `json:"aliases"`
+	CodeSha256   string   `json:"code_sha256"`
+	Description  string   `json:"description"`
+	LastModified string   `json:"last_modified"`
+}
+
+// The outcome of a deploy.
+type DeployResult struct {
+	FunctionName  string        `json:"function_name"`
+	FunctionArn   string        `json:"function_arn,omitempty"` // unqualified
+	Action        string        `json:"action"`                 // create, update or no-op like DeployPlan, rollback for Rollback
+	Created       bool          `json:"created"`
+	CodeChanged   bool          `json:"code_changed"`
+	ConfigChanged bool          `json:"config_changed"`
+	ConfigChanges []FieldChange `json:"config_changes,omitempty"` // secrets are masked
+	Version       string        `json:"version,omitempty"`        // the published version, empty when not publishing
+	CodeSha256    string        `json:"code_sha256"`
+	Aliases       []string      `json:"aliases,omitempty"`        // the aliases pointed at Version
+	RolledBackTo  string        `json:"rolled_back_to,omitempty"` // the version a rollback restored
+}
+
+// Returns the alias names of the descriptor, sorted.
+func (l *LambdaFunctionDesc) AliasNames() []string {
+	names := make([]string, 0, len(l.Aliases))
+	for name := range l.Aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (l *LambdaFunctionDesc) validateAliases() []string {
+	errorList := make([]string, 0)
+	if len(l.Aliases) > 0 && !l.Publish {
+		errorList = append(errorList, "aliases require publish: true")
+	}
+	for _, name := range l.AliasNames() {
+		if name == "$LATEST" || isVersionNumber(name) {
+			errorList = append(errorList, fmt.Sprintf("Invalid alias name %q", name))
+		}
+	}
+	return errorList
+}
+
+func isVersionNumber(name string) bool {
+	for _, r := range name {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// Publishes a version of the current code and configuration, guarded by the
+// code sha so a concurrent code change is not published by accident.
+func PublishVersion(svc LambdaAPI, functionName, codeSha256, description string) (string, error) {
+	input := &lambda.PublishVersionInput{FunctionName: aws.String(functionName)}
+	if codeSha256 != "" {
+		input.CodeSha256 = aws.String(codeSha256)
+	}
+	if description != "" {
+		input.Description = aws.String(description)
+	}
+	config, err := svc.PublishVersion(input)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(config.Version), nil
+}
+
+// Creates the alias pointing at version, or moves it there, reporting what
+// it did to out. Any traffic shifting configured on the alias is removed.
+func PointAlias(svc LambdaAPI, functionName, alias, version, description string, out io.Writer) error {
+	existing, err := svc.GetAlias(&lambda.GetAliasInput{
+		FunctionName: aws.String(functionName),
+		Name:         aws.String(alias),
+	})
+	if isNotFound(err) {
+		_, err = svc.CreateAlias(&lambda.CreateAliasInput{
+			FunctionName:    aws.String(functionName),
+			Name:            aws.String(alias),
+			FunctionVersion: aws.String(version),
+			Description:     aws.String(description),
+		})
+		if err == nil {
+			fmt.Fprintf(out, "Created alias %s -> %s\n", alias, version)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if aws.StringValue(existing.FunctionVersion) == version &&
+		aws.StringValue(existing.Description) == description &&
+		(existing.RoutingConfig == nil || len(existing.RoutingConfig.AdditionalVersionWeights) == 0) {
+		fmt.Fprintf(out, "Alias %s already points to %s\n", alias, version)
+		return nil
+	}
+	_, err = svc.UpdateAlias(&lambda.UpdateAliasInput{
+		FunctionName:    aws.String(functionName),
+		Name:            aws.String(alias),
+		FunctionVersion: aws.String(version),
+		Description:     aws.String(description),
+		RoutingConfig:   &lambda.AliasRoutingConfiguration{AdditionalVersionWeights: map[string]*float64{}},
+	})
+	if err == nil {
+		fmt.Fprintf(out, "Moved alias %s from %s to %s\n", alias, aws.StringValue(existing.FunctionVersion), version)
+	}
+	return err
+}
+
+// Publishes a version of the deployed code, unless creating the function
+// published one already.
+func publishNewVersion(svc LambdaAPI, descriptor *LambdaFunctionDesc, result *DeployResult, options *DeployOptions) error {
+	if result.Version == "" {
+		version, err := PublishVersion(svc, descriptor.Function_name, result.CodeSha256, "")
+		if err != nil {
+			return &ConfigUpdateError{FunctionName: descriptor.Function_name, Err: fmt.Errorf("Unable to publish version: %s", err)}
+		}
+		result.Version = version
+	}
+	fmt.Fprintln(options.out(), "Published version:", result.Version)
+	return nil
+}
+
+// Points the aliases of the descriptor at the published version, then shifts
+// the alias of options.Shift (which may be nil) to it.
+func pointAliases(svc LambdaAPI, descriptor *LambdaFunctionDesc, result *DeployResult, options *DeployOptions) error {
+	shift := options.Shift
+	for _, alias := range descriptor.AliasNames() {
+		if shift != nil && alias == shift.Alias {
+			continue
+		}
+		description := ""
+		if aliasDesc := descriptor.Aliases[alias]; aliasDesc != nil {
+			description = aliasDesc.Description
+		}
+		if err := PointAlias(svc, descriptor.Function_name, alias, result.Version, description, options.out()); err != nil {
+			return &ConfigUpdateError{FunctionName: descriptor.Function_name, Err: fmt.Errorf("Unable to update alias %s: %s", alias, err)}
+		}
+		result.Aliases = append(result.Aliases, alias)
+	}
+	if shift == nil {
+		return nil
+	}
+	aliasDesc, listed := descriptor.Aliases[shift.Alias]
+	if listed && aliasDesc == nil {
+		aliasDesc = &LambdaAliasDesc{}
+	}
+	err := ShiftTraffic(svc, descriptor.Function_name, result.Version, aliasDesc, shift, options.out())
+	if _, ok := err.(*TrafficShiftError); err != nil && !ok {
+		return &ConfigUpdateError{FunctionName: descriptor.Function_name, Err: fmt.Errorf("Unable to update alias %s: %s", shift.Alias, err)}
+	}
+	if err == nil {
+		result.Aliases = append(result.Aliases, shift.Alias)
+	}
+	return err
+}
+
+// Lists the published versions of a function, oldest first, with the
+// aliases pointing at each. $LATEST is left out.
+func ListVersions(svc LambdaAPI, functionName string) ([]FunctionVersion, error) {
+	aliases := make(map[string][]string)
+	aliasInput := &lambda.ListAliasesInput{FunctionName: aws.String(functionName)}
+	for {
+		output, err := svc.ListAliases(aliasInput)
+		if err != nil {
+			if isNotFound(err) {
+				return nil, &FunctionNotFoundError{FunctionName: functionName, Err: err}
+			}
+			return nil, err
+		}
+		for _, alias := range output.Aliases {
+			version := aws.StringValue(alias.FunctionVersion)
+			aliases[version] = append(aliases[version], aws.StringValue(alias.Name))
+			if alias.RoutingConfig != nil {
+				for weighted := range alias.RoutingConfig.AdditionalVersionWeights {
+					aliases[weighted] = append(aliases[weighted], aws.StringValue(alias.Name)+"*")
+				}
+			}
+		}
+		if output.NextMarker == nil {
+			break
+		}
+		aliasInput.Marker = output.NextMarker
+	}
+
+	versions := make([]FunctionVersion, 0)
+	input := &lambda.ListVersionsByFunctionInput{FunctionName: aws.String(functionName)}
+	for {
+		output, err := svc.ListVersionsByFunction(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, config := range output.Versions {
+			version := aws.StringValue(config.Version)
+			if version == "$LATEST" {
+				continue
+			}
+			names := aliases[version]
+			sort.Strings(names)
+			versions = append(versions, FunctionVersion{
+				Version:      version,
+				Aliases:      names,
+				CodeSha256:   aws.StringValue(config.CodeSha256),
+				Description:  aws.StringValue(config.Description),
+				LastModified: aws.StringValue(config.LastModified),
+			})
+		}
+		if output.NextMarker == nil {
+			break
+		}
+		input.Marker = output.NextMarker
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		a, _ := strconv.Atoi(versions[i].Version)
+		b, _ := strconv.Atoi(versions[j].Version)
+		return a < b
+	})
+	return versions, nil
+}
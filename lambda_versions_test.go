@@ -0,0 +1,102 @@
+package lambda_deploy
+
+import (
+	"io/ioutil"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+)
+
+func loadAliasDescriptor(t *testing.T) *LambdaFunctionDesc {
+	lambdaDesc, err := LoadDescriptorFile("./testdata/descriptors/alias-descriptor.yml")
+	assert.NoError(t, err)
+	return lambdaDesc.Lambda
+}
+
+func TestDeployPublishesAndPointsAliases(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadAliasDescriptor(t)
+	result, err := LambdaDeployWithOptions(fake, testZip, lambdaDesc, &DeployOptions{})
+	assert.NoError(t, err)
+	assert.True(t, result.Created)
+	assert.Equal(t, PlanActionCreate, result.Action)
+	assert.Equal(t, "1", result.Version)
+
+	// nothing changed, so the same version is handed back
+	result, err = LambdaDeployWithOptions(fake, testZip, lambdaDesc, &DeployOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, PlanActionNoop, result.Action)
+	assert.Equal(t, "1", result.Version)
+
+	lambdaDesc.Memory_size = 256
+	result, err = LambdaDeployWithOptions(fake, testZip, lambdaDesc, &DeployOptions{})
+	assert.NoError(t, err)
+	assert.True(t, result.ConfigChanged)
+	assert.Equal(t, PlanActionUpdate, result.Action)
+	assert.Equal(t, "2", result.Version)
+
+	for _, name := range []string{"live", "staging"} {
+		alias, err := fake.GetAlias(&lambda.GetAliasInput{FunctionName: aws.String("python-hello"), Name: aws.String(name)})
+		assert.NoError(t, err)
+		assert.Equal(t, "2", *alias.FunctionVersion)
+	}
+	// the published version has the new configuration
+	function, err := fake.GetFunction(&lambda.GetFunctionInput{FunctionName: aws.String("python-hello"), Qualifier: aws.String("live")})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(256), *function.Configuration.MemorySize)
+
+	versions, err := ListVersions(fake, "python-hello")
+	assert.NoError(t, err)
+	assert.Equal(t, []FunctionVersion{
+		{Version: "1", CodeSha256: Base64sha256(testZip), Description: "python hello world", LastModified: versions[0].LastModified},
+		{Version: "2", Aliases: []string{"live", "staging"}, CodeSha256: Base64sha256(testZip), Description: "python hello world", LastModified: versions[1].LastModified},
+	}, versions)
+}
+
+func TestAliasesRequirePublish(t *testing.T) {
+	lambdaDesc := loadAliasDescriptor(t)
+	lambdaDesc.Publish = false
+	lambdaDesc.Aliases["42"] = nil
+	err := lambdaDesc.Validate()
+	assert.Equal(t, []string{"aliases require publish: true", `Invalid alias name "42"`},
+		err.(*DescriptorValidationError).Errors)
+}
+
+func TestListVersionsOfMissingFunction(t *testing.T) {
+	_, err := ListVersions(lambdatest.NewFakeLambda(), "missing")
+	assert.IsType(t, &FunctionNotFoundError{}, err)
+}
+
+// Updates the code of the function right before publishing, like a
+// concurrent deploy would.
+type racingLambda struct {
+	*lambdatest.FakeLambda
+	zip []byte
+}
+
+func (r *racingLambda) PublishVersion(input *lambda.PublishVersionInput) (*lambda.FunctionConfiguration, error) {
+	_, err := r.FakeLambda.UpdateFunctionCode(&lambda.UpdateFunctionCodeInput{FunctionName: input.FunctionName, ZipFile: r.zip})
+	if err != nil {
+		return nil, err
+	}
+	return r.FakeLambda.PublishVersion(input)
+}
+
+func TestPublishIsGuardedByCodeSha(t *testing.T) {
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadAliasDescriptor(t)
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	// any other file will do as the concurrently deployed code
+	other, err := ioutil.ReadFile("./testdata/descriptors/vpc-descriptor.yml")
+	assert.NoError(t, err)
+
+	lambdaDesc.Memory_size = 256
+	_, err = LambdaDeployWithOptions(&racingLambda{fake, other}, testZip, lambdaDesc, &DeployOptions{})
+	assert.IsType(t, &ConfigUpdateError{}, err)
+	assert.Contains(t, err.Error(), "is different from current CodeSHA256")
+	versions, err := ListVersions(fake, "python-hello")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1, "the concurrent code is not published")
+}
@@ -0,0 +1,76 @@
+package lambda_deploy
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// How long to wait for a function to become ready when no timeout is given.
+const DefaultWaitTimeout = 5 * time.Minute
+
+const (
+	waitFirstDelay = 500 * time.Millisecond
+	waitMaxDelay   = 5 * time.Second
+)
+
+// Replaceable in tests.
+var waitSleep = time.Sleep
+var waitNow = time.Now
+
+// Polls GetFunctionConfiguration, with backoff, until the function is Active
+// and its last update Successful, reporting each wait to out. Functions in
+// any other state, like Pending or Inactive, are polled until timeout. Returns a
+// FunctionNotReadyError with the reason when it failed or when timeout runs
+// out; a timeout of 0 means DefaultWaitTimeout.
+func WaitForFunction(svc LambdaAPI, functionName string, timeout time.Duration, out io.Writer) (*lambda.FunctionConfiguration, error) {
+	if timeout <= 0 {
+		timeout = DefaultWaitTimeout
+	}
+	deadline := waitNow().Add(timeout)
+	delay := waitFirstDelay
+	for {
+		config, err := svc.GetFunctionConfiguration(&lambda.GetFunctionConfigurationInput{
+			FunctionName: aws.String(functionName),
+		})
+		if err != nil {
+			if isNotFound(err) {
+				return nil, &FunctionNotFoundError{FunctionName: functionName, Err: err}
+			}
+			return nil, err
+		}
+		state := aws.StringValue(config.State)
+		updateStatus := aws.StringValue(config.LastUpdateStatus)
+		switch {
+		case state == lambda.StateFailed:
+			return config, notReady(functionName, config, aws.StringValue(config.StateReasonCode), aws.StringValue(config.StateReason), false)
+		case updateStatus == lambda.LastUpdateStatusFailed:
+			return config, notReady(functionName, config, aws.StringValue(config.LastUpdateStatusReasonCode), aws.StringValue(config.LastUpdateStatusReason), false)
+		case state == lambda.StateActive && updateStatus == lambda.LastUpdateStatusSuccessful:
+			return config, nil
+		}
+		if !waitNow().Add(delay).Before(deadline) {
+			return config, notReady(functionName, config, aws.StringValue(config.StateReasonCode), aws.StringValue(config.StateReason), true)
+		}
+		fmt.Fprintf(out, "Waiting for %s (State: %s, LastUpdateStatus: %s)\n", functionName, state, updateStatus)
+		waitSleep(delay)
+		delay *= 2
+		if delay > waitMaxDelay {
+			delay = waitMaxDelay
+		}
+	}
+}
+
+func notReady(functionName string, config *lambda.FunctionConfiguration, reasonCode, reason string, timedOut bool) error {
+	return &FunctionNotReadyError{
+		FunctionName:     functionName,
+		State:            aws.StringValue(config.State),
+		LastUpdateStatus: aws.StringValue(config.LastUpdateStatus),
+		ReasonCode:       reasonCode,
+		Reason:           reason,
+		TimedOut:         timedOut,
+	}
+}
@@ -0,0 +1,95 @@
+package lambda_deploy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/assert"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+)
+
+// Replaces sleeping with advancing a fake clock, and returns the delays slept.
+func fakeClock(t *testing.T) *[]time.Duration {
+	originalSleep, originalNow := waitSleep, waitNow
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	delays := make([]time.Duration, 0)
+	waitSleep = func(d time.Duration) {
+		delays = append(delays, d)
+		now = now.Add(d)
+	}
+	waitNow = func() time.Time { return now }
+	t.Cleanup(func() { waitSleep, waitNow = originalSleep, originalNow })
+	return &delays
+}
+
+func TestDeployWaitsForPendingFunction(t *testing.T) {
+	delays := fakeClock(t)
+	fake := lambdatest.NewFakeLambda()
+	fake.PendingPolls = 3
+	lambdaDesc := loadTestDescriptor(t)
+	// any other file will do as the first version of the code
+	var out bytes.Buffer
+	_, err := LambdaDeployWithOptions(fake, "./testdata/descriptors/vpc-descriptor.yml", lambdaDesc, &DeployOptions{Out: &out})
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second}, *delays)
+	assert.Contains(t, out.String(), "Waiting for python-hello (State: Pending, LastUpdateStatus: Successful)\n")
+
+	// the config update has to wait for the code update to finish
+	lambdaDesc.Memory_size = 256
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	config, err := WaitForFunction(fake, lambdaDesc.Function_name, time.Minute, ioutil.Discard)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(256), *config.MemorySize)
+	assert.Equal(t, lambda.LastUpdateStatusSuccessful, *config.LastUpdateStatus)
+}
+
+func TestWaitTimesOut(t *testing.T) {
+	delays := fakeClock(t)
+	fake := lambdatest.NewFakeLambda()
+	fake.PendingPolls = 100
+	_, err := LambdaDeployWithOptions(fake, testZip, loadTestDescriptor(t), &DeployOptions{WaitTimeout: 10 * time.Second})
+	assert.IsType(t, &FunctionNotReadyError{}, err)
+	assert.True(t, err.(*FunctionNotReadyError).TimedOut)
+	assert.Equal(t, lambda.StatePending, err.(*FunctionNotReadyError).State)
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second}, *delays)
+}
+
+func TestWaitReportsFailure(t *testing.T) {
+	fakeClock(t)
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadTestDescriptor(t)
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	assert.NoError(t, fake.SetState(lambdaDesc.Function_name, lambda.StateActive, lambda.LastUpdateStatusFailed, "SubnetOutOfIPAddresses"))
+	_, err := WaitForFunction(fake, lambdaDesc.Function_name, 0, ioutil.Discard)
+	assert.EqualError(t, err, `Lambda function "python-hello" is not ready (State: Active, LastUpdateStatus: Failed): SubnetOutOfIPAddresses: Simulated failure: SubnetOutOfIPAddresses`)
+
+	_, err = WaitForFunction(fake, "missing", 0, ioutil.Discard)
+	assert.IsType(t, &FunctionNotFoundError{}, err)
+}
+
+func TestWaitForInactiveFunction(t *testing.T) {
+	fakeClock(t)
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadTestDescriptor(t)
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	assert.NoError(t, fake.SetState(lambdaDesc.Function_name, lambda.StateInactive, lambda.LastUpdateStatusSuccessful, ""))
+	_, err := WaitForFunction(fake, lambdaDesc.Function_name, 10*time.Second, ioutil.Discard)
+	assert.IsType(t, &FunctionNotReadyError{}, err)
+	assert.True(t, err.(*FunctionNotReadyError).TimedOut, "an inactive function is not ready")
+	assert.Equal(t, lambda.StateInactive, err.(*FunctionNotReadyError).State)
+}
+
+func TestWaitForFailedFunction(t *testing.T) {
+	delays := fakeClock(t)
+	fake := lambdatest.NewFakeLambda()
+	lambdaDesc := loadTestDescriptor(t)
+	assert.NoError(t, LambdaDeploy(fake, testZip, lambdaDesc))
+	assert.NoError(t, fake.SetState(lambdaDesc.Function_name, lambda.StateFailed, lambda.LastUpdateStatusSuccessful, "EniLimitExceeded"))
+	_, err := WaitForFunction(fake, lambdaDesc.Function_name, time.Minute, ioutil.Discard)
+	assert.IsType(t, &FunctionNotReadyError{}, err)
+	assert.False(t, err.(*FunctionNotReadyError).TimedOut, "a failed function fails right away")
+	assert.Equal(t, "EniLimitExceeded", err.(*FunctionNotReadyError).ReasonCode)
+	assert.Empty(t, *delays)
+}
@@ -0,0 +1,705 @@
+/*
+Package lambdatest provides an in-memory fake of the AWS Lambda API, so code
+using package lambda_deploy can be tested without an AWS account.
+*/
+package lambdatest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+)
+
+const (
+	FakeRegion    = "us-east-1"
+	FakeAccountId = "123456789012"
+
+	// GetFunction returns code locations under this URL, see Download.
+	codeURL = "https://fake-lambda.local/code/"
+)
+
+// Called by Invoke with the request payload. A returned error is reported
+// the way Lambda reports an unhandled function error.
+type InvokeHandler func(payload []byte) ([]byte, error)
+
+// An in-memory implementation of the Lambda operations used by this tool.
+// The zero value is not usable, create one with NewFakeLambda.
+type FakeLambda struct {
+	// Embedded so FakeLambda satisfies lambdaiface.LambdaAPI. Operations that
+	// are not implemented by the fake panic when called.
+	lambdaiface.LambdaAPI
+
+	// Invoke handlers keyed by function name, or by name:version for a
+	// published version. Without a handler the payload is echoed back.
+	// Invoking an alias runs the version it points to, additional version
+	// weights are ignored.
+	Handlers map[string]InvokeHandler
+
+	// Where code given as S3Bucket/S3Key is read from. Without it such code
+	// is rejected.
+	S3 *FakeS3
+
+	// After a create or update, the number of GetFunctionConfiguration calls
+	// that report the function as Pending or the update as InProgress. Until
+	// then further updates fail with ResourceConflictException, as on AWS.
+	PendingPolls int
+
+	// The number of functions, versions, aliases or event source mappings
+	// the List operations return per page when the request sets no MaxItems,
+	// 0 for all of them. AWS returns 50.
+	PageSize int
+
+	mu        sync.Mutex
+	functions map[string]*fakeFunction
+	images    map[string]string // digests of the tags pushed with PushImage
+	mappings  []*lambda.EventSourceMappingConfiguration
+	calls     []string
+}
+
+type fakeFunction struct {
+	config   lambda.FunctionConfiguration
+	code     []byte
+	imageUri string
+	pending  int
+
+	versions []fakeVersion // published, versions[i] is version i+1
+	changed  bool          // since the last version was published
+	aliases  map[string]*lambda.AliasConfiguration
+	tags     map[string]*string
+
+	policy      []map[string]interface{} // statements of the resource policy
+	concurrency *int64                   // reserved, nil when not set
+}
+
+type fakeVersion struct {
+	config lambda.FunctionConfiguration
+	code   []byte
+}
+
+func NewFakeLambda() *FakeLambda {
+	return &FakeLambda{
+		Handlers:  make(map[string]InvokeHandler),
+		functions: make(map[string]*fakeFunction),
+		images:    make(map[string]string),
+	}
+}
+
+// Points an image tag at a digest, as pushing an image to a registry does.
+// Functions given the tagged image uri afterwards get that digest, those
+// already deployed keep theirs until their code is updated.
+func (f *FakeLambda) PushImage(imageUri, digest string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.images[imageUri] = digest
+}
+
+// Returns the names of the operations called so far, in order.
+func (f *FakeLambda) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// Returns the code last uploaded for a function, or nil if it does not exist.
+func (f *FakeLambda) Code(functionName string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if fn, ok := f.functions[functionName]; ok {
+		return fn.code
+	}
+	return nil
+}
+
+// Computes the CodeSha256 value Lambda reports for a zip file.
+func CodeSha256(zip []byte) string {
+	sum := sha256.Sum256(zip)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func FunctionArn(functionName string) string {
+	return fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", FakeRegion, FakeAccountId, functionName)
+}
+
+func notFound(functionName string) error {
+	return awserr.New(lambda.ErrCodeResourceNotFoundException,
+		"Function not found: "+FunctionArn(functionName), nil)
+}
+
+// Returns the zip given inline, or the one in the fake S3.
+func (f *FakeLambda) code(zip []byte, bucket, key *string) ([]byte, error) {
+	if bucket == nil && key == nil {
+		return zip, nil
+	}
+	var content []byte
+	if f.S3 != nil {
+		content = f.S3.Object(aws.StringValue(bucket), aws.StringValue(key))
+	}
+	if content == nil {
+		return nil, awserr.New(lambda.ErrCodeInvalidParameterValueException,
+			"Error occurred while GetObject. S3 Error Code: NoSuchKey. S3 Error Message: The specified key does not exist.", nil)
+	}
+	return content, nil
+}
+
+// Sets the state reported for a function, e.g. to simulate a failed update.
+// reasonCode is reported as StateReasonCode when state is Failed, and as
+// LastUpdateStatusReasonCode otherwise.
+func (f *FakeLambda) SetState(functionName, state, lastUpdateStatus, reasonCode string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn, ok := f.functions[functionName]
+	if !ok {
+		return notFound(functionName)
+	}
+	fn.pending = 0
+	fn.config.State = aws.String(state)
+	fn.config.LastUpdateStatus = aws.String(lastUpdateStatus)
+	fn.config.StateReasonCode, fn.config.StateReason = nil, nil
+	fn.config.LastUpdateStatusReasonCode, fn.config.LastUpdateStatusReason = nil, nil
+	if reasonCode != "" && state == lambda.StateFailed {
+		fn.config.StateReasonCode = aws.String(reasonCode)
+		fn.config.StateReason = aws.String("Simulated failure: " + reasonCode)
+	} else if reasonCode != "" {
+		fn.config.LastUpdateStatusReasonCode = aws.String(reasonCode)
+		fn.config.LastUpdateStatusReason = aws.String("Simulated failure: " + reasonCode)
+	}
+	return nil
+}
+
+func conflict(functionName string) error {
+	return awserr.New(lambda.ErrCodeResourceConflictException,
+		"The operation cannot be performed at this time. An update is in progress for resource: "+FunctionArn(functionName), nil)
+}
+
+func (f *FakeLambda) record(operation string) {
+	f.calls = append(f.calls, operation)
+}
+
+func (f *FakeLambda) CreateFunction(input *lambda.CreateFunctionInput) (*lambda.FunctionConfiguration, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("CreateFunction")
+	name := aws.StringValue(input.FunctionName)
+	if _, ok := f.functions[name]; ok {
+		return nil, awserr.New(lambda.ErrCodeResourceConflictException,
+			"Function already exist: "+name, nil)
+	}
+	fn := &fakeFunction{}
+	fn.config = lambda.FunctionConfiguration{
+		FunctionName: aws.String(name),
+		FunctionArn:  aws.String(FunctionArn(name)),
+		Description:  aws.String(aws.StringValue(input.Description)),
+		Handler:      input.Handler,
+		Runtime:      input.Runtime,
+		Role:         input.Role,
+		MemorySize:   aws.Int64(128),
+		Timeout:      aws.Int64(3),
+		Version:      aws.String("$LATEST"),
+	}
+	if input.MemorySize != nil {
+		fn.config.MemorySize = input.MemorySize
+	}
+	if input.Timeout != nil {
+		fn.config.Timeout = input.Timeout
+	}
+	fn.config.PackageType = aws.String(lambda.PackageTypeZip)
+	if aws.StringValue(input.PackageType) == lambda.PackageTypeImage {
+		if input.Code.ImageUri == nil {
+			return nil, awserr.New(lambda.ErrCodeInvalidParameterValueException,
+				"ImageUri is required for package type Image", nil)
+		}
+		fn.config.PackageType = input.PackageType
+		fn.setImage(*input.Code.ImageUri, f.imageDigest(*input.Code.ImageUri))
+		fn.setImageConfig(input.ImageConfig)
+	} else {
+		zip, err := f.code(input.Code.ZipFile, input.Code.S3Bucket, input.Code.S3Key)
+		if err != nil {
+			return nil, err
+		}
+		fn.setCode(zip)
+	}
+	fn.setEnvironment(input.Environment)
+	fn.setVpcConfig(input.VpcConfig)
+	if err := fn.setTags(input.Tags); err != nil {
+		return nil, err
+	}
+	fn.config.State = aws.String(lambda.StateActive)
+	fn.config.LastUpdateStatus = aws.String(lambda.LastUpdateStatusSuccessful)
+	if f.PendingPolls > 0 {
+		fn.pending = f.PendingPolls
+		fn.config.State = aws.String(lambda.StatePending)
+	}
+	f.functions[name] = fn
+	return fn.publishIf(aws.BoolValue(input.Publish)), nil
+}
+
+func (f *FakeLambda) GetFunction(input *lambda.GetFunctionInput) (*lambda.GetFunctionOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("GetFunction")
+	name := aws.StringValue(input.FunctionName)
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	config, _, ok := fn.qualified(aws.StringValue(input.Qualifier))
+	if !ok {
+		return nil, versionNotFound(name, aws.StringValue(input.Qualifier))
+	}
+	code := &lambda.FunctionCodeLocation{
+		RepositoryType: aws.String("S3"),
+		Location:       aws.String(codeURL + url.PathEscape(name) + "/" + url.PathEscape(*config.Version)),
+	}
+	if fn.imageUri != "" {
+		code = &lambda.FunctionCodeLocation{
+			RepositoryType:   aws.String("ECR"),
+			ImageUri:         aws.String(fn.imageUri),
+			ResolvedImageUri: aws.String(imageRepository(fn.imageUri) + "@sha256:" + *fn.config.CodeSha256),
+		}
+	}
+	return &lambda.GetFunctionOutput{
+		Configuration: config,
+		Code:          code,
+	}, nil
+}
+
+// Like Code, for a version ("$LATEST" or a number) or the version an alias
+// points to.
+func (f *FakeLambda) VersionCode(name, qualifier string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	_, code, ok := fn.qualified(qualifier)
+	if !ok {
+		return nil, versionNotFound(name, qualifier)
+	}
+	return code, nil
+}
+
+// Fetches the code from a Location returned by GetFunction, the way an HTTP
+// client would from AWS.
+func (f *FakeLambda) Download(location string) ([]byte, error) {
+	parts := strings.Split(strings.TrimPrefix(location, codeURL), "/")
+	if !strings.HasPrefix(location, codeURL) || len(parts) != 2 {
+		return nil, fmt.Errorf("not a code location of the fake: %s", location)
+	}
+	name, err := url.PathUnescape(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	version, err := url.PathUnescape(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	return f.VersionCode(name, version)
+}
+
+// Returns a HTTP client that fetches code locations from the fake with
+// Download, for code that downloads the code of a function.
+func (f *FakeLambda) HttpClient() *http.Client {
+	return &http.Client{Transport: codeTransport{f}}
+}
+
+type codeTransport struct {
+	fake *FakeLambda
+}
+
+func (t codeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	response := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Request: req}
+	code, err := t.fake.Download(req.URL.String())
+	if err != nil {
+		response.StatusCode = http.StatusNotFound
+		code = []byte(err.Error())
+	}
+	response.Status = fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode))
+	response.ContentLength = int64(len(code))
+	response.Body = ioutil.NopCloser(bytes.NewReader(code))
+	return response, nil
+}
+
+// Reports the function as Pending (after create) or its update as
+// InProgress, until it has been polled PendingPolls times.
+func (f *FakeLambda) GetFunctionConfiguration(input *lambda.GetFunctionConfigurationInput) (*lambda.FunctionConfiguration, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("GetFunctionConfiguration")
+	name := aws.StringValue(input.FunctionName)
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	config := fn.config
+	if fn.pending > 0 {
+		fn.pending--
+		if fn.pending == 0 {
+			fn.config.State = aws.String(lambda.StateActive)
+			fn.config.LastUpdateStatus = aws.String(lambda.LastUpdateStatusSuccessful)
+		}
+	}
+	return &config, nil
+}
+
+func (f *FakeLambda) startUpdate(fn *fakeFunction) {
+	fn.config.LastUpdateStatus = aws.String(lambda.LastUpdateStatusSuccessful)
+	if f.PendingPolls > 0 {
+		fn.pending = f.PendingPolls
+		fn.config.LastUpdateStatus = aws.String(lambda.LastUpdateStatusInProgress)
+	}
+}
+
+func (f *FakeLambda) UpdateFunctionCode(input *lambda.UpdateFunctionCodeInput) (*lambda.FunctionConfiguration, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("UpdateFunctionCode")
+	name := aws.StringValue(input.FunctionName)
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	if fn.pending > 0 {
+		return nil, conflict(name)
+	}
+	if (input.ImageUri != nil) != (fn.imageUri != "") {
+		return nil, awserr.New(lambda.ErrCodeInvalidParameterValueException,
+			"Please provide code matching the package type "+aws.StringValue(fn.config.PackageType), nil)
+	}
+	if input.ImageUri != nil {
+		fn.setImage(*input.ImageUri, f.imageDigest(*input.ImageUri))
+	} else {
+		zip, err := f.code(input.ZipFile, input.S3Bucket, input.S3Key)
+		if err != nil {
+			return nil, err
+		}
+		fn.setCode(zip)
+	}
+	f.startUpdate(fn)
+	return fn.publishIf(aws.BoolValue(input.Publish)), nil
+}
+
+func (f *FakeLambda) UpdateFunctionConfiguration(input *lambda.UpdateFunctionConfigurationInput) (*lambda.FunctionConfiguration, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("UpdateFunctionConfiguration")
+	name := aws.StringValue(input.FunctionName)
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	if fn.pending > 0 {
+		return nil, conflict(name)
+	}
+	if input.Description != nil {
+		fn.config.Description = input.Description
+	}
+	if input.Handler != nil {
+		fn.config.Handler = input.Handler
+	}
+	if input.Runtime != nil {
+		fn.config.Runtime = input.Runtime
+	}
+	if input.Role != nil {
+		fn.config.Role = input.Role
+	}
+	if input.MemorySize != nil {
+		fn.config.MemorySize = input.MemorySize
+	}
+	if input.Timeout != nil {
+		fn.config.Timeout = input.Timeout
+	}
+	if input.Environment != nil {
+		fn.setEnvironment(input.Environment)
+	}
+	if input.VpcConfig != nil {
+		fn.setVpcConfig(input.VpcConfig)
+	}
+	if input.ImageConfig != nil {
+		fn.setImageConfig(input.ImageConfig)
+	}
+	fn.touch()
+	f.startUpdate(fn)
+	config := fn.config
+	return &config, nil
+}
+
+func (f *FakeLambda) DeleteFunction(input *lambda.DeleteFunctionInput) (*lambda.DeleteFunctionOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("DeleteFunction")
+	name := aws.StringValue(input.FunctionName)
+	if _, ok := f.functions[name]; !ok {
+		return nil, notFound(name)
+	}
+	delete(f.functions, name)
+	return &lambda.DeleteFunctionOutput{}, nil
+}
+
+// Lists functions sorted by name.
+func (f *FakeLambda) ListFunctions(input *lambda.ListFunctionsInput) (*lambda.ListFunctionsOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("ListFunctions")
+	names := make([]string, 0, len(f.functions))
+	for name := range f.functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	start, end, nextMarker, err := page(len(names), input.Marker, f.maxItems(input.MaxItems))
+	if err != nil {
+		return nil, err
+	}
+	output := &lambda.ListFunctionsOutput{
+		Functions:  make([]*lambda.FunctionConfiguration, 0, end-start),
+		NextMarker: nextMarker,
+	}
+	for _, name := range names[start:end] {
+		config := f.functions[name].config
+		output.Functions = append(output.Functions, &config)
+	}
+	return output, nil
+}
+
+func (f *FakeLambda) ListFunctionsPages(input *lambda.ListFunctionsInput, fn func(*lambda.ListFunctionsOutput, bool) bool) error {
+	pageInput := *input
+	for {
+		output, err := f.ListFunctions(&pageInput)
+		if err != nil {
+			return err
+		}
+		lastPage := output.NextMarker == nil
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+		pageInput.Marker = output.NextMarker
+	}
+}
+
+// Works out the slice of a list of count items to return for marker and
+// maxItems. Markers are the index of the first item to return.
+// The MaxItems of a request, or PageSize when it sets none.
+func (f *FakeLambda) maxItems(maxItems *int64) *int64 {
+	if maxItems == nil && f.PageSize > 0 {
+		return aws.Int64(int64(f.PageSize))
+	}
+	return maxItems
+}
+
+func page(count int, marker *string, maxItems *int64) (int, int, *string, error) {
+	start := 0
+	if marker != nil {
+		var err error
+		start, err = strconv.Atoi(*marker)
+		if err != nil || start < 0 || start > count {
+			return 0, 0, nil, awserr.New(lambda.ErrCodeInvalidParameterValueException,
+				"Invalid marker: "+*marker, nil)
+		}
+	}
+	end := count
+	if maxItems != nil && start+int(*maxItems) < end {
+		end = start + int(*maxItems)
+	}
+	if end < count {
+		return start, end, aws.String(strconv.Itoa(end)), nil
+	}
+	return start, end, nil, nil
+}
+
+func (f *FakeLambda) Invoke(input *lambda.InvokeInput) (*lambda.InvokeOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.record("Invoke")
+	name := aws.StringValue(input.FunctionName)
+	fn, ok := f.functions[name]
+	if !ok {
+		f.mu.Unlock()
+		return nil, notFound(name)
+	}
+	config, _, ok := fn.qualified(aws.StringValue(input.Qualifier))
+	if !ok {
+		f.mu.Unlock()
+		return nil, versionNotFound(name, aws.StringValue(input.Qualifier))
+	}
+	handler, ok := f.Handlers[name+":"+aws.StringValue(config.Version)]
+	if !ok {
+		handler = f.Handlers[name]
+	}
+	f.mu.Unlock()
+
+	output := &lambda.InvokeOutput{
+		StatusCode:      aws.Int64(200),
+		ExecutedVersion: config.Version,
+	}
+	if aws.StringValue(input.InvocationType) == lambda.InvocationTypeEvent {
+		output.StatusCode = aws.Int64(202)
+	}
+	if handler == nil {
+		output.Payload = input.Payload
+		return output, nil
+	}
+	payload, err := handler(input.Payload)
+	if err != nil {
+		output.FunctionError = aws.String("Unhandled")
+		payload, _ = json.Marshal(map[string]string{
+			"errorMessage": err.Error(),
+			"errorType":    "Error",
+		})
+	}
+	output.Payload = payload
+	return output, nil
+}
+
+func (f *FakeLambda) GetAccountSettings(input *lambda.GetAccountSettingsInput) (*lambda.GetAccountSettingsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("GetAccountSettings")
+	var codeSize int64
+	for _, fn := range f.functions {
+		codeSize += int64(len(fn.code))
+	}
+	return &lambda.GetAccountSettingsOutput{
+		AccountLimit: &lambda.AccountLimit{
+			CodeSizeUnzipped:               aws.Int64(262144000),
+			CodeSizeZipped:                 aws.Int64(52428800),
+			ConcurrentExecutions:           aws.Int64(1000),
+			TotalCodeSize:                  aws.Int64(80530636800),
+			UnreservedConcurrentExecutions: aws.Int64(1000),
+		},
+		AccountUsage: &lambda.AccountUsage{
+			FunctionCount: aws.Int64(int64(len(f.functions))),
+			TotalCodeSize: aws.Int64(codeSize),
+		},
+	}, nil
+}
+
+func (fn *fakeFunction) setCode(zip []byte) {
+	fn.code = zip
+	fn.config.CodeSha256 = aws.String(CodeSha256(zip))
+	fn.config.CodeSize = aws.Int64(int64(len(zip)))
+	fn.touch()
+}
+
+// Images are not pulled, the digest is taken from the uri when it is pinned to
+// one, from PushImage for a pushed tag, and made up from the uri otherwise.
+func (f *FakeLambda) imageDigest(imageUri string) string {
+	if i := strings.Index(imageUri, "@sha256:"); i >= 0 {
+		return imageUri[i+len("@sha256:"):]
+	}
+	if digest, ok := f.images[imageUri]; ok {
+		return digest
+	}
+	sum := sha256.Sum256([]byte(imageUri))
+	return hex.EncodeToString(sum[:])
+}
+
+func (fn *fakeFunction) setImage(imageUri, digest string) {
+	fn.imageUri = imageUri
+	fn.config.CodeSha256 = aws.String(digest)
+	fn.config.CodeSize = aws.Int64(0)
+	fn.touch()
+}
+
+func (fn *fakeFunction) setImageConfig(config *lambda.ImageConfig) {
+	if config == nil || (len(config.Command) == 0 && len(config.EntryPoint) == 0 && config.WorkingDirectory == nil) {
+		fn.config.ImageConfigResponse = nil
+		return
+	}
+	fn.config.ImageConfigResponse = &lambda.ImageConfigResponse{
+		ImageConfig: &lambda.ImageConfig{
+			Command:          config.Command,
+			EntryPoint:       config.EntryPoint,
+			WorkingDirectory: config.WorkingDirectory,
+		},
+	}
+}
+
+// Strips the tag or digest from an image uri.
+func imageRepository(imageUri string) string {
+	if i := strings.Index(imageUri, "@"); i >= 0 {
+		return imageUri[:i]
+	}
+	if i := strings.LastIndex(imageUri, ":"); i > strings.LastIndex(imageUri, "/") {
+		return imageUri[:i]
+	}
+	return imageUri
+}
+
+func (fn *fakeFunction) setEnvironment(env *lambda.Environment) {
+	if env == nil || len(env.Variables) == 0 {
+		fn.config.Environment = nil
+		return
+	}
+	variables := make(map[string]*string, len(env.Variables))
+	for k, v := range env.Variables {
+		variables[k] = aws.String(aws.StringValue(v))
+	}
+	fn.config.Environment = &lambda.EnvironmentResponse{Variables: variables}
+}
+
+func (fn *fakeFunction) setVpcConfig(vpc *lambda.VpcConfig) {
+	if vpc == nil || (len(vpc.SubnetIds) == 0 && len(vpc.SecurityGroupIds) == 0) {
+		fn.config.VpcConfig = nil
+		return
+	}
+	fn.config.VpcConfig = &lambda.VpcConfigResponse{
+		SubnetIds:        aws.StringSlice(aws.StringValueSlice(vpc.SubnetIds)),
+		SecurityGroupIds: aws.StringSlice(aws.StringValueSlice(vpc.SecurityGroupIds)),
+		VpcId:            aws.String("vpc-fake"),
+	}
+}
+
+func (fn *fakeFunction) touch() {
+	fn.config.LastModified = aws.String(time.Now().UTC().Format("2006-01-02T15:04:05.000-0700"))
+	fn.changed = true
+}
+
+// Returns the configuration to hand back from a create or code update,
+// publishing a new version first when asked to.
+func (fn *fakeFunction) publishIf(publish bool) *lambda.FunctionConfiguration {
+	if publish {
+		return fn.publish("")
+	}
+	config := fn.config
+	return &config
+}
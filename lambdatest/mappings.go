@@ -0,0 +1,74 @@
+package lambdatest
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// Creates an event source mapping, which is Enabled right away. Nothing is
+// ever read from the event source.
+func (f *FakeLambda) CreateEventSourceMapping(input *lambda.CreateEventSourceMappingInput) (*lambda.EventSourceMappingConfiguration, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("CreateEventSourceMapping")
+	name := functionNameFromArn(aws.StringValue(input.FunctionName))
+	if _, ok := f.functions[name]; !ok {
+		return nil, notFound(name)
+	}
+	state := "Enabled"
+	if input.Enabled != nil && !*input.Enabled {
+		state = "Disabled"
+	}
+	batchSize := input.BatchSize
+	if batchSize == nil {
+		batchSize = aws.Int64(10)
+	}
+	mapping := &lambda.EventSourceMappingConfiguration{
+		UUID:                  aws.String(fmt.Sprintf("00000000-0000-4000-8000-%012d", len(f.mappings)+1)),
+		EventSourceArn:        input.EventSourceArn,
+		FunctionArn:           aws.String(FunctionArn(name)),
+		BatchSize:             batchSize,
+		StartingPosition:      input.StartingPosition,
+		State:                 aws.String(state),
+		StateTransitionReason: aws.String("USER_INITIATED"),
+		LastProcessingResult:  aws.String("No records processed"),
+	}
+	f.mappings = append(f.mappings, mapping)
+	copied := *mapping
+	return &copied, nil
+}
+
+// Lists the mappings of FunctionName, or all of them, in the order they were
+// created.
+func (f *FakeLambda) ListEventSourceMappings(input *lambda.ListEventSourceMappingsInput) (*lambda.ListEventSourceMappingsOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("ListEventSourceMappings")
+	mappings := make([]*lambda.EventSourceMappingConfiguration, 0)
+	for _, mapping := range f.mappings {
+		if input.FunctionName != nil && *mapping.FunctionArn != FunctionArn(functionNameFromArn(*input.FunctionName)) {
+			continue
+		}
+		if input.EventSourceArn != nil && aws.StringValue(mapping.EventSourceArn) != *input.EventSourceArn {
+			continue
+		}
+		copied := *mapping
+		mappings = append(mappings, &copied)
+	}
+	start, end, nextMarker, err := page(len(mappings), input.Marker, f.maxItems(input.MaxItems))
+	if err != nil {
+		return nil, err
+	}
+	return &lambda.ListEventSourceMappingsOutput{
+		EventSourceMappings: mappings[start:end],
+		NextMarker:          nextMarker,
+	}, nil
+}
@@ -0,0 +1,111 @@
+package lambdatest
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// Adds a statement to the resource policy of the function. Principals that
+// are services (ending in .amazonaws.com) are written as Service, others as
+// AWS, like Lambda does.
+func (f *FakeLambda) AddPermission(input *lambda.AddPermissionInput) (*lambda.AddPermissionOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("AddPermission")
+	name := functionNameFromArn(aws.StringValue(input.FunctionName))
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	sid := aws.StringValue(input.StatementId)
+	for _, statement := range fn.policy {
+		if statement["Sid"] == sid {
+			return nil, awserr.New(lambda.ErrCodeResourceConflictException,
+				"The statement id ("+sid+") provided already exists. Please provide a new statement id, or remove the existing statement.", nil)
+		}
+	}
+	principal := map[string]string{"AWS": aws.StringValue(input.Principal)}
+	if strings.HasSuffix(aws.StringValue(input.Principal), ".amazonaws.com") {
+		principal = map[string]string{"Service": aws.StringValue(input.Principal)}
+	}
+	statement := map[string]interface{}{
+		"Sid":       sid,
+		"Effect":    "Allow",
+		"Principal": principal,
+		"Action":    aws.StringValue(input.Action),
+		"Resource":  FunctionArn(name),
+	}
+	if input.SourceArn != nil {
+		statement["Condition"] = map[string]interface{}{
+			"ArnLike": map[string]string{"AWS:SourceArn": *input.SourceArn},
+		}
+	}
+	fn.policy = append(fn.policy, statement)
+	out, _ := json.Marshal(statement)
+	return &lambda.AddPermissionOutput{Statement: aws.String(string(out))}, nil
+}
+
+// Returns the resource policy, or ResourceNotFoundException when the
+// function has none.
+func (f *FakeLambda) GetPolicy(input *lambda.GetPolicyInput) (*lambda.GetPolicyOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("GetPolicy")
+	name := functionNameFromArn(aws.StringValue(input.FunctionName))
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	if len(fn.policy) == 0 {
+		return nil, awserr.New(lambda.ErrCodeResourceNotFoundException,
+			"The resource you requested does not exist.", nil)
+	}
+	out, _ := json.Marshal(map[string]interface{}{
+		"Version":   "2012-10-17",
+		"Id":        "default",
+		"Statement": fn.policy,
+	})
+	return &lambda.GetPolicyOutput{Policy: aws.String(string(out))}, nil
+}
+
+func (f *FakeLambda) PutFunctionConcurrency(input *lambda.PutFunctionConcurrencyInput) (*lambda.PutFunctionConcurrencyOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("PutFunctionConcurrency")
+	name := functionNameFromArn(aws.StringValue(input.FunctionName))
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	fn.concurrency = aws.Int64(*input.ReservedConcurrentExecutions)
+	return &lambda.PutFunctionConcurrencyOutput{ReservedConcurrentExecutions: fn.concurrency}, nil
+}
+
+// Returns the reserved concurrency, an empty output when none is reserved.
+func (f *FakeLambda) GetFunctionConcurrency(input *lambda.GetFunctionConcurrencyInput) (*lambda.GetFunctionConcurrencyOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("GetFunctionConcurrency")
+	name := functionNameFromArn(aws.StringValue(input.FunctionName))
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	return &lambda.GetFunctionConcurrencyOutput{ReservedConcurrentExecutions: fn.concurrency}, nil
+}
@@ -0,0 +1,164 @@
+package lambdatest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// An in-memory fake of the S3 operations used to stage zips and keep the
+// deploy history. Objects are keyed by "bucket/key".
+type FakeS3 struct {
+	s3iface.S3API
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int64][]byte
+	calls   []string
+}
+
+func NewFakeS3() *FakeS3 {
+	return &FakeS3{
+		objects: make(map[string][]byte),
+		uploads: make(map[string]map[int64][]byte),
+	}
+}
+
+// Returns the names of the operations called so far, in order.
+func (f *FakeS3) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// Returns the content of an object, or nil if it does not exist.
+func (f *FakeS3) Object(bucket, key string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.objects[bucket+"/"+key]
+}
+
+func (f *FakeS3) SetObject(bucket, key string, content []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[bucket+"/"+key] = content
+}
+
+func (f *FakeS3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "HeadObject")
+	content, ok := f.objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.NewRequestFailure(awserr.New("NotFound", "Not Found", nil), 404, "fake")
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(content))),
+		ETag:          aws.String(etag(content)),
+	}, nil
+}
+
+func (f *FakeS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "GetObject")
+	content, ok := f.objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)]
+	if !ok {
+		return nil, awserr.NewRequestFailure(awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil), 404, "fake")
+	}
+	return &s3.GetObjectOutput{
+		Body:          ioutil.NopCloser(bytes.NewReader(content)),
+		ContentLength: aws.Int64(int64(len(content))),
+		ETag:          aws.String(etag(content)),
+	}, nil
+}
+
+func (f *FakeS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	content, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "PutObject")
+	f.objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)] = content
+	return &s3.PutObjectOutput{ETag: aws.String(etag(content))}, nil
+}
+
+func (f *FakeS3) CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "CreateMultipartUpload")
+	uploadId := "upload-" + strconv.Itoa(len(f.calls))
+	f.uploads[uploadId] = make(map[int64][]byte)
+	return &s3.CreateMultipartUploadOutput{
+		Bucket:   input.Bucket,
+		Key:      input.Key,
+		UploadId: aws.String(uploadId),
+	}, nil
+}
+
+func (f *FakeS3) UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	content, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "UploadPart")
+	parts, ok := f.uploads[aws.StringValue(input.UploadId)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchUpload, "The specified upload does not exist.", nil)
+	}
+	parts[aws.Int64Value(input.PartNumber)] = content
+	return &s3.UploadPartOutput{ETag: aws.String(etag(content))}, nil
+}
+
+func (f *FakeS3) CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "CompleteMultipartUpload")
+	uploadId := aws.StringValue(input.UploadId)
+	parts, ok := f.uploads[uploadId]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchUpload, "The specified upload does not exist.", nil)
+	}
+	numbers := make([]int64, 0, len(parts))
+	for number := range parts {
+		numbers = append(numbers, number)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	content := make([]byte, 0)
+	for _, number := range numbers {
+		content = append(content, parts[number]...)
+	}
+	delete(f.uploads, uploadId)
+	f.objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)] = content
+	return &s3.CompleteMultipartUploadOutput{
+		Bucket: input.Bucket,
+		Key:    input.Key,
+		ETag:   aws.String(etag(content)),
+	}, nil
+}
+
+func (f *FakeS3) AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "AbortMultipartUpload")
+	delete(f.uploads, aws.StringValue(input.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func etag(content []byte) string {
+	sum := md5.Sum(content)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
@@ -0,0 +1,56 @@
+package lambdatest
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// An in-memory fake of SSM Parameter Store, with parameters keyed by name.
+type FakeSSM struct {
+	ssmiface.SSMAPI
+	Parameters map[string]string
+}
+
+func NewFakeSSM(parameters map[string]string) *FakeSSM {
+	return &FakeSSM{Parameters: parameters}
+}
+
+func (f *FakeSSM) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	value, ok := f.Parameters[aws.StringValue(input.Name)]
+	if !ok {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "Parameter not found: "+aws.StringValue(input.Name), nil)
+	}
+	return &ssm.GetParameterOutput{
+		Parameter: &ssm.Parameter{
+			Name:  input.Name,
+			Type:  aws.String(ssm.ParameterTypeSecureString),
+			Value: aws.String(value),
+		},
+	}, nil
+}
+
+// An in-memory fake of Secrets Manager, with secret strings keyed by name.
+type FakeSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	Secrets map[string]string
+}
+
+func NewFakeSecretsManager(secrets map[string]string) *FakeSecretsManager {
+	return &FakeSecretsManager{Secrets: secrets}
+}
+
+func (f *FakeSecretsManager) GetSecretValue(input *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	value, ok := f.Secrets[aws.StringValue(input.SecretId)]
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException,
+			"Secrets Manager can't find the specified secret.", nil)
+	}
+	return &secretsmanager.GetSecretValueOutput{
+		Name:         input.SecretId,
+		SecretString: aws.String(value),
+	}, nil
+}
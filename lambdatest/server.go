@@ -0,0 +1,368 @@
+package lambdatest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+const (
+	functionsPath       = "/2015-03-31/functions"
+	accountSettingsPath = "/2016-08-19/account-settings"
+	codePath            = "/code/"
+	tagsPath            = "/2017-03-31/tags/"
+	mappingsPath        = "/2015-03-31/event-source-mappings"
+
+	// The concurrency operations are under newer API versions.
+	getConcurrencyPath = "/2019-09-30/functions/"
+	putConcurrencyPath = "/2017-10-31/functions/"
+	concurrencySuffix  = "/concurrency"
+)
+
+// Serves the subset of the Lambda REST API implemented by FakeLambda, so the
+// real SDK client (and the lambdatool binary) can be pointed at it.
+type Handler struct {
+	Fake *FakeLambda
+}
+
+func NewHandler(fake *FakeLambda) *Handler {
+	return &Handler{Fake: fake}
+}
+
+// Starts a server on a local port backed by fake. Use its URL as the
+// endpoint of the lambda client, and Close it when done.
+func NewServer(fake *FakeLambda) *httptest.Server {
+	return httptest.NewServer(NewHandler(fake))
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.EscapedPath(), "/")
+	switch {
+	case path == accountSettingsPath && r.Method == "GET":
+		h.reply(w, http.StatusOK)(h.Fake.GetAccountSettings(&lambda.GetAccountSettingsInput{}))
+	case path == functionsPath && r.Method == "GET":
+		h.listFunctions(w, r)
+	case path == functionsPath && r.Method == "POST":
+		input := &lambda.CreateFunctionInput{}
+		if readBody(w, r, input) {
+			h.reply(w, http.StatusCreated)(h.Fake.CreateFunction(input))
+		}
+	case path == mappingsPath && r.Method == "GET":
+		if maxItems, ok := maxItems(w, r); ok {
+			h.reply(w, http.StatusOK)(h.Fake.ListEventSourceMappings(&lambda.ListEventSourceMappingsInput{
+				FunctionName:   queryString(r, "FunctionName"),
+				EventSourceArn: queryString(r, "EventSourceArn"),
+				Marker:         queryString(r, "Marker"),
+				MaxItems:       maxItems,
+			}))
+		}
+	case path == mappingsPath && r.Method == "POST":
+		input := &lambda.CreateEventSourceMappingInput{}
+		if readBody(w, r, input) {
+			h.reply(w, http.StatusAccepted)(h.Fake.CreateEventSourceMapping(input))
+		}
+	case strings.HasPrefix(path, getConcurrencyPath) && strings.HasSuffix(path, concurrencySuffix) && r.Method == "GET":
+		name := strings.TrimSuffix(strings.TrimPrefix(path, getConcurrencyPath), concurrencySuffix)
+		if name, ok := pathFunctionName(w, name); ok {
+			h.reply(w, http.StatusOK)(h.Fake.GetFunctionConcurrency(&lambda.GetFunctionConcurrencyInput{FunctionName: aws.String(name)}))
+		}
+	case strings.HasPrefix(path, putConcurrencyPath) && strings.HasSuffix(path, concurrencySuffix) && r.Method == "PUT":
+		input := &lambda.PutFunctionConcurrencyInput{}
+		name := strings.TrimSuffix(strings.TrimPrefix(path, putConcurrencyPath), concurrencySuffix)
+		if name, ok := pathFunctionName(w, name); ok && readBody(w, r, input) {
+			input.FunctionName = aws.String(name)
+			h.reply(w, http.StatusOK)(h.Fake.PutFunctionConcurrency(input))
+		}
+	case strings.HasPrefix(path, tagsPath):
+		h.serveTags(w, r, strings.TrimPrefix(path, tagsPath))
+	case strings.HasPrefix(path, codePath) && r.Method == "GET":
+		h.downloadCode(w, r, path)
+	case strings.HasPrefix(path, functionsPath+"/"):
+		h.serveFunction(w, r, strings.Split(strings.TrimPrefix(path, functionsPath+"/"), "/"))
+	default:
+		writeError(w, awserr.New("UnknownOperationException", "Unknown operation "+r.Method+" "+path, nil))
+	}
+}
+
+// Handles /2015-03-31/functions/{FunctionName}[/operation]
+func (h *Handler) serveFunction(w http.ResponseWriter, r *http.Request, parts []string) {
+	name, ok := pathFunctionName(w, parts[0])
+	if !ok {
+		return
+	}
+	operation := ""
+	if len(parts) > 1 {
+		operation = strings.Join(parts[1:], "/")
+	}
+
+	switch {
+	case operation == "" && r.Method == "GET":
+		output, err := h.Fake.GetFunction(&lambda.GetFunctionInput{
+			FunctionName: aws.String(name),
+			Qualifier:    queryString(r, "Qualifier"),
+		})
+		if err == nil && output.Code.Location != nil {
+			// point at this server, which serves the code
+			location := "http://" + r.Host + codePath + strings.TrimPrefix(*output.Code.Location, codeURL)
+			output.Code.Location = aws.String(location)
+		}
+		h.reply(w, http.StatusOK)(output, err)
+	case operation == "" && r.Method == "DELETE":
+		_, err := h.Fake.DeleteFunction(&lambda.DeleteFunctionInput{
+			FunctionName: aws.String(name),
+			Qualifier:    queryString(r, "Qualifier"),
+		})
+		h.reply(w, http.StatusNoContent)(nil, err)
+	case operation == "code" && r.Method == "PUT":
+		input := &lambda.UpdateFunctionCodeInput{}
+		if readBody(w, r, input) {
+			input.FunctionName = aws.String(name)
+			h.reply(w, http.StatusOK)(h.Fake.UpdateFunctionCode(input))
+		}
+	case operation == "configuration" && r.Method == "GET":
+		h.reply(w, http.StatusOK)(h.Fake.GetFunctionConfiguration(&lambda.GetFunctionConfigurationInput{
+			FunctionName: aws.String(name),
+			Qualifier:    queryString(r, "Qualifier"),
+		}))
+	case operation == "configuration" && r.Method == "PUT":
+		input := &lambda.UpdateFunctionConfigurationInput{}
+		if readBody(w, r, input) {
+			input.FunctionName = aws.String(name)
+			h.reply(w, http.StatusOK)(h.Fake.UpdateFunctionConfiguration(input))
+		}
+	case operation == "policy" && r.Method == "GET":
+		h.reply(w, http.StatusOK)(h.Fake.GetPolicy(&lambda.GetPolicyInput{
+			FunctionName: aws.String(name),
+			Qualifier:    queryString(r, "Qualifier"),
+		}))
+	case operation == "policy" && r.Method == "POST":
+		input := &lambda.AddPermissionInput{}
+		if readBody(w, r, input) {
+			input.FunctionName = aws.String(name)
+			h.reply(w, http.StatusCreated)(h.Fake.AddPermission(input))
+		}
+	case operation == "invocations" && r.Method == "POST":
+		h.invoke(w, r, name)
+	case operation == "versions" && r.Method == "GET":
+		if maxItems, ok := maxItems(w, r); ok {
+			h.reply(w, http.StatusOK)(h.Fake.ListVersionsByFunction(&lambda.ListVersionsByFunctionInput{
+				FunctionName: aws.String(name),
+				Marker:       queryString(r, "Marker"),
+				MaxItems:     maxItems,
+			}))
+		}
+	case operation == "versions" && r.Method == "POST":
+		input := &lambda.PublishVersionInput{}
+		if readBody(w, r, input) {
+			input.FunctionName = aws.String(name)
+			h.reply(w, http.StatusCreated)(h.Fake.PublishVersion(input))
+		}
+	case operation == "aliases" && r.Method == "GET":
+		if maxItems, ok := maxItems(w, r); ok {
+			h.reply(w, http.StatusOK)(h.Fake.ListAliases(&lambda.ListAliasesInput{
+				FunctionName:    aws.String(name),
+				FunctionVersion: queryString(r, "FunctionVersion"),
+				Marker:          queryString(r, "Marker"),
+				MaxItems:        maxItems,
+			}))
+		}
+	case operation == "aliases" && r.Method == "POST":
+		input := &lambda.CreateAliasInput{}
+		if readBody(w, r, input) {
+			input.FunctionName = aws.String(name)
+			h.reply(w, http.StatusCreated)(h.Fake.CreateAlias(input))
+		}
+	case len(parts) == 3 && parts[1] == "aliases" && r.Method == "GET":
+		h.reply(w, http.StatusOK)(h.Fake.GetAlias(&lambda.GetAliasInput{
+			FunctionName: aws.String(name),
+			Name:         aws.String(parts[2]),
+		}))
+	case len(parts) == 3 && parts[1] == "aliases" && r.Method == "PUT":
+		input := &lambda.UpdateAliasInput{}
+		if readBody(w, r, input) {
+			input.FunctionName = aws.String(name)
+			input.Name = aws.String(parts[2])
+			h.reply(w, http.StatusOK)(h.Fake.UpdateAlias(input))
+		}
+	default:
+		writeError(w, awserr.New("UnknownOperationException", "Unknown operation "+r.Method+" "+r.URL.Path, nil))
+	}
+}
+
+// Unescapes the function name in a path, which may be given as an ARN.
+func pathFunctionName(w http.ResponseWriter, escapedName string) (string, bool) {
+	name, err := url.PathUnescape(escapedName)
+	if err != nil {
+		writeError(w, awserr.New(lambda.ErrCodeInvalidParameterValueException, err.Error(), nil))
+		return "", false
+	}
+	return functionNameFromArn(name), true
+}
+
+// Handles /2017-03-31/tags/{ARN}
+func (h *Handler) serveTags(w http.ResponseWriter, r *http.Request, escapedArn string) {
+	arn, err := url.PathUnescape(escapedArn)
+	if err != nil {
+		writeError(w, awserr.New(lambda.ErrCodeInvalidParameterValueException, err.Error(), nil))
+		return
+	}
+	switch r.Method {
+	case "GET":
+		h.reply(w, http.StatusOK)(h.Fake.ListTags(&lambda.ListTagsInput{Resource: aws.String(arn)}))
+	case "POST":
+		input := &lambda.TagResourceInput{}
+		if readBody(w, r, input) {
+			input.Resource = aws.String(arn)
+			_, err := h.Fake.TagResource(input)
+			h.reply(w, http.StatusNoContent)(nil, err)
+		}
+	case "DELETE":
+		_, err := h.Fake.UntagResource(&lambda.UntagResourceInput{
+			Resource: aws.String(arn),
+			TagKeys:  aws.StringSlice(r.URL.Query()["tagKeys"]),
+		})
+		h.reply(w, http.StatusNoContent)(nil, err)
+	default:
+		writeError(w, awserr.New("UnknownOperationException", "Unknown operation "+r.Method+" "+r.URL.Path, nil))
+	}
+}
+
+// Serves the zip of /code/{FunctionName}/{Version}
+func (h *Handler) downloadCode(w http.ResponseWriter, r *http.Request, path string) {
+	code, err := h.Fake.Download(codeURL + strings.TrimPrefix(path, codePath))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Write(code)
+}
+
+func (h *Handler) listFunctions(w http.ResponseWriter, r *http.Request) {
+	if maxItems, ok := maxItems(w, r); ok {
+		h.reply(w, http.StatusOK)(h.Fake.ListFunctions(&lambda.ListFunctionsInput{
+			Marker:   queryString(r, "Marker"),
+			MaxItems: maxItems,
+		}))
+	}
+}
+
+// Parses the MaxItems query parameter of list operations, writing an error
+// when it is not a number.
+func maxItems(w http.ResponseWriter, r *http.Request) (*int64, bool) {
+	maxItems := r.URL.Query().Get("MaxItems")
+	if maxItems == "" {
+		return nil, true
+	}
+	n, err := strconv.ParseInt(maxItems, 10, 64)
+	if err != nil {
+		writeError(w, awserr.New(lambda.ErrCodeInvalidParameterValueException, "Invalid MaxItems: "+maxItems, nil))
+		return nil, false
+	}
+	return aws.Int64(n), true
+}
+
+func (h *Handler) invoke(w http.ResponseWriter, r *http.Request, name string) {
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	input := &lambda.InvokeInput{
+		FunctionName: aws.String(name),
+		Qualifier:    queryString(r, "Qualifier"),
+		Payload:      payload,
+	}
+	if invocationType := r.Header.Get("X-Amz-Invocation-Type"); invocationType != "" {
+		input.InvocationType = aws.String(invocationType)
+	}
+	output, err := h.Fake.Invoke(input)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if output.FunctionError != nil {
+		w.Header().Set("X-Amz-Function-Error", *output.FunctionError)
+	}
+	if output.ExecutedVersion != nil {
+		w.Header().Set("X-Amz-Executed-Version", *output.ExecutedVersion)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(int(aws.Int64Value(output.StatusCode)))
+	w.Write(output.Payload)
+}
+
+// Returns a function that writes the result of a fake operation as the
+// response, so calls can be written as h.reply(w, status)(fake.Op(input)).
+func (h *Handler) reply(w http.ResponseWriter, status int) func(interface{}, error) {
+	return func(output interface{}, err error) {
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if output != nil && status != http.StatusNoContent {
+			json.NewEncoder(w).Encode(output)
+		}
+	}
+}
+
+func readBody(w http.ResponseWriter, r *http.Request, input interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(input); err != nil {
+		writeError(w, awserr.New(lambda.ErrCodeInvalidRequestContentException, "Could not parse request body: "+err.Error(), nil))
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := lambda.ErrCodeServiceException
+	if aerr, ok := err.(awserr.Error); ok {
+		code = aerr.Code()
+	}
+	status := http.StatusInternalServerError
+	switch code {
+	case lambda.ErrCodeResourceNotFoundException, "UnknownOperationException":
+		status = http.StatusNotFound
+	case lambda.ErrCodeResourceConflictException:
+		status = http.StatusConflict
+	case lambda.ErrCodeInvalidParameterValueException, lambda.ErrCodeInvalidRequestContentException, request.InvalidParameterErrCode:
+		status = http.StatusBadRequest
+	}
+	message := err.Error()
+	if aerr, ok := err.(awserr.Error); ok {
+		message = aerr.Message()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Amzn-Errortype", code)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"Type": "User", "message": message})
+}
+
+func queryString(r *http.Request, name string) *string {
+	if value := r.URL.Query().Get(name); value != "" {
+		return aws.String(value)
+	}
+	return nil
+}
+
+// Function names in the path may be given as a full or partial ARN.
+func functionNameFromArn(name string) string {
+	if i := strings.LastIndex(name, "function:"); i >= 0 {
+		name = name[i+len("function:"):]
+	}
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
@@ -0,0 +1,206 @@
+package lambdatest_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/pbthorste/aws-lambda-tool"
+	"github.com/pbthorste/aws-lambda-tool/lambdatest"
+	"github.com/stretchr/testify/assert"
+)
+
+const testZip = "../testdata/test1/python_hello.zip"
+
+func newClient(t *testing.T) (*lambda.Lambda, *lambdatest.FakeLambda, func()) {
+	fake := lambdatest.NewFakeLambda()
+	server := lambdatest.NewServer(fake)
+	sess, err := session.NewSession(aws.NewConfig().
+		WithEndpoint(server.URL).
+		WithRegion(lambdatest.FakeRegion).
+		WithCredentials(credentials.NewStaticCredentials("fake", "fake", "")))
+	assert.NoError(t, err)
+	return lambda.New(sess), fake, server.Close
+}
+
+func loadDescriptor(t *testing.T) *lambda_deploy.LambdaFunctionDesc {
+	lambdaDesc, err := lambda_deploy.LoadDescriptorFile("../testdata/test1/lambda-desc.yml")
+	assert.NoError(t, err)
+	return lambdaDesc.Lambda
+}
+
+func TestServerDeployListDelete(t *testing.T) {
+	client, fake, closeServer := newClient(t)
+	defer closeServer()
+	lambdaDesc := loadDescriptor(t)
+
+	assert.NoError(t, lambda_deploy.LambdaDeploy(client, testZip, lambdaDesc))
+	result, err := client.GetFunction(&lambda.GetFunctionInput{FunctionName: aws.String("python-hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, lambda_deploy.Base64sha256(testZip), *result.Configuration.CodeSha256)
+	assert.Equal(t, "yolatengo", *result.Configuration.Environment.Variables["envVar"])
+
+	plan, err := lambda_deploy.PlanDeploy(client, testZip, lambdaDesc)
+	assert.NoError(t, err)
+	assert.False(t, plan.HasChanges())
+
+	lambdaDesc.Timeout = 30
+	assert.NoError(t, lambda_deploy.LambdaDeploy(client, testZip, lambdaDesc))
+	assert.Equal(t, []string{"GetFunction", "CreateFunction", "GetFunctionConfiguration", "GetFunction", "GetFunction", "GetFunction", "UpdateFunctionConfiguration", "GetFunctionConfiguration"}, fake.Calls())
+
+	list, err := lambda_deploy.ListLambdas(client)
+	assert.NoError(t, err)
+	assert.Contains(t, list, "python-hello")
+
+	assert.NoError(t, lambda_deploy.DeleteLambda(client, "python-hello"))
+	err = lambda_deploy.DeleteLambda(client, "python-hello")
+	_, ok := err.(*lambda_deploy.FunctionNotFoundError)
+	assert.True(t, ok, "should be a not found error")
+}
+
+func TestServerInvoke(t *testing.T) {
+	client, fake, closeServer := newClient(t)
+	defer closeServer()
+	assert.NoError(t, lambda_deploy.LambdaDeploy(client, testZip, loadDescriptor(t)))
+	fake.Handlers["python-hello"] = func(payload []byte) ([]byte, error) {
+		return []byte(`"hello"`), nil
+	}
+
+	output, err := lambda_deploy.InvokeLambda(client, "python-hello", `{}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `"hello"`, output)
+
+	_, err = lambda_deploy.InvokeLambda(client, "missing", `{}`)
+	_, ok := err.(*lambda_deploy.FunctionNotFoundError)
+	assert.True(t, ok, "should be a not found error")
+}
+
+func TestServerDownloadCode(t *testing.T) {
+	client, _, closeServer := newClient(t)
+	defer closeServer()
+	assert.NoError(t, lambda_deploy.LambdaDeploy(client, testZip, loadDescriptor(t)))
+	function, err := client.GetFunction(&lambda.GetFunctionInput{FunctionName: aws.String("python-hello")})
+	assert.NoError(t, err)
+	resp, err := http.Get(*function.Code.Location)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	code, _ := ioutil.ReadAll(resp.Body)
+	zip, _ := ioutil.ReadFile(testZip)
+	assert.Equal(t, zip, code)
+}
+
+func TestServerTags(t *testing.T) {
+	client, _, closeServer := newClient(t)
+	defer closeServer()
+	assert.NoError(t, lambda_deploy.LambdaDeploy(client, testZip, loadDescriptor(t)))
+	arn := aws.String(lambdatest.FunctionArn("python-hello"))
+	_, err := client.TagResource(&lambda.TagResourceInput{Resource: arn, Tags: aws.StringMap(map[string]string{"team": "a", "env": "dev"})})
+	assert.NoError(t, err)
+	_, err = client.UntagResource(&lambda.UntagResourceInput{Resource: arn, TagKeys: aws.StringSlice([]string{"env"})})
+	assert.NoError(t, err)
+	tags, err := client.ListTags(&lambda.ListTagsInput{Resource: arn})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "a"}, aws.StringValueMap(tags.Tags))
+}
+
+func TestServerListPages(t *testing.T) {
+	client, _, closeServer := newClient(t)
+	defer closeServer()
+	for _, name := range []string{"a", "b", "c"} {
+		lambdaDesc := loadDescriptor(t)
+		lambdaDesc.Function_name = name
+		assert.NoError(t, lambda_deploy.LambdaDeploy(client, testZip, lambdaDesc))
+	}
+	names := []string{}
+	err := client.ListFunctionsPages(&lambda.ListFunctionsInput{MaxItems: aws.Int64(2)},
+		func(page *lambda.ListFunctionsOutput, lastPage bool) bool {
+			for _, function := range page.Functions {
+				names = append(names, *function.FunctionName)
+			}
+			return true
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, names)
+}
+
+func TestServerAccountSettings(t *testing.T) {
+	client, _, closeServer := newClient(t)
+	defer closeServer()
+	settings, err := lambda_deploy.LambdaAccountSettings(client)
+	assert.NoError(t, err)
+	assert.Contains(t, settings, "FunctionCount: 0")
+}
+
+func TestServerVersionsAndAliases(t *testing.T) {
+	client, _, closeServer := newClient(t)
+	defer closeServer()
+	lambdaDesc := loadDescriptor(t)
+	lambdaDesc.Publish = true
+	lambdaDesc.Aliases = map[string]*lambda_deploy.LambdaAliasDesc{"live": {Description: "production"}}
+
+	assert.NoError(t, lambda_deploy.LambdaDeploy(client, testZip, lambdaDesc))
+	lambdaDesc.Timeout = 30
+	assert.NoError(t, lambda_deploy.LambdaDeploy(client, testZip, lambdaDesc))
+
+	alias, err := client.GetAlias(&lambda.GetAliasInput{FunctionName: aws.String("python-hello"), Name: aws.String("live")})
+	assert.NoError(t, err)
+	assert.Equal(t, "2", *alias.FunctionVersion)
+	assert.Equal(t, "production", *alias.Description)
+
+	versions, err := lambda_deploy.ListVersions(client, "python-hello")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 2)
+	assert.Equal(t, []string{"live"}, versions[1].Aliases)
+	assert.Equal(t, lambda_deploy.Base64sha256(testZip), versions[1].CodeSha256)
+
+	output, err := client.Invoke(&lambda.InvokeInput{FunctionName: aws.String("python-hello"), Qualifier: aws.String("live"), Payload: []byte(`{}`)})
+	assert.NoError(t, err)
+	assert.Equal(t, "2", *output.ExecutedVersion)
+	_, err = client.Invoke(&lambda.InvokeInput{FunctionName: aws.String("python-hello"), Qualifier: aws.String("3")})
+	assert.Error(t, err)
+}
+
+func TestServerPolicyConcurrencyAndMappings(t *testing.T) {
+	client, _, closeServer := newClient(t)
+	defer closeServer()
+	assert.NoError(t, lambda_deploy.LambdaDeploy(client, testZip, loadDescriptor(t)))
+
+	_, err := client.GetPolicy(&lambda.GetPolicyInput{FunctionName: aws.String("python-hello")})
+	assert.Error(t, err)
+	_, err = client.AddPermission(&lambda.AddPermissionInput{
+		FunctionName: aws.String("python-hello"),
+		StatementId:  aws.String("events"),
+		Action:       aws.String("lambda:InvokeFunction"),
+		Principal:    aws.String("events.amazonaws.com"),
+	})
+	assert.NoError(t, err)
+	policy, err := client.GetPolicy(&lambda.GetPolicyInput{FunctionName: aws.String("python-hello")})
+	assert.NoError(t, err)
+	assert.Contains(t, *policy.Policy, `"Service":"events.amazonaws.com"`)
+
+	concurrency, err := client.GetFunctionConcurrency(&lambda.GetFunctionConcurrencyInput{FunctionName: aws.String("python-hello")})
+	assert.NoError(t, err)
+	assert.Nil(t, concurrency.ReservedConcurrentExecutions)
+	_, err = client.PutFunctionConcurrency(&lambda.PutFunctionConcurrencyInput{
+		FunctionName:                 aws.String("python-hello"),
+		ReservedConcurrentExecutions: aws.Int64(3),
+	})
+	assert.NoError(t, err)
+	concurrency, err = client.GetFunctionConcurrency(&lambda.GetFunctionConcurrencyInput{FunctionName: aws.String("python-hello")})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), *concurrency.ReservedConcurrentExecutions)
+
+	_, err = client.CreateEventSourceMapping(&lambda.CreateEventSourceMappingInput{
+		FunctionName:   aws.String("python-hello"),
+		EventSourceArn: aws.String("arn:aws:sqs:us-east-1:123456789012:orders"),
+	})
+	assert.NoError(t, err)
+	mappings, err := client.ListEventSourceMappings(&lambda.ListEventSourceMappingsInput{FunctionName: aws.String("python-hello")})
+	assert.NoError(t, err)
+	assert.Len(t, mappings.EventSourceMappings, 1)
+	assert.Equal(t, "Enabled", *mappings.EventSourceMappings[0].State)
+}
@@ -0,0 +1,96 @@
+package lambdatest
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// The limits Lambda enforces on the tags of a function.
+const (
+	maxTags          = 50
+	maxTagValueChars = 256
+)
+
+func (fn *fakeFunction) setTags(tags map[string]*string) error {
+	if fn.tags == nil {
+		fn.tags = make(map[string]*string)
+	}
+	for key, value := range tags {
+		if len(aws.StringValue(value)) > maxTagValueChars {
+			return awserr.New(lambda.ErrCodeInvalidParameterValueException,
+				"Tag value of "+key+" is longer than "+strconv.Itoa(maxTagValueChars)+" characters", nil)
+		}
+	}
+	merged := 0
+	for key := range tags {
+		if _, ok := fn.tags[key]; !ok {
+			merged++
+		}
+	}
+	if len(fn.tags)+merged > maxTags {
+		return awserr.New(lambda.ErrCodeInvalidParameterValueException,
+			"A function can have at most "+strconv.Itoa(maxTags)+" tags", nil)
+	}
+	for key, value := range tags {
+		fn.tags[key] = aws.String(aws.StringValue(value))
+	}
+	return nil
+}
+
+func (f *FakeLambda) TagResource(input *lambda.TagResourceInput) (*lambda.TagResourceOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("TagResource")
+	name := functionNameFromArn(aws.StringValue(input.Resource))
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	if err := fn.setTags(input.Tags); err != nil {
+		return nil, err
+	}
+	return &lambda.TagResourceOutput{}, nil
+}
+
+func (f *FakeLambda) UntagResource(input *lambda.UntagResourceInput) (*lambda.UntagResourceOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("UntagResource")
+	name := functionNameFromArn(aws.StringValue(input.Resource))
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	for _, key := range input.TagKeys {
+		delete(fn.tags, aws.StringValue(key))
+	}
+	return &lambda.UntagResourceOutput{}, nil
+}
+
+func (f *FakeLambda) ListTags(input *lambda.ListTagsInput) (*lambda.ListTagsOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("ListTags")
+	name := functionNameFromArn(aws.StringValue(input.Resource))
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	tags := make(map[string]*string, len(fn.tags))
+	for key, value := range fn.tags {
+		tags[key] = aws.String(*value)
+	}
+	return &lambda.ListTagsOutput{Tags: tags}, nil
+}
@@ -0,0 +1,271 @@
+package lambdatest
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// Publishes a version of the current code and configuration. As on AWS,
+// nothing is published when nothing changed since the last version, and
+// that version is returned instead.
+func (fn *fakeFunction) publish(description string) *lambda.FunctionConfiguration {
+	if !fn.changed && len(fn.versions) > 0 {
+		config := fn.versions[len(fn.versions)-1].config
+		return &config
+	}
+	config := fn.config
+	config.Version = aws.String(strconv.Itoa(len(fn.versions) + 1))
+	config.FunctionArn = aws.String(FunctionArn(*fn.config.FunctionName) + ":" + *config.Version)
+	if description != "" {
+		config.Description = aws.String(description)
+	}
+	fn.versions = append(fn.versions, fakeVersion{config: config, code: fn.code})
+	fn.changed = false
+	return &config
+}
+
+// Returns the configuration of a version ("$LATEST" or a number) or of the
+// version an alias points to.
+func (fn *fakeFunction) qualified(qualifier string) (*lambda.FunctionConfiguration, []byte, bool) {
+	if qualifier == "" || qualifier == "$LATEST" {
+		config := fn.config
+		return &config, fn.code, true
+	}
+	if alias, ok := fn.aliases[qualifier]; ok {
+		qualifier = aws.StringValue(alias.FunctionVersion)
+	}
+	n, err := strconv.Atoi(qualifier)
+	if err != nil || n < 1 || n > len(fn.versions) {
+		return nil, nil, false
+	}
+	config := fn.versions[n-1].config
+	return &config, fn.versions[n-1].code, true
+}
+
+func (fn *fakeFunction) hasVersion(version string) bool {
+	if version == "$LATEST" {
+		return true
+	}
+	n, err := strconv.Atoi(version)
+	return err == nil && n >= 1 && n <= len(fn.versions)
+}
+
+func aliasArn(functionName, alias string) string {
+	return FunctionArn(functionName) + ":" + alias
+}
+
+func aliasNotFound(functionName, alias string) error {
+	return awserr.New(lambda.ErrCodeResourceNotFoundException,
+		"Alias not found: "+aliasArn(functionName, alias), nil)
+}
+
+func versionNotFound(functionName, version string) error {
+	return awserr.New(lambda.ErrCodeResourceNotFoundException,
+		"Function not found: "+FunctionArn(functionName)+":"+version, nil)
+}
+
+func (f *FakeLambda) PublishVersion(input *lambda.PublishVersionInput) (*lambda.FunctionConfiguration, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("PublishVersion")
+	name := aws.StringValue(input.FunctionName)
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	if fn.pending > 0 {
+		return nil, conflict(name)
+	}
+	if input.CodeSha256 != nil && *input.CodeSha256 != aws.StringValue(fn.config.CodeSha256) {
+		return nil, awserr.New(lambda.ErrCodeInvalidParameterValueException,
+			"CodeSHA256 ("+*input.CodeSha256+") is different from current CodeSHA256 in $LATEST", nil)
+	}
+	return fn.publish(aws.StringValue(input.Description)), nil
+}
+
+// Lists $LATEST followed by the published versions.
+func (f *FakeLambda) ListVersionsByFunction(input *lambda.ListVersionsByFunctionInput) (*lambda.ListVersionsByFunctionOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("ListVersionsByFunction")
+	name := aws.StringValue(input.FunctionName)
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	all := make([]lambda.FunctionConfiguration, 0, len(fn.versions)+1)
+	all = append(all, fn.config)
+	for _, version := range fn.versions {
+		all = append(all, version.config)
+	}
+	start, end, nextMarker, err := page(len(all), input.Marker, f.maxItems(input.MaxItems))
+	if err != nil {
+		return nil, err
+	}
+	output := &lambda.ListVersionsByFunctionOutput{NextMarker: nextMarker}
+	for i := start; i < end; i++ {
+		output.Versions = append(output.Versions, &all[i])
+	}
+	return output, nil
+}
+
+func (f *FakeLambda) GetAlias(input *lambda.GetAliasInput) (*lambda.AliasConfiguration, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("GetAlias")
+	name, aliasName := aws.StringValue(input.FunctionName), aws.StringValue(input.Name)
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	alias, ok := fn.aliases[aliasName]
+	if !ok {
+		return nil, aliasNotFound(name, aliasName)
+	}
+	return copyAlias(alias), nil
+}
+
+func (f *FakeLambda) CreateAlias(input *lambda.CreateAliasInput) (*lambda.AliasConfiguration, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("CreateAlias")
+	name, aliasName := aws.StringValue(input.FunctionName), aws.StringValue(input.Name)
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	if _, ok := fn.aliases[aliasName]; ok {
+		return nil, awserr.New(lambda.ErrCodeResourceConflictException,
+			"Alias already exists: "+aliasArn(name, aliasName), nil)
+	}
+	if err := fn.checkRouting(name, *input.FunctionVersion, input.RoutingConfig); err != nil {
+		return nil, err
+	}
+	if fn.aliases == nil {
+		fn.aliases = make(map[string]*lambda.AliasConfiguration)
+	}
+	fn.aliases[aliasName] = &lambda.AliasConfiguration{
+		AliasArn:        aws.String(aliasArn(name, aliasName)),
+		Name:            aws.String(aliasName),
+		FunctionVersion: input.FunctionVersion,
+		Description:     aws.String(aws.StringValue(input.Description)),
+		RoutingConfig:   input.RoutingConfig,
+	}
+	return copyAlias(fn.aliases[aliasName]), nil
+}
+
+func (f *FakeLambda) UpdateAlias(input *lambda.UpdateAliasInput) (*lambda.AliasConfiguration, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("UpdateAlias")
+	name, aliasName := aws.StringValue(input.FunctionName), aws.StringValue(input.Name)
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	alias, ok := fn.aliases[aliasName]
+	if !ok {
+		return nil, aliasNotFound(name, aliasName)
+	}
+	version := aws.StringValue(alias.FunctionVersion)
+	if input.FunctionVersion != nil {
+		version = *input.FunctionVersion
+	}
+	if err := fn.checkRouting(name, version, input.RoutingConfig); err != nil {
+		return nil, err
+	}
+	alias.FunctionVersion = aws.String(version)
+	if input.Description != nil {
+		alias.Description = input.Description
+	}
+	if input.RoutingConfig != nil {
+		alias.RoutingConfig = input.RoutingConfig
+		if len(input.RoutingConfig.AdditionalVersionWeights) == 0 {
+			alias.RoutingConfig = nil
+		}
+	}
+	return copyAlias(alias), nil
+}
+
+// Lists the aliases of a function sorted by name.
+func (f *FakeLambda) ListAliases(input *lambda.ListAliasesInput) (*lambda.ListAliasesOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("ListAliases")
+	name := aws.StringValue(input.FunctionName)
+	fn, ok := f.functions[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	names := make([]string, 0, len(fn.aliases))
+	for aliasName, alias := range fn.aliases {
+		if input.FunctionVersion == nil || *input.FunctionVersion == aws.StringValue(alias.FunctionVersion) {
+			names = append(names, aliasName)
+		}
+	}
+	sort.Strings(names)
+	start, end, nextMarker, err := page(len(names), input.Marker, f.maxItems(input.MaxItems))
+	if err != nil {
+		return nil, err
+	}
+	output := &lambda.ListAliasesOutput{NextMarker: nextMarker}
+	for _, aliasName := range names[start:end] {
+		output.Aliases = append(output.Aliases, copyAlias(fn.aliases[aliasName]))
+	}
+	return output, nil
+}
+
+// Checks that an alias points to a published version, and that any
+// additional version it routes traffic to exists.
+func (fn *fakeFunction) checkRouting(name, version string, routing *lambda.AliasRoutingConfiguration) error {
+	if version == "$LATEST" || !fn.hasVersion(version) {
+		return versionNotFound(name, version)
+	}
+	if routing == nil {
+		return nil
+	}
+	for weighted, weight := range routing.AdditionalVersionWeights {
+		if !fn.hasVersion(weighted) || weighted == version {
+			return versionNotFound(name, weighted)
+		}
+		if aws.Float64Value(weight) < 0 || aws.Float64Value(weight) > 1 {
+			return awserr.New(lambda.ErrCodeInvalidParameterValueException,
+				"Weight must be between 0.0 and 1.0", nil)
+		}
+	}
+	return nil
+}
+
+func copyAlias(alias *lambda.AliasConfiguration) *lambda.AliasConfiguration {
+	copied := *alias
+	if alias.RoutingConfig != nil {
+		weights := make(map[string]*float64, len(alias.RoutingConfig.AdditionalVersionWeights))
+		for version, weight := range alias.RoutingConfig.AdditionalVersionWeights {
+			weights[version] = aws.Float64(aws.Float64Value(weight))
+		}
+		copied.RoutingConfig = &lambda.AliasRoutingConfiguration{AdditionalVersionWeights: weights}
+	}
+	return &copied
+}
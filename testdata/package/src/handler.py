@@ -0,0 +1,5 @@
+from lib import greeting
+
+
+def handler(event, context):
+    return greeting.hello(event.get("name", "world"))
@@ -0,0 +1,5 @@
+from handler import handler
+
+
+def test_handler():
+    assert handler({}, None) == "Hello, world!"